@@ -23,6 +23,11 @@ func (r Result) ToStarlark() (*starlarkstruct.Struct, error) {
 		msg = *r.Message
 	}
 
+	diff := ""
+	if r.Diff != nil {
+		diff = *r.Diff
+	}
+
 	ret := r.Return
 	if r.Return == nil {
 		ret = starlark.None
@@ -38,6 +43,7 @@ func (r Result) ToStarlark() (*starlarkstruct.Struct, error) {
 		"changed": starlark.Bool(r.Changed),
 		"success": starlark.Bool(r.Success),
 		"message": starlark.String(msg),
+		"diff":    starlark.String(diff),
 		"error":   starlark.String(fmt.Sprint(r.Error)),
 		"return":  ret,
 	}
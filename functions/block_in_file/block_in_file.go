@@ -0,0 +1,280 @@
+package blockinfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/diffutils"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/textfile"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+const defaultMarker = "# {mark} STARCM MANAGED BLOCK"
+
+type blockInFileAction struct {
+	fsys afero.Fs
+}
+
+var _ base.Runnable = (*blockInFileAction)(nil)
+
+type parsedArgs struct {
+	path         string
+	block        string
+	state        string
+	beginMarker  string
+	endMarker    string
+	insertAfter  string
+	insertBefore string
+	create       bool
+	mode         int64
+	whatIf       bool
+}
+
+func (a *blockInFileAction) parseArgs(label string, kwargs []starlark.Tuple) (*parsedArgs, error) {
+	path, err := starlarkhelpers.FindValueinKwargs(kwargs, "path")
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := fileutils.ExpandPath(*path)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "state", "present")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find state in kwargs: %w", err)
+	}
+	if *state != "present" && *state != "absent" {
+		return nil, fmt.Errorf("state must be %q or %q in block_in_file(label=%q), got %q", "present", "absent", label, *state)
+	}
+
+	block, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "block")
+	if err != nil {
+		return nil, err
+	}
+	if *state == "present" && block == nil {
+		return nil, fmt.Errorf("block must be provided to block_in_file(label=%q) when state is %q", label, "present")
+	}
+
+	marker, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "marker", defaultMarker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find marker in kwargs: %w", err)
+	}
+	if !strings.Contains(*marker, "{mark}") {
+		return nil, fmt.Errorf("marker %q must contain {mark}", *marker)
+	}
+	markerBegin, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "marker_begin", "BEGIN")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find marker_begin in kwargs: %w", err)
+	}
+	markerEnd, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "marker_end", "END")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find marker_end in kwargs: %w", err)
+	}
+	if *markerBegin == *markerEnd {
+		return nil, fmt.Errorf("marker_begin and marker_end must differ, both are %q", *markerBegin)
+	}
+
+	insertAfter, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "insert_after")
+	if err != nil {
+		return nil, err
+	}
+	insertBefore, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "insert_before")
+	if err != nil {
+		return nil, err
+	}
+
+	create, err := starlarkhelpers.FindBoolInKwargs(kwargs, "create", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find create in kwargs: %w", err)
+	}
+	mode, err := starlarkhelpers.FindIntInKwargs(kwargs, "mode", 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mode in kwargs: %w", err)
+	}
+	whatIf, err := starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find what_if in kwargs: %w", err)
+	}
+
+	p := &parsedArgs{
+		path:        filePath,
+		state:       *state,
+		beginMarker: strings.ReplaceAll(*marker, "{mark}", *markerBegin),
+		endMarker:   strings.ReplaceAll(*marker, "{mark}", *markerEnd),
+		create:      create,
+		mode:        mode,
+		whatIf:      whatIf,
+	}
+	if block != nil {
+		p.block = *block
+	}
+	if insertAfter != nil {
+		p.insertAfter = *insertAfter
+	}
+	if insertBefore != nil {
+		p.insertBefore = *insertBefore
+	}
+	return p, nil
+}
+
+// findBlock returns the indexes of the begin and end marker lines, or -1 for each if the block is not present.
+func findBlock(doc *textfile.Document, args *parsedArgs) (int, int, error) {
+	begin, end := -1, -1
+	for i, l := range doc.Lines {
+		switch {
+		case l == args.beginMarker && begin == -1:
+			begin = i
+		case l == args.endMarker && begin != -1:
+			end = i
+		}
+		if end != -1 {
+			break
+		}
+	}
+	if begin != -1 && end == -1 {
+		return -1, -1, fmt.Errorf("found %q without matching %q", args.beginMarker, args.endMarker)
+	}
+	return begin, end, nil
+}
+
+func applyBlock(doc *textfile.Document, args *parsedArgs) error {
+	begin, end, err := findBlock(doc, args)
+	if err != nil {
+		return err
+	}
+
+	if args.state == "absent" {
+		if begin != -1 {
+			doc.Lines = append(doc.Lines[:begin], doc.Lines[end+1:]...)
+		}
+		return nil
+	}
+
+	managed := []string{args.beginMarker}
+	if args.block != "" {
+		managed = append(managed, strings.Split(strings.TrimSuffix(args.block, "\n"), "\n")...)
+	}
+	managed = append(managed, args.endMarker)
+
+	if begin != -1 {
+		doc.Lines = append(doc.Lines[:begin], append(managed, doc.Lines[end+1:]...)...)
+		return nil
+	}
+	idx, err := doc.InsertionPoint(args.insertAfter, args.insertBefore)
+	if err != nil {
+		return err
+	}
+	doc.Insert(idx, managed...)
+	return nil
+}
+
+func (a *blockInFileAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	label string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to block_in_file module")
+	}
+	parsed, err := a.parseArgs(label, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := textfile.Read(a.fsys, parsed.path)
+	if err != nil {
+		return nil, err
+	}
+	if !doc.Exists {
+		if parsed.state == "absent" {
+			return &base.Result{
+				Label:   label,
+				Message: func() *string { s := fmt.Sprintf("file %q does not exist", parsed.path); return &s }(),
+				Success: true,
+				Changed: false,
+			}, nil
+		}
+		if !parsed.create {
+			return nil, fmt.Errorf("file %q does not exist and create is false", parsed.path)
+		}
+	}
+
+	before := doc.String()
+	if err := applyBlock(doc, parsed); err != nil {
+		return nil, fmt.Errorf("failed to update block in %q: %w", parsed.path, err)
+	}
+	after := doc.String()
+
+	if doc.Exists && before == after {
+		return &base.Result{
+			Label:   label,
+			Message: func() *string { s := fmt.Sprintf("file %q already in desired state", parsed.path); return &s }(),
+			Success: true,
+			Changed: false,
+		}, nil
+	}
+
+	if !parsed.whatIf {
+		mode := os.FileMode(parsed.mode)
+		if doc.Exists {
+			mode = doc.Mode
+		}
+		if err := textfile.Write(a.fsys, parsed.path, after, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := diffutils.GitDiff(before, after)
+	return &base.Result{
+		Label:   label,
+		Message: func() *string { s := fmt.Sprintf("updated file %q", parsed.path); return &s }(),
+		Success: true,
+		Changed: true,
+		Diff:    &diff,
+	}, nil
+}
+
+func New(ctx context.Context, fsys afero.Fs) *base.Module {
+	var (
+		path         string
+		block        string
+		state        string
+		marker       string
+		markerBegin  string
+		markerEnd    string
+		insertAfter  string
+		insertBefore string
+		create       bool
+		mode         int64
+	)
+
+	return base.NewModule(
+		ctx,
+		"block_in_file",
+		[]base.ArgPair{
+			{Key: "path", Type: &path},
+			{Key: "block??", Type: &block},
+			{Key: "state??", Type: &state},
+			{Key: "marker??", Type: &marker},
+			{Key: "marker_begin??", Type: &markerBegin},
+			{Key: "marker_end??", Type: &markerEnd},
+			{Key: "insert_after??", Type: &insertAfter},
+			{Key: "insert_before??", Type: &insertBefore},
+			{Key: "create??", Type: &create},
+			{Key: "mode??", Type: &mode},
+		},
+		&blockInFileAction{
+			fsys: fsys,
+		},
+	)
+}
@@ -0,0 +1,120 @@
+package blockinfile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+func TestBlockInFileAction_Run(t *testing.T) {
+	const hosts = "127.0.0.1 localhost\n"
+	const managed = "127.0.0.1 localhost\n# BEGIN STARCM MANAGED BLOCK\n10.0.0.1 db\n# END STARCM MANAGED BLOCK\n"
+
+	tests := []struct {
+		name            string
+		files           []FileDefinition
+		kwargs          []starlark.Tuple
+		wantErr         bool
+		expectedChanged bool
+		expectedContent string
+	}{
+		{
+			name:  "appends block",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: hosts}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("block"), starlark.String("10.0.0.1 db\n")},
+			},
+			expectedChanged: true,
+			expectedContent: managed,
+		},
+		{
+			name:  "no change when block matches",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: managed}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("block"), starlark.String("10.0.0.1 db")},
+			},
+			expectedChanged: false,
+			expectedContent: managed,
+		},
+		{
+			name:  "replaces existing block",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: managed + "::1 localhost\n"}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("block"), starlark.String("10.0.0.2 db\n10.0.0.3 cache")},
+			},
+			expectedChanged: true,
+			expectedContent: "127.0.0.1 localhost\n# BEGIN STARCM MANAGED BLOCK\n10.0.0.2 db\n10.0.0.3 cache\n# END STARCM MANAGED BLOCK\n::1 localhost\n",
+		},
+		{
+			name:  "removes block",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: managed}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("state"), starlark.String("absent")},
+			},
+			expectedChanged: true,
+			expectedContent: hosts,
+		},
+		{
+			name:  "custom marker inserted before match",
+			files: []FileDefinition{{Path: "/etc/app.ini", Content: "[main]\n[extra]\n"}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/app.ini")},
+				{starlark.String("block"), starlark.String("key = value")},
+				{starlark.String("marker"), starlark.String("; {mark} app")},
+				{starlark.String("insert_before"), starlark.String(`^\[extra\]`)},
+			},
+			expectedChanged: true,
+			expectedContent: "[main]\n; BEGIN app\nkey = value\n; END app\n[extra]\n",
+		},
+		{
+			name:  "errors on unterminated block",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: "# BEGIN STARCM MANAGED BLOCK\n"}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("block"), starlark.String("10.0.0.1 db")},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "errors on marker without placeholder",
+			files: []FileDefinition{{Path: "/etc/hosts", Content: hosts}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/hosts")},
+				{starlark.String("block"), starlark.String("10.0.0.1 db")},
+				{starlark.String("marker"), starlark.String("# managed")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(tt.files...)
+			action := &blockInFileAction{fsys: fs}
+			thread := starlark.Thread{Name: "test"}
+			result, err := action.Run(context.Background(), "", "block_in_file_test", &thread, nil, tt.kwargs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, result.Success)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+
+			path := string(tt.kwargs[0][1].(starlark.String))
+			b, err := afero.ReadFile(fs, path)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(b))
+		})
+	}
+}
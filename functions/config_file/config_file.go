@@ -0,0 +1,245 @@
+package configfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/diffutils"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/textfile"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+type configFileAction struct {
+	fsys afero.Fs
+}
+
+var _ base.Runnable = (*configFileAction)(nil)
+
+type setting struct {
+	path  []string
+	value any
+}
+
+type parsedArgs struct {
+	path     string
+	format   string
+	merge    map[string]any
+	settings []setting
+	create   bool
+	mode     int64
+	whatIf   bool
+}
+
+func findDict(kwargs []starlark.Tuple, key string) (*starlark.Dict, error) {
+	v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, key)
+	if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
+		return nil, err
+	}
+	if v == nil || v == starlark.None {
+		return nil, nil
+	}
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a dict, got %s", key, v.Type())
+	}
+	return d, nil
+}
+
+func (a *configFileAction) parseArgs(label string, kwargs []starlark.Tuple) (*parsedArgs, error) {
+	path, err := starlarkhelpers.FindValueinKwargs(kwargs, "path")
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := fileutils.ExpandPath(*path)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "format")
+	if err != nil {
+		return nil, err
+	}
+	if format == nil {
+		inferred, err := formatFromPath(filePath)
+		if err != nil {
+			return nil, err
+		}
+		format = &inferred
+	}
+
+	mergeDict, err := findDict(kwargs, "merge")
+	if err != nil {
+		return nil, err
+	}
+	setDict, err := findDict(kwargs, "set")
+	if err != nil {
+		return nil, err
+	}
+	if mergeDict == nil && setDict == nil {
+		return nil, fmt.Errorf("merge or set must be provided to config_file(label=%q)", label)
+	}
+
+	p := &parsedArgs{
+		path:   filePath,
+		format: *format,
+	}
+	if mergeDict != nil {
+		if p.merge, err = starlarkhelpers.DictToGoMap(mergeDict); err != nil {
+			return nil, fmt.Errorf("merge: %w", err)
+		}
+	}
+	if setDict != nil {
+		for _, item := range setDict.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("set keys must be strings, got %s", item[0].Type())
+			}
+			keyPath, err := splitKeyPath(key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := starlarkhelpers.ToGo(item[1])
+			if err != nil {
+				return nil, fmt.Errorf("set %q: %w", key, err)
+			}
+			p.settings = append(p.settings, setting{path: keyPath, value: value})
+		}
+	}
+
+	p.create, err = starlarkhelpers.FindBoolInKwargs(kwargs, "create", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find create in kwargs: %w", err)
+	}
+	p.mode, err = starlarkhelpers.FindIntInKwargs(kwargs, "mode", 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mode in kwargs: %w", err)
+	}
+	p.whatIf, err = starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find what_if in kwargs: %w", err)
+	}
+	return p, nil
+}
+
+func (a *configFileAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	label string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to config_file module")
+	}
+	parsed, err := a.parseArgs(label, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		before []byte
+		exists = true
+		mode   = os.FileMode(parsed.mode)
+	)
+	info, err := a.fsys.Stat(parsed.path)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, fmt.Errorf("%q is a directory, not a file", parsed.path)
+	case err == nil:
+		mode = info.Mode().Perm()
+		before, err = afero.ReadFile(a.fsys, parsed.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %q: %w", parsed.path, err)
+		}
+	case os.IsNotExist(err):
+		if !parsed.create {
+			return nil, fmt.Errorf("file %q does not exist and create is false", parsed.path)
+		}
+		exists = false
+	default:
+		return nil, fmt.Errorf("failed to stat file %q: %w", parsed.path, err)
+	}
+
+	doc, err := parseDocument(parsed.format, before)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", parsed.path, err)
+	}
+	if parsed.merge != nil {
+		if err := doc.merge(parsed.merge); err != nil {
+			return nil, fmt.Errorf("failed to merge into %q: %w", parsed.path, err)
+		}
+	}
+	for _, s := range parsed.settings {
+		if err := doc.set(s.path, s.value); err != nil {
+			return nil, fmt.Errorf("failed to set value in %q: %w", parsed.path, err)
+		}
+	}
+	after, err := doc.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		same, err := semanticEqual(parsed.format, before, after)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return &base.Result{
+				Label:   label,
+				Message: func() *string { s := fmt.Sprintf("config %q already in desired state", parsed.path); return &s }(),
+				Success: true,
+				Changed: false,
+			}, nil
+		}
+	}
+
+	if !parsed.whatIf {
+		if err := textfile.Write(a.fsys, parsed.path, string(after), mode); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := diffutils.GitDiff(string(before), string(after))
+	return &base.Result{
+		Label:   label,
+		Message: func() *string { s := fmt.Sprintf("updated config %q", parsed.path); return &s }(),
+		Success: true,
+		Changed: true,
+		Diff:    &diff,
+	}, nil
+}
+
+func New(ctx context.Context, fsys afero.Fs) *base.Module {
+	var (
+		path   string
+		format string
+		merge  *starlark.Dict
+		set    *starlark.Dict
+		create bool
+		mode   int64
+	)
+
+	return base.NewModule(
+		ctx,
+		"config_file",
+		[]base.ArgPair{
+			{Key: "path", Type: &path},
+			{Key: "format??", Type: &format},
+			{Key: "merge??", Type: &merge},
+			{Key: "set??", Type: &set},
+			{Key: "create??", Type: &create},
+			{Key: "mode??", Type: &mode},
+		},
+		&configFileAction{
+			fsys: fsys,
+		},
+	)
+}
@@ -0,0 +1,258 @@
+package configfile
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+func dict(t *testing.T, kvs ...any) *starlark.Dict {
+	t.Helper()
+	d := starlark.NewDict(len(kvs) / 2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		require.NoError(t, d.SetKey(starlark.String(kvs[i].(string)), kvs[i+1].(starlark.Value)))
+	}
+	return d
+}
+
+func TestConfigFileAction_Run(t *testing.T) {
+	tests := []struct {
+		name            string
+		files           []FileDefinition
+		kwargs          func(t *testing.T) []starlark.Tuple
+		wantErr         bool
+		expectedChanged bool
+		expectedContent string
+	}{
+		{
+			name: "json merge keeps key order and indentation",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{\n    \"name\": \"app\",\n    \"server\": {\n        \"port\": 80\n    }\n}\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("merge"), dict(t, "server", dict(t, "port", starlark.MakeInt(8080), "tls", starlark.True))},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "{\n    \"name\": \"app\",\n    \"server\": {\n        \"port\": 8080,\n        \"tls\": true\n    }\n}\n",
+		},
+		{
+			name: "json unchanged when content is semantically equal",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{\"server\": {\"port\": 8080}}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("set"), dict(t, "server.port", starlark.MakeInt(8080))},
+				}
+			},
+			expectedChanged: false,
+			expectedContent: "{\"server\": {\"port\": 8080}}",
+		},
+		{
+			name: "yaml set keeps comments",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.yaml",
+				Content: "# app config\nlog:\n  level: info # noisy\n  format: json\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.yaml")},
+					{starlark.String("set"), dict(t, "log.level", starlark.String("debug"), "log.outputs.file", starlark.String("/var/log/app.log"))},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "# app config\nlog:\n  level: debug # noisy\n  format: json\n  outputs:\n    file: /var/log/app.log\n",
+		},
+		{
+			name: "toml merge",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.toml",
+				Content: "[server]\nport = 80\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.toml")},
+					{starlark.String("merge"), dict(t, "server", dict(t, "host", starlark.String("0.0.0.0")))},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "[server]\nhost = '0.0.0.0'\nport = 80\n",
+		},
+		{
+			name: "ini set keeps comments",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.ini",
+				Content: "; main settings\n[main]\nenabled = false\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.ini")},
+					{starlark.String("set"), dict(t, "main.enabled", starlark.True)},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "; main settings\n[main]\nenabled = true\n",
+		},
+		{
+			name: "creates missing file",
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/new.json")},
+					{starlark.String("merge"), dict(t, "a", starlark.String("b"))},
+					{starlark.String("create"), starlark.True},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "{\n  \"a\": \"b\"\n}\n",
+		},
+		{
+			name: "errors without merge or set",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "json floats are written as JSON numbers",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{\"ratio\": 1e3}\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("set"), dict(t, "big", starlark.Float(1e21), "half", starlark.Float(0.5))},
+				}
+			},
+			expectedChanged: true,
+			expectedContent: "{\n  \"ratio\": 1e3,\n  \"big\": 1e+21,\n  \"half\": 0.5\n}\n",
+		},
+		{
+			name: "errors on infinite json floats",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("set"), dict(t, "a", starlark.Float(math.Inf(1)))},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "errors on NaN json floats",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("set"), dict(t, "a", starlark.Float(math.NaN()))},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "conf files need an explicit format",
+			files: []FileDefinition{{
+				Path:    "/etc/ssh/sshd_config.conf",
+				Content: "PermitRootLogin no\n",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/ssh/sshd_config.conf")},
+					{starlark.String("set"), dict(t, "a", starlark.True)},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "errors on non-string merge keys",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				merge := starlark.NewDict(1)
+				require.NoError(t, merge.SetKey(starlark.MakeInt(1), starlark.True))
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("merge"), merge},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "errors on values that are not data",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.json",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.json")},
+					{starlark.String("set"), dict(t, "a", starlark.NewBuiltin("f", nil))},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "errors on unknown extension",
+			files: []FileDefinition{{
+				Path:    "/etc/app/config.txt",
+				Content: "{}",
+			}},
+			kwargs: func(t *testing.T) []starlark.Tuple {
+				return []starlark.Tuple{
+					{starlark.String("path"), starlark.String("/etc/app/config.txt")},
+					{starlark.String("set"), dict(t, "a", starlark.True)},
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(tt.files...)
+			action := &configFileAction{fsys: fs}
+			thread := starlark.Thread{Name: "test"}
+			kwargs := tt.kwargs(t)
+			result, err := action.Run(context.Background(), "", "config_file_test", &thread, nil, kwargs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, result.Success)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+
+			path := string(kwargs[0][1].(starlark.String))
+			b, err := afero.ReadFile(fs, path)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(b))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// document is an editable, format-specific representation of a config file.
+// Implementations keep whatever formatting and comments their format allows so that
+// encode only changes what was edited.
+type document interface {
+	// merge deep-merges patch into the document. Maps are merged recursively, everything else is replaced.
+	merge(patch map[string]any) error
+	// set replaces the value at the given key path, creating intermediate maps as needed.
+	set(path []string, value any) error
+	encode() ([]byte, error)
+}
+
+var formatsByExtension = map[string]string{
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".toml": "toml",
+	".ini":  "ini",
+	".cfg":  "ini",
+	// .conf is left out on purpose: nginx, sshd and sysctl files all use it and none of them are INI.
+}
+
+// formatFromPath infers the config format from the file extension.
+func formatFromPath(path string) (string, error) {
+	format, ok := formatsByExtension[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", fmt.Errorf("cannot infer config format from %q, pass format explicitly", path)
+	}
+	return format, nil
+}
+
+func parseDocument(format string, data []byte) (document, error) {
+	switch format {
+	case "json":
+		return parseNodeDocument(data, true)
+	case "yaml":
+		return parseNodeDocument(data, false)
+	case "toml":
+		return parseTOMLDocument(data)
+	case "ini":
+		return parseINIDocument(data)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q, must be one of json, yaml, toml or ini", format)
+	}
+}
+
+// semanticEqual reports whether a and b decode to the same content, ignoring formatting, comments and key order.
+func semanticEqual(format string, a, b []byte) (bool, error) {
+	av, err := decodeSemantic(format, a)
+	if err != nil {
+		return false, err
+	}
+	bv, err := decodeSemantic(format, b)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(av, bv), nil
+}
+
+func decodeSemantic(format string, data []byte) (any, error) {
+	var (
+		v   any
+		err error
+	)
+	switch format {
+	case "json", "yaml":
+		v, err = decodeNodeSemantic(data)
+	case "toml":
+		v, err = decodeTOMLSemantic(data)
+	case "ini":
+		v, err = decodeINISemantic(data)
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalize(v)
+}
+
+// normalize round-trips v through JSON so that values decoded by different libraries
+// (int vs int64 vs float64, typed maps, timestamps) compare equal.
+func normalize(v any) (any, error) {
+	if v == nil {
+		return map[string]any{}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize config content: %w", err)
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("failed to normalize config content: %w", err)
+	}
+	return out, nil
+}
+
+// splitKeyPath splits a dotted key path like "server.tls.port" into its components.
+func splitKeyPath(path string) ([]string, error) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("invalid key path %q", path)
+		}
+	}
+	return parts, nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// deepMerge merges patch into dst. Nested maps are merged, all other values replace what is in dst.
+func deepMerge(dst, patch map[string]any) {
+	for k, v := range patch {
+		pm, ok := v.(map[string]any)
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]any)
+		if !ok {
+			dm = map[string]any{}
+			dst[k] = dm
+		}
+		deepMerge(dm, pm)
+	}
+}
@@ -0,0 +1,111 @@
+package configfile
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/ini.v1"
+)
+
+// iniDocument edits INI files in place, keeping comments and the order of sections and keys.
+// Key paths are "key" for the default section and "section.key" otherwise.
+type iniDocument struct {
+	file *ini.File
+}
+
+var _ document = (*iniDocument)(nil)
+
+func loadINI(data []byte) (*ini.File, error) {
+	f, err := ini.LoadSources(ini.LoadOptions{
+		// Keep values such as "a;b" or "x # y" intact.
+		IgnoreInlineComment: true,
+	}, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return f, nil
+}
+
+func parseINIDocument(data []byte) (*iniDocument, error) {
+	f, err := loadINI(data)
+	if err != nil {
+		return nil, err
+	}
+	return &iniDocument{file: f}, nil
+}
+
+func decodeINISemantic(data []byte) (any, error) {
+	f, err := loadINI(data)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]any{}
+	for _, s := range f.Sections() {
+		keys := map[string]any{}
+		for _, k := range s.Keys() {
+			keys[k.Name()] = k.Value()
+		}
+		if len(keys) > 0 {
+			out[s.Name()] = keys
+		}
+	}
+	return out, nil
+}
+
+func iniValue(v any) (string, error) {
+	switch v := v.(type) {
+	case map[string]any, []any:
+		return "", fmt.Errorf("ini values must be scalars, got %T", v)
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+func (d *iniDocument) setKey(section, key string, v any) error {
+	s, err := iniValue(v)
+	if err != nil {
+		return fmt.Errorf("%s.%s: %w", section, key, err)
+	}
+	d.file.Section(section).Key(key).SetValue(s)
+	return nil
+}
+
+func (d *iniDocument) merge(patch map[string]any) error {
+	for _, k := range sortedKeys(patch) {
+		v := patch[k]
+		section, ok := v.(map[string]any)
+		if !ok {
+			if err := d.setKey(ini.DefaultSection, k, v); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, kk := range sortedKeys(section) {
+			if err := d.setKey(k, kk, section[kk]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *iniDocument) set(path []string, value any) error {
+	switch len(path) {
+	case 1:
+		return d.setKey(ini.DefaultSection, path[0], value)
+	case 2:
+		return d.setKey(path[0], path[1], value)
+	default:
+		return fmt.Errorf("ini key paths must be \"key\" or \"section.key\", got %d components", len(path))
+	}
+}
+
+func (d *iniDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := d.file.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode ini: %w", err)
+	}
+	return buf.Bytes(), nil
+}
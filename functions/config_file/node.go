@@ -0,0 +1,297 @@
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeDocument edits YAML and JSON files through a yaml.Node tree, which keeps key order,
+// comments and scalar styles of everything that is not touched. JSON is parsed as YAML
+// (JSON is a subset of YAML 1.2) and written back with a small JSON emitter.
+type nodeDocument struct {
+	root            *yaml.Node
+	json            bool
+	indent          string
+	trailingNewline bool
+}
+
+var _ document = (*nodeDocument)(nil)
+
+func parseNodeDocument(data []byte, isJSON bool) (*nodeDocument, error) {
+	d := &nodeDocument{
+		json:            isJSON,
+		indent:          detectIndent(data, isJSON),
+		trailingNewline: len(data) == 0 || bytes.HasSuffix(data, []byte("\n")),
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config must contain a single top-level mapping")
+	}
+	d.root = &root
+	return d, nil
+}
+
+// detectIndent returns the indentation of the first indented line, falling back to two spaces.
+func detectIndent(data []byte, isJSON bool) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || len(trimmed) == len(line) {
+			continue
+		}
+		if !isJSON && strings.HasPrefix(trimmed, "- ") {
+			// Sequences may be written without indentation, so they say nothing about the mapping indent.
+			continue
+		}
+		return line[:len(line)-len(trimmed)]
+	}
+	return "  "
+}
+
+func decodeNodeSemantic(data []byte) (any, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return v, nil
+}
+
+func (d *nodeDocument) mapping() *yaml.Node {
+	return d.root.Content[0]
+}
+
+func toNode(v any) (*yaml.Node, error) {
+	n := &yaml.Node{}
+	if err := n.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode value %v: %w", v, err)
+	}
+	return n, nil
+}
+
+// replaceNode swaps old for replacement in place, keeping any comments attached to old.
+func replaceNode(old, replacement *yaml.Node) {
+	replacement.HeadComment = old.HeadComment
+	replacement.LineComment = old.LineComment
+	replacement.FootComment = old.FootComment
+	*old = *replacement
+}
+
+// mappingValue returns the value node for key in mapping m, or nil if it is absent.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func appendMapping(m *yaml.Node, key string, value *yaml.Node) {
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func mergeNode(dst *yaml.Node, patch map[string]any) error {
+	for _, k := range sortedKeys(patch) {
+		v := patch[k]
+		existing := mappingValue(dst, k)
+		if pm, ok := v.(map[string]any); ok && existing != nil && existing.Kind == yaml.MappingNode {
+			if err := mergeNode(existing, pm); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := toNode(v)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			replaceNode(existing, n)
+		} else {
+			appendMapping(dst, k, n)
+		}
+	}
+	return nil
+}
+
+func (d *nodeDocument) merge(patch map[string]any) error {
+	return mergeNode(d.mapping(), patch)
+}
+
+func (d *nodeDocument) set(path []string, value any) error {
+	n, err := toNode(value)
+	if err != nil {
+		return err
+	}
+	cur := d.mapping()
+	for i, key := range path {
+		last := i == len(path)-1
+		switch cur.Kind {
+		case yaml.MappingNode:
+			next := mappingValue(cur, key)
+			switch {
+			case next == nil && last:
+				appendMapping(cur, key, n)
+				return nil
+			case next == nil:
+				next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				appendMapping(cur, key, next)
+			case last:
+				replaceNode(next, n)
+				return nil
+			}
+			cur = next
+		case yaml.SequenceNode:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(cur.Content) {
+				return fmt.Errorf("invalid index %q into list at %q", key, strings.Join(path[:i], "."))
+			}
+			if last {
+				replaceNode(cur.Content[idx], n)
+				return nil
+			}
+			cur = cur.Content[idx]
+		default:
+			return fmt.Errorf("cannot set %q: %q is not a map or list", strings.Join(path, "."), strings.Join(path[:i], "."))
+		}
+	}
+	return nil
+}
+
+func (d *nodeDocument) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if d.json {
+		if err := writeJSONNode(&buf, d.mapping(), d.indent, 0); err != nil {
+			return nil, err
+		}
+		if d.trailingNewline {
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	}
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(len(strings.ReplaceAll(d.indent, "\t", "  ")))
+	if err := enc.Encode(d.root); err != nil {
+		return nil, fmt.Errorf("failed to encode yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode yaml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// json.Encoder always terminates values with a newline.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// writeJSONNode emits n as JSON, preserving the order of mapping keys.
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// writeJSONScalar writes a bool, int or float scalar. Numbers already spelled the JSON way are kept as they
+// are; YAML spellings that JSON does not accept, like "0x1f", "+1.5", ".5" or "True", are normalised, and
+// infinities and NaN, which JSON cannot represent, are an error.
+func writeJSONScalar(buf *bytes.Buffer, n *yaml.Node) error {
+	switch {
+	case n.ShortTag() == "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return err
+		}
+		buf.WriteString(strconv.FormatBool(b))
+	case jsonNumber.MatchString(n.Value):
+		buf.WriteString(n.Value)
+	case n.ShortTag() == "!!int":
+		var i int64
+		if err := n.Decode(&i); err != nil {
+			return fmt.Errorf("cannot write %q at line %d as a JSON number: %w", n.Value, n.Line, err)
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+	default:
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return fmt.Errorf("cannot write %q at line %d as a JSON number: %w", n.Value, n.Line, err)
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("cannot write %q at line %d to JSON, which has no infinity or NaN", n.Value, n.Line)
+		}
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return nil
+}
+
+func writeJSONNode(buf *bytes.Buffer, n *yaml.Node, indent string, depth int) error {
+	pad := strings.Repeat(indent, depth+1)
+	closing := strings.Repeat(indent, depth)
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			buf.WriteString(pad)
+			if err := writeJSONString(buf, n.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := writeJSONNode(buf, n.Content[i+1], indent, depth+1); err != nil {
+				return err
+			}
+			if i+2 < len(n.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(closing + "}")
+	case yaml.SequenceNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range n.Content {
+			buf.WriteString(pad)
+			if err := writeJSONNode(buf, item, indent, depth+1); err != nil {
+				return err
+			}
+			if i+1 < len(n.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(closing + "]")
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			buf.WriteString("null")
+		case "!!bool", "!!int", "!!float":
+			return writeJSONScalar(buf, n)
+		default:
+			return writeJSONString(buf, n.Value)
+		}
+	default:
+		return fmt.Errorf("unsupported node in JSON config at line %d", n.Line)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package configfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// tomlDocument edits TOML files as plain maps. The TOML library does not keep comments,
+// so they are lost when a file is rewritten; files are only rewritten when their content changes.
+type tomlDocument struct {
+	data map[string]any
+}
+
+var _ document = (*tomlDocument)(nil)
+
+func parseTOMLDocument(data []byte) (*tomlDocument, error) {
+	d := &tomlDocument{data: map[string]any{}}
+	if err := toml.Unmarshal(data, &d.data); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return d, nil
+}
+
+func decodeTOMLSemantic(data []byte) (any, error) {
+	v := map[string]any{}
+	if err := toml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return v, nil
+}
+
+func (d *tomlDocument) merge(patch map[string]any) error {
+	deepMerge(d.data, patch)
+	return nil
+}
+
+func (d *tomlDocument) set(path []string, value any) error {
+	var cur any = d.data
+	for i, key := range path {
+		last := i == len(path)-1
+		switch c := cur.(type) {
+		case map[string]any:
+			if last {
+				c[key] = value
+				return nil
+			}
+			next, ok := c[key]
+			if !ok {
+				next = map[string]any{}
+				c[key] = next
+			}
+			cur = next
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(c) {
+				return fmt.Errorf("invalid index %q into list at %q", key, strings.Join(path[:i], "."))
+			}
+			if last {
+				c[idx] = value
+				return nil
+			}
+			cur = c[idx]
+		default:
+			return fmt.Errorf("cannot set %q: %q is not a table or array", strings.Join(path, "."), strings.Join(path[:i], "."))
+		}
+	}
+	return nil
+}
+
+func (d *tomlDocument) encode() ([]byte, error) {
+	b, err := toml.Marshal(d.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode toml: %w", err)
+	}
+	return b, nil
+}
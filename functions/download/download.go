@@ -2,7 +2,6 @@ package download
 
 import (
 	"context"
-	"crypto/sha256"
 	"errors"
 	"os"
 	"time"
@@ -12,40 +11,253 @@ import (
 	"io"
 
 	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
 
 	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/cache"
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/httpclient"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/signature"
 	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/google/deck"
 	"github.com/spf13/afero"
 	"go.starlark.net/starlark"
 )
 
+const (
+	// partSuffix is appended to save_to for in-progress downloads so they can be resumed.
+	partSuffix = ".part"
+
+	defaultRetries    = 3
+	defaultRetryDelay = time.Second
+)
+
 type downloadAction struct {
 	httpClient *http.Client
 	fsys       afero.Fs
 	output     io.Writer
+	// netrcPath locates the netrc file; it defaults to httpclient.NetrcPath.
+	netrcPath func() (string, error)
+	// cache, if set, serves and stores downloads by checksum.
+	cache *cache.Cache
+	// retryDelay is the base delay between retries; it doubles after every failed attempt.
+	retryDelay time.Duration
 }
 
 // Ensure downloadAction implements base.Runnable
 var _ base.Runnable = (*downloadAction)(nil)
 
-func (a *downloadAction) Run(
-	ctx context.Context,
-	workingDirectory string,
-	moduleName string,
-	thread *starlark.Thread,
-	args starlark.Tuple,
-	kwargs []starlark.Tuple,
-) (*base.Result, error) {
-	if a.fsys == nil {
-		return nil, fmt.Errorf("fsys must be provided to download module")
+type parsedArgs struct {
+	urls         []string
+	savePath     string
+	checksum     *checksum.Checksum
+	checksumURL  string
+	signature    *signatureArgs
+	retries      int64
+	liveProgress bool
+	headers      map[string]string
+	auth         *auth
+	netrc        bool
+	// authHost is the host of the first url. Headers and auth are only sent there, never to mirrors or to
+	// checksum and signature urls on other hosts.
+	authHost string
+}
+
+// auth holds the credentials from the auth argument, either {"type": "basic", "username": ..., "password": ...}
+// or {"type": "bearer", "token": ...}.
+type auth struct {
+	kind     string
+	username string
+	password string
+	token    string
+}
+
+// signatureArgs describes a detached signature that must verify before the download is moved into place.
+// Exactly one of publicKey (minisign) and keyring (OpenPGP) is set.
+type signatureArgs struct {
+	url       string
+	publicKey string
+	keyring   string
+}
+
+// retryableError marks failures worth retrying against the same URL, such as 5xx responses and network errors.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func findURLs(kwargs []starlark.Tuple) ([]string, error) {
+	var urls []string
+	url, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "url")
+	if err != nil {
+		return nil, err
+	}
+	if url != nil {
+		urls = append(urls, *url)
+	}
+
+	v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, "urls")
+	if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
+		return nil, err
+	}
+	if v != nil && v != starlark.None {
+		iterable, ok := v.(starlark.Iterable)
+		if !ok {
+			return nil, fmt.Errorf("urls must be a list of strings, got %s", v.Type())
+		}
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var item starlark.Value
+		for iter.Next(&item) {
+			s, ok := starlark.AsString(item)
+			if !ok {
+				return nil, fmt.Errorf("urls must be a list of strings, got element of type %s", item.Type())
+			}
+			urls = append(urls, s)
+		}
+	}
+	return urls, nil
+}
+
+func findStringDict(kwargs []starlark.Tuple, key string) (map[string]string, error) {
+	v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, key)
+	if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
+		return nil, err
+	}
+	if v == nil || v == starlark.None {
+		return nil, nil
+	}
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a dict, got %s", key, v.Type())
+	}
+	m := make(map[string]string, d.Len())
+	for _, item := range d.Items() {
+		k, kok := starlark.AsString(item[0])
+		val, vok := starlark.AsString(item[1])
+		if !kok || !vok {
+			return nil, fmt.Errorf("%s must map strings to strings, got %s: %s", key, item[0].Type(), item[1].Type())
+		}
+		m[k] = val
+	}
+	return m, nil
+}
+
+func parseAuth(m map[string]string) (*auth, error) {
+	if m == nil {
+		return nil, nil
+	}
+	a := &auth{
+		kind:     m["type"],
+		username: m["username"],
+		password: m["password"],
+		token:    m["token"],
+	}
+	switch a.kind {
+	case "basic":
+		if a.username == "" {
+			return nil, fmt.Errorf("auth of type basic requires a username")
+		}
+	case "bearer":
+		if a.token == "" {
+			return nil, fmt.Errorf("auth of type bearer requires a token")
+		}
+	default:
+		return nil, fmt.Errorf("auth type must be %q or %q, got %q", "basic", "bearer", a.kind)
+	}
+	return a, nil
+}
+
+// parseChecksum returns the expected checksum from sha256 or checksum, or the url of a checksums file to look
+// it up in. Exactly one of them must be given, so every download is verified.
+// With allow_unpinned=True none of them is required, and the download is tracked by ETag/Last-Modified instead.
+func parseChecksum(moduleName string, kwargs []starlark.Tuple) (*checksum.Checksum, string, error) {
+	var given []string
+	sha, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "sha256")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find sha256 in kwargs: %v", err)
+	}
+	sum, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "checksum")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find checksum in kwargs: %v", err)
+	}
+	sumURL, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "checksum_url")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find checksum_url in kwargs: %v", err)
+	}
+	for name, v := range map[string]*string{"sha256": sha, "checksum": sum, "checksum_url": sumURL} {
+		if v != nil && *v != "" {
+			given = append(given, name)
+		}
+	}
+	allowUnpinned, err := starlarkhelpers.FindBoolInKwargs(kwargs, "allow_unpinned", false)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find allow_unpinned in kwargs: %w", err)
+	}
+	if len(given) == 0 && allowUnpinned {
+		return nil, "", nil
+	}
+	if len(given) != 1 {
+		return nil, "", fmt.Errorf("exactly one of sha256, checksum or checksum_url must be provided to download(label=%q), or allow_unpinned=True", moduleName)
+	}
+
+	switch given[0] {
+	case "sha256":
+		c, err := checksum.Parse(checksum.SHA256 + ":" + *sha)
+		return &c, "", err
+	case "checksum":
+		c, err := checksum.Parse(*sum)
+		return &c, "", err
+	default:
+		return nil, *sumURL, nil
 	}
+}
 
-	url, err := starlarkhelpers.FindValueinKwargs(kwargs, "url")
+func parseSignature(moduleName string, kwargs []starlark.Tuple) (*signatureArgs, error) {
+	sigURL, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "signature_url")
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "public_key")
 	if err != nil {
 		return nil, err
 	}
-	if url == nil {
-		return nil, fmt.Errorf("url must be provided to download module, cannot be nil")
+	keyring, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "keyring")
+	if err != nil {
+		return nil, err
+	}
+	if sigURL == nil {
+		if publicKey != nil || keyring != nil {
+			return nil, fmt.Errorf("signature_url must be provided with public_key or keyring to download(label=%q)", moduleName)
+		}
+		return nil, nil
+	}
+	if (publicKey == nil) == (keyring == nil) {
+		return nil, fmt.Errorf("exactly one of public_key (minisign) or keyring (OpenPGP) must be provided with signature_url to download(label=%q)", moduleName)
+	}
+	sig := &signatureArgs{url: *sigURL}
+	if publicKey != nil {
+		sig.publicKey = *publicKey
+	}
+	if keyring != nil {
+		sig.keyring = *keyring
+	}
+	return sig, nil
+}
+
+func (a *downloadAction) parseArgs(moduleName string, kwargs []starlark.Tuple) (*parsedArgs, error) {
+	urls, err := findURLs(kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("url or urls must be provided to download(label=%q)", moduleName)
 	}
 
 	savePath, err := starlarkhelpers.FindValueinKwargs(kwargs, "save_to")
@@ -56,12 +268,21 @@ func (a *downloadAction) Run(
 		return nil, fmt.Errorf("save_to must be provided to download(label=%q), cannot be nil", moduleName)
 	}
 
-	expectedHash, err := starlarkhelpers.FindStringInKwargs(kwargs, "sha256")
+	expected, checksumURL, err := parseChecksum(moduleName, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := parseSignature(moduleName, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := starlarkhelpers.FindIntInKwargs(kwargs, "retries", defaultRetries)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find sha256 in kwargs: %v", err)
+		return nil, fmt.Errorf("failed to find retries in kwargs: %w", err)
 	}
-	if expectedHash == nil || *expectedHash == "" {
-		return nil, fmt.Errorf("sha256 must be provided to download(label=%q), cannot be nil/empty", moduleName)
+	if retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative, got %d", retries)
 	}
 
 	liveProgress, err := starlarkhelpers.FindBoolInKwargs(kwargs, "live_progress", false)
@@ -69,155 +290,523 @@ func (a *downloadAction) Run(
 		return nil, fmt.Errorf("failed to find live_progress in kwargs: %w", err)
 	}
 
-	fileSHA256 := func(path string) (string, error) {
-		f, err := a.fsys.Open(path)
+	headers, err := findStringDict(kwargs, "headers")
+	if err != nil {
+		return nil, err
+	}
+	authDict, err := findStringDict(kwargs, "auth")
+	if err != nil {
+		return nil, err
+	}
+	creds, err := parseAuth(authDict)
+	if err != nil {
+		return nil, err
+	}
+	netrc, err := starlarkhelpers.FindBoolInKwargs(kwargs, "netrc", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find netrc in kwargs: %w", err)
+	}
+	if creds != nil && netrc {
+		return nil, fmt.Errorf("auth and netrc cannot both be set in download(label=%q)", moduleName)
+	}
+	primary, err := neturl.Parse(urls[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q in download(label=%q): %w", urls[0], moduleName, err)
+	}
+
+	return &parsedArgs{
+		urls:         urls,
+		savePath:     *savePath,
+		checksum:     expected,
+		checksumURL:  checksumURL,
+		signature:    sig,
+		retries:      retries,
+		liveProgress: liveProgress,
+		headers:      headers,
+		auth:         creds,
+		netrc:        netrc,
+		authHost:     primary.Host,
+	}, nil
+}
+
+func (a *downloadAction) fileChecksum(path, algo string) (checksum.Checksum, error) {
+	f, err := a.fsys.Open(path)
+	if err != nil {
+		return checksum.Checksum{}, err
+	}
+	defer f.Close()
+	return checksum.FromReader(algo, f)
+}
+
+// get fetches a small auxiliary file, such as a checksums file or signature, into memory.
+func (a *downloadAction) get(ctx context.Context, url string, parsed *parsedArgs) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.authorize(req, parsed); err != nil {
+		return nil, err
+	}
+	resp, err := a.do(req, parsed)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %q: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// resolveChecksum looks up the expected checksum in the checksums file, matching on the file name of the
+// first url.
+func (a *downloadAction) resolveChecksum(ctx context.Context, parsed *parsedArgs) error {
+	if parsed.checksum != nil {
+		return nil
+	}
+	sums, err := a.get(ctx, parsed.checksumURL, parsed)
+	if err != nil {
+		return err
+	}
+	u, err := neturl.Parse(parsed.urls[0])
+	if err != nil {
+		return err
+	}
+	c, err := checksum.FromSumsFile(sums, path.Base(u.Path))
+	if err != nil {
+		return fmt.Errorf("checksum_url %q: %w", parsed.checksumURL, err)
+	}
+	parsed.checksum = &c
+	return nil
+}
+
+// verifySignature checks the detached signature of the file at p.
+func (a *downloadAction) verifySignature(p string, sig []byte, sigArgs *signatureArgs) error {
+	f, err := a.fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if sigArgs.publicKey != "" {
+		return signature.VerifyMinisign(sigArgs.publicKey, sig, f)
+	}
+	keyringPath, err := fileutils.ExpandPath(sigArgs.keyring)
+	if err != nil {
+		return err
+	}
+	keyring, err := afero.ReadFile(a.fsys, keyringPath)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring %q: %w", sigArgs.keyring, err)
+	}
+	return signature.VerifyOpenPGP(keyring, sig, f)
+}
+
+func (a *downloadAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	moduleName string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to download module")
+	}
+
+	parsed, err := a.parseArgs(moduleName, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	savePath := parsed.savePath
+	if parsed.checksum == nil && parsed.checksumURL == "" {
+		return a.runUnpinned(ctx, moduleName, parsed)
+	}
+	if err := a.resolveChecksum(ctx, parsed); err != nil {
+		return nil, err
+	}
+
+	if _, err := a.fsys.Stat(savePath); err == nil {
+		existing, err := a.fileChecksum(savePath, parsed.checksum.Algo)
 		if err != nil {
-			return "", err
+			return nil, fmt.Errorf("failed to hash existing file %q: %w", savePath, err)
+		}
+		if existing == *parsed.checksum {
+			return &base.Result{
+				Label: moduleName,
+				Message: func() *string {
+					s := fmt.Sprintf("%q already present and %s verified", savePath, parsed.checksum.Algo)
+					return &s
+				}(),
+				Success: true,
+				Changed: false,
+				Return:  starlark.None,
+			}, nil
 		}
-		defer f.Close()
 
-		h := sha256.New()
-		if _, err := io.Copy(h, f); err != nil {
-			return "", err
+		// Exists but wrong hash: remove and re-download.
+		if err := a.fsys.Remove(savePath); err != nil {
+			return nil, fmt.Errorf("existing file %q has wrong %s; failed to remove: %w", savePath, parsed.checksum.Algo, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat %q: %w", savePath, err)
+	}
+
+	var sig []byte
+	if parsed.signature != nil {
+		if sig, err = a.get(ctx, parsed.signature.url, parsed); err != nil {
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
 		}
-		return fmt.Sprintf("%x", h.Sum(nil)), nil
 	}
 
-	if _, err := a.fsys.Stat(*savePath); err == nil {
-		existingHash, err := fileSHA256(*savePath)
+	if a.cache != nil {
+		restored, err := a.cache.Restore(*parsed.checksum, savePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to hash existing file %q: %w", *savePath, err)
+			logging.Log(moduleName, nil, "warn", "ignoring download cache: %v", err)
 		}
-		if existingHash == *expectedHash {
+		if restored {
+			if sig != nil {
+				if err := a.verifySignature(savePath, sig, parsed.signature); err != nil {
+					_ = a.fsys.Remove(savePath)
+					return nil, fmt.Errorf("signature verification of %q failed: %w", savePath, err)
+				}
+			}
 			return &base.Result{
 				Label: moduleName,
 				Message: func() *string {
-					s := fmt.Sprintf("%q already present and sha256 verified", *savePath)
+					s := fmt.Sprintf("restored %s from cache", savePath)
 					return &s
 				}(),
 				Success: true,
-				Changed: false,
+				Changed: true,
 				Return:  starlark.None,
 			}, nil
 		}
+	}
 
-		// Exists but wrong hash: remove and re-download.
-		if err := a.fsys.Remove(*savePath); err != nil {
-			return nil, fmt.Errorf("existing file %q has wrong sha256; failed to remove: %w", *savePath, err)
+	partPath := savePath + partSuffix
+	var errs []error
+	for _, url := range parsed.urls {
+		_, err := a.fetchVerified(ctx, url, partPath, parsed, nil)
+		if err == nil && sig != nil {
+			// The checksum already matched, so every mirror would serve the same bytes; don't try the others.
+			if err := a.verifySignature(partPath, sig, parsed.signature); err != nil {
+				_ = a.fsys.Remove(partPath)
+				return nil, fmt.Errorf("signature verification of %q failed: %w", savePath, err)
+			}
 		}
+		if err == nil {
+			if err := a.fsys.Rename(partPath, savePath); err != nil {
+				return nil, fmt.Errorf("failed to move %q to %q: %w", partPath, savePath, err)
+			}
+			if a.cache != nil {
+				if err := a.cache.Put(*parsed.checksum, savePath); err != nil {
+					logging.Log(moduleName, nil, "warn", "failed to add %q to download cache: %v", savePath, err)
+				}
+			}
+			return &base.Result{
+				Label: moduleName,
+				Message: func() *string {
+					s := fmt.Sprintf("downloaded file to %s", savePath)
+					return &s
+				}(),
+				Success: true,
+				Changed: true,
+				Return:  starlark.None,
+			}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logging.Log(moduleName, nil, "warn", "download from %q failed: %v", url, err)
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return nil, fmt.Errorf("failed to download %q from any url: %w", savePath, errors.Join(errs...))
+}
+
+// fetchVerified downloads url into partPath, retrying retryable failures, and checks the result against the
+// expected checksum, if there is one. A partial file of a pinned download is kept on failure so that the next
+// attempt or run can resume it; unpinned downloads always start from scratch because the content may have
+// changed in between.
+func (a *downloadAction) fetchVerified(ctx context.Context, url, partPath string, parsed *parsedArgs, validators *metadata) (*fetchResult, error) {
+	var err error
+	for attempt := int64(0); attempt <= parsed.retries; attempt++ {
+		if attempt > 0 {
+			delay := a.retryDelay << (attempt - 1)
+			logging.Log("download", deck.V(2), "info", "retrying %q in %s (attempt %d of %d): %v", url, delay, attempt, parsed.retries, err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		if parsed.checksum == nil {
+			_ = a.fsys.Remove(partPath)
+		}
+		var res *fetchResult
+		res, err = a.fetch(ctx, url, partPath, parsed, validators)
+		if err != nil {
+			var retryable *retryableError
+			if errors.As(err, &retryable) && ctx.Err() == nil {
+				continue
+			}
+			return nil, err
+		}
+		if parsed.checksum == nil {
+			return res, nil
+		}
+
+		actual, hashErr := a.fileChecksum(partPath, parsed.checksum.Algo)
+		if hashErr != nil {
+			return nil, fmt.Errorf("failed to hash %q: %w", partPath, hashErr)
+		}
+		if actual == *parsed.checksum {
+			return res, nil
+		}
+		_ = a.fsys.Remove(partPath)
+		err = fmt.Errorf("expected %s, got %s", parsed.checksum, actual)
+		if !res.resumed {
+			return nil, err
+		}
+		// The partial file may have been left over from different content, so start over from scratch.
+	}
+	return nil, err
+}
+
+// fetchResult describes a completed fetch.
+type fetchResult struct {
+	// resumed reports whether an existing partial file was continued with a Range request.
+	resumed bool
+	// etag and lastModified are the validators sent by the server, used for later conditional requests.
+	etag         string
+	lastModified string
+}
+
+// errNotModified is returned by fetch when a conditional request finds the content unchanged.
+var errNotModified = errors.New("not modified")
+
+// fetch downloads url into partPath, resuming from the end of an existing partial file with a Range request.
+// If validators is set the request is conditional, and errNotModified is returned when the server answers
+// 304 Not Modified.
+func (a *downloadAction) fetch(ctx context.Context, url, partPath string, parsed *parsedArgs, validators *metadata) (*fetchResult, error) {
+	var offset int64
+	if info, err := a.fsys.Stat(partPath); err == nil {
+		offset = info.Size()
 	} else if !errors.Is(err, os.ErrNotExist) {
-		return nil, fmt.Errorf("failed to stat %q: %w", *savePath, err)
+		return nil, fmt.Errorf("failed to stat %q: %w", partPath, err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
+	if err := a.authorize(req, parsed); err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if validators != nil {
+		if validators.ETag != "" {
+			req.Header.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			req.Header.Set("If-Modified-Since", validators.LastModified)
+		}
+	}
 
-	resp, err := a.httpClient.Do(req)
+	resp, err := a.do(req, parsed)
 	if err != nil {
-		return nil, err
+		return nil, &retryableError{err}
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download file %q: %s", *url, resp.Status)
-	}
 
-	totalSize := resp.ContentLength
+	res := &fetchResult{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusNotModified && validators != nil:
+		return nil, errNotModified
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete (or stale); let hash verification decide.
+		res.resumed = true
+		return res, nil
+	case resp.StatusCode == http.StatusOK:
+		offset = 0
+	case resp.StatusCode >= 500:
+		return nil, &retryableError{fmt.Errorf("failed to download file %q: %s", url, resp.Status)}
+	default:
+		return nil, fmt.Errorf("failed to download file %q: %s", url, resp.Status)
+	}
+	res.resumed = offset > 0
+	if res.resumed {
+		logging.Log("download", deck.V(2), "info", "resuming %q at byte %d", url, offset)
+	}
 
-	f, err := a.fsys.Create(*savePath)
+	f, err := a.fsys.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	hasher := sha256.New()
-
-	var dest io.Writer = io.MultiWriter(f, hasher)
-	if liveProgress {
-		pw := &progressWriter{
-			total: totalSize,
-			out:   a.output,
-			name:  *url,
-		}
-		if pw.out == nil {
-			pw.out = io.Discard
+	var (
+		dest io.Writer = f
+		pw   *progressWriter
+	)
+	if parsed.liveProgress {
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
 		}
-		dest = io.MultiWriter(f, hasher, pw)
+		pw = newProgressWriter(a.output, url, offset, total)
+		dest = io.MultiWriter(f, pw)
 	}
 
-	if _, err := io.Copy(dest, resp.Body); err != nil {
-		// Best-effort cleanup of partial download
-		_ = a.fsys.Remove(*savePath)
-		return nil, err
+	_, err = io.Copy(dest, resp.Body)
+	if pw != nil {
+		pw.finish()
 	}
-	if liveProgress && a.output != nil {
-		fmt.Fprintln(a.output)
+	if err != nil {
+		return nil, &retryableError{err}
 	}
+	return res, nil
+}
 
-	actualHash := fmt.Sprintf("%x", hasher.Sum(nil))
-	if *expectedHash != actualHash {
-		_ = a.fsys.Remove(*savePath)
-		return nil, fmt.Errorf("expected sha256 hash %s, got %s", *expectedHash, actualHash)
+// authorize adds the configured headers and credentials to req. Headers and auth are only added for the host
+// of the first url, so that they do not leak to mirrors or checksum and signature hosts run by someone else.
+// Credentials from netrc are looked up per host so that mirrors on different hosts get their own entries.
+func (a *downloadAction) authorize(req *http.Request, parsed *parsedArgs) error {
+	primary := strings.EqualFold(req.URL.Host, parsed.authHost)
+	if !primary && (len(parsed.headers) > 0 || parsed.auth != nil) {
+		logging.Log("download", deck.V(1), "info", "not sending headers or auth to %q, they are only sent to %q", req.URL.Host, parsed.authHost)
 	}
-
-	return &base.Result{
-		Label: moduleName,
-		Message: func() *string {
-			s := fmt.Sprintf("downloaded file to %s", *savePath)
-			return &s
-		}(),
-		Success: true,
-		Changed: true,
-		Return:  starlark.None,
-	}, nil
+	if primary {
+		for k, v := range parsed.headers {
+			req.Header.Set(k, v)
+		}
+	}
+	switch {
+	case parsed.auth != nil && parsed.auth.kind == "basic":
+		if primary {
+			req.SetBasicAuth(parsed.auth.username, parsed.auth.password)
+		}
+	case parsed.auth != nil && parsed.auth.kind == "bearer":
+		if primary {
+			req.Header.Set("Authorization", "Bearer "+parsed.auth.token)
+		}
+	case parsed.netrc:
+		locate := a.netrcPath
+		if locate == nil {
+			locate = httpclient.NetrcPath
+		}
+		netrcPath, err := locate()
+		if err != nil {
+			return err
+		}
+		creds, err := httpclient.LookupNetrc(a.fsys, netrcPath, req.URL.Hostname())
+		if err != nil {
+			return err
+		}
+		if creds != nil {
+			req.SetBasicAuth(creds.Login, creds.Password)
+		}
+	}
+	return nil
 }
 
-type progressWriter struct {
-	name      string
-	total     int64
-	written   int64
-	lastPrint time.Time
-	out       io.Writer
+// do sends req, keeping headers and auth on authHost across redirects. net/http copies every header to the
+// redirected request and only drops Authorization and cookies, so a redirect from authHost to another host
+// would otherwise forward custom headers such as API tokens.
+func (a *downloadAction) do(req *http.Request, parsed *parsedArgs) (*http.Response, error) {
+	client := *a.httpClient
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= 10 {
+			// The limit http.Client applies when CheckRedirect is nil.
+			return errors.New("stopped after 10 redirects")
+		}
+		if !strings.EqualFold(req.URL.Host, parsed.authHost) {
+			for k := range parsed.headers {
+				req.Header.Del(k)
+			}
+			req.Header.Del("Authorization")
+		}
+		return a.authorize(req, parsed)
+	}
+	return client.Do(req)
 }
 
-func (pw *progressWriter) Write(p []byte) (int, error) {
-	n := len(p)
-	pw.written += int64(n)
+// Option configures optional behaviour of the download module.
+type Option func(*downloadAction)
 
-	now := time.Now()
-	if now.Sub(pw.lastPrint) > 500*time.Millisecond || pw.written == pw.total {
-		_, _ = fmt.Fprintf(pw.out, "\rDownloading %s... %d%%", pw.name, (pw.written*100)/pw.total)
-		pw.lastPrint = now
+// WithCache serves downloads from, and adds them to, a content-addressed cache.
+func WithCache(c *cache.Cache) Option {
+	return func(a *downloadAction) {
+		a.cache = c
 	}
-
-	return n, nil
 }
 
-func New(ctx context.Context, httpClient http.Client, fsys afero.Fs, writer io.Writer) *base.Module {
+func New(ctx context.Context, httpClient http.Client, fsys afero.Fs, writer io.Writer, opts ...Option) *base.Module {
 	var (
 		str          string
+		urls         *starlark.List
 		savePath     string
 		sha256       string
+		sum          string
+		sumURL       string
+		signatureURL string
+		publicKey    string
+		keyring      string
+		retries      int64
 		liveProgress bool
+		headers      *starlark.Dict
+		auth         *starlark.Dict
+		netrc        bool
+		unpinned     bool
 	)
 
+	action := &downloadAction{
+		httpClient: &httpClient,
+		fsys:       fsys,
+		output:     writer,
+		retryDelay: defaultRetryDelay,
+		netrcPath:  httpclient.NetrcPath,
+	}
+	for _, opt := range opts {
+		opt(action)
+	}
+
 	return base.NewModule(
 		ctx,
 		"download",
 		[]base.ArgPair{
-			{Key: "url", Type: &str},
+			{Key: "url??", Type: &str},
+			{Key: "urls??", Type: &urls},
 			{Key: "save_to", Type: &savePath},
-			{Key: "sha256", Type: &sha256},
+			{Key: "sha256??", Type: &sha256},
+			{Key: "checksum??", Type: &sum},
+			{Key: "checksum_url??", Type: &sumURL},
+			{Key: "signature_url??", Type: &signatureURL},
+			{Key: "public_key??", Type: &publicKey},
+			{Key: "keyring??", Type: &keyring},
+			{Key: "retries??", Type: &retries},
+			{Key: "headers??", Type: &headers},
+			{Key: "auth??", Type: &auth},
+			{Key: "netrc??", Type: &netrc},
+			{Key: "allow_unpinned??", Type: &unpinned},
 			{
 				Key:  string(starlarkhelpers.OptionalKeyword("live_progress")),
 				Type: &liveProgress,
 			},
 		},
-		&downloadAction{
-			httpClient: &httpClient,
-			fsys:       fsys,
-			output:     writer,
-		},
+		action,
 	)
 }
@@ -0,0 +1,138 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ttyProgressInterval  = 200 * time.Millisecond
+	lineProgressInterval = 5 * time.Second
+)
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// progressWriter reports download progress to out. On a terminal it redraws a single line with
+// carriage returns; otherwise it writes a progress line every lineProgressInterval so that logs stay readable.
+// A negative total means the size is unknown, in which case a spinner and byte count are shown instead of
+// a percentage and ETA.
+type progressWriter struct {
+	name      string
+	total     int64
+	written   int64
+	offset    int64
+	out       io.Writer
+	tty       bool
+	start     time.Time
+	lastPrint time.Time
+	frame     int
+	now       func() time.Time
+}
+
+func newProgressWriter(out io.Writer, name string, offset, total int64) *progressWriter {
+	if out == nil {
+		out = io.Discard
+	}
+	now := time.Now()
+	return &progressWriter{
+		name:    name,
+		total:   total,
+		written: offset,
+		offset:  offset,
+		out:     out,
+		tty:     isTerminal(out),
+		start:   now,
+		now:     time.Now,
+	}
+}
+
+// isTerminal reports whether w is a character device such as an interactive terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.written += int64(len(p))
+
+	interval := lineProgressInterval
+	if pw.tty {
+		interval = ttyProgressInterval
+	}
+	if now := pw.now(); now.Sub(pw.lastPrint) >= interval {
+		pw.print(now)
+		pw.lastPrint = now
+	}
+	return len(p), nil
+}
+
+// finish prints the final state and ends the progress line.
+func (pw *progressWriter) finish() {
+	pw.print(pw.now())
+	if pw.tty {
+		fmt.Fprintln(pw.out)
+	}
+}
+
+func (pw *progressWriter) print(now time.Time) {
+	if pw.tty {
+		// Pad so that a shorter line fully overwrites the previous one.
+		fmt.Fprintf(pw.out, "\r%-80s", pw.status(now))
+		return
+	}
+	fmt.Fprintln(pw.out, pw.status(now))
+}
+
+func (pw *progressWriter) status(now time.Time) string {
+	var rate float64
+	if elapsed := now.Sub(pw.start).Seconds(); elapsed > 0 {
+		rate = float64(pw.written-pw.offset) / elapsed
+	}
+
+	parts := []string{fmt.Sprintf("Downloading %s...", pw.name)}
+	if pw.total < 0 {
+		if pw.tty {
+			parts = append(parts, spinnerFrames[pw.frame%len(spinnerFrames)])
+			pw.frame++
+		}
+		parts = append(parts, formatBytes(pw.written))
+	} else {
+		var percent int64 = 100
+		if pw.total > 0 {
+			percent = pw.written * 100 / pw.total
+		}
+		parts = append(parts, fmt.Sprintf("%d%%", percent), formatBytes(pw.written)+" / "+formatBytes(pw.total))
+	}
+	if rate > 0 {
+		parts = append(parts, formatBytes(int64(rate))+"/s")
+		if pw.total >= 0 && pw.written < pw.total {
+			eta := time.Duration(float64(pw.total-pw.written) / rate * float64(time.Second))
+			parts = append(parts, "ETA "+eta.Round(time.Second).String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatBytes renders n with binary units, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
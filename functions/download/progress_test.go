@@ -0,0 +1,89 @@
+package download
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		tty    bool
+		offset int64
+		total  int64
+		want   []string
+	}{
+		{
+			name:  "known size",
+			total: 4096,
+			want:  []string{"Downloading f... 50% 2.0 KiB / 4.0 KiB 204 B/s ETA 10s", "Downloading f... 100% 4.0 KiB / 4.0 KiB 204 B/s"},
+		},
+		{
+			name:   "resumed download reports rate of new bytes only",
+			offset: 2048,
+			total:  6144,
+			want:   []string{"Downloading f... 66% 4.0 KiB / 6.0 KiB 204 B/s ETA 10s", "Downloading f... 100% 6.0 KiB / 6.0 KiB 204 B/s"},
+		},
+		{
+			name:  "unknown size",
+			total: -1,
+			want:  []string{"Downloading f... 2.0 KiB 204 B/s", "Downloading f... 4.0 KiB 204 B/s"},
+		},
+		{
+			name:  "unknown size on a terminal",
+			tty:   true,
+			total: -1,
+			want:  []string{"Downloading f... | 2.0 KiB 204 B/s", "Downloading f... - 4.0 KiB 204 B/s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			start := time.Unix(0, 0)
+			clock := start
+			pw := newProgressWriter(&out, "f", tt.offset, tt.total)
+			pw.tty = tt.tty
+			pw.start = start
+			pw.lastPrint = start
+			pw.now = func() time.Time { return clock }
+
+			clock = start.Add(10 * time.Second)
+			_, err := pw.Write(make([]byte, 2048))
+			assert.NoError(t, err)
+			// Within the print interval nothing new is printed.
+			_, err = pw.Write(make([]byte, 1024))
+			assert.NoError(t, err)
+			clock = start.Add(20 * time.Second)
+			_, err = pw.Write(make([]byte, 1024))
+			assert.NoError(t, err)
+			pw.finish()
+
+			var lines []string
+			if tt.tty {
+				for _, l := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\r")[1:] {
+					lines = append(lines, strings.TrimRight(l, " "))
+				}
+			} else {
+				lines = strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			}
+			assert.Equal(t, tt.want[0], lines[0])
+			assert.Equal(t, tt.want[1], lines[len(lines)-1])
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	} {
+		assert.Equal(t, want, formatBytes(n))
+	}
+}
@@ -0,0 +1,156 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+// metadataSuffix is appended to save_to for the file that tracks an unpinned download.
+const metadataSuffix = ".starcm-meta.json"
+
+// metadata records where an unpinned download came from and the validators the server sent for it, so that
+// later runs can make conditional requests.
+type metadata struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256"`
+}
+
+func (a *downloadAction) readMetadata(savePath string) *metadata {
+	b, err := afero.ReadFile(a.fsys, savePath+metadataSuffix)
+	if err != nil {
+		return nil
+	}
+	var m metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		logging.Log("download", nil, "warn", "ignoring invalid metadata for %q: %v", savePath, err)
+		return nil
+	}
+	return &m
+}
+
+func (a *downloadAction) writeMetadata(savePath string, m *metadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(a.fsys, savePath+metadataSuffix, append(b, '\n'), 0644)
+}
+
+// runUnpinned downloads a file that has no expected checksum. The server's ETag and Last-Modified are stored
+// beside the file and sent back on the next run, so the result is only Changed when the content changed.
+func (a *downloadAction) runUnpinned(ctx context.Context, moduleName string, parsed *parsedArgs) (*base.Result, error) {
+	savePath := parsed.savePath
+
+	var existing string
+	if _, err := a.fsys.Stat(savePath); err == nil {
+		sum, err := a.fileChecksum(savePath, checksum.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash existing file %q: %w", savePath, err)
+		}
+		existing = sum.Hex
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat %q: %w", savePath, err)
+	}
+	meta := a.readMetadata(savePath)
+	if meta != nil && meta.SHA256 != existing {
+		// The file was changed or removed locally, so the validators no longer describe it.
+		meta = nil
+	}
+
+	var sig []byte
+	if parsed.signature != nil {
+		var err error
+		if sig, err = a.get(ctx, parsed.signature.url, parsed); err != nil {
+			return nil, fmt.Errorf("failed to fetch signature: %w", err)
+		}
+	}
+
+	partPath := savePath + partSuffix
+	var errs []error
+	for _, url := range parsed.urls {
+		var validators *metadata
+		if meta != nil && meta.URL == url {
+			validators = meta
+		}
+		res, err := a.fetchVerified(ctx, url, partPath, parsed, validators)
+		if errors.Is(err, errNotModified) {
+			return &base.Result{
+				Label: moduleName,
+				Message: func() *string {
+					s := fmt.Sprintf("%q not modified on server", savePath)
+					return &s
+				}(),
+				Success: true,
+				Changed: false,
+				Return:  starlark.None,
+			}, nil
+		}
+		if err == nil {
+			return a.finishUnpinned(moduleName, url, partPath, existing, res, sig, parsed)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logging.Log(moduleName, nil, "warn", "download from %q failed: %v", url, err)
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return nil, fmt.Errorf("failed to download %q from any url: %w", savePath, errors.Join(errs...))
+}
+
+// finishUnpinned moves a completed unpinned download into place if its content differs from the existing
+// file, and records the new validators.
+func (a *downloadAction) finishUnpinned(moduleName, url, partPath, existing string, res *fetchResult, sig []byte, parsed *parsedArgs) (*base.Result, error) {
+	savePath := parsed.savePath
+	if sig != nil {
+		if err := a.verifySignature(partPath, sig, parsed.signature); err != nil {
+			_ = a.fsys.Remove(partPath)
+			return nil, fmt.Errorf("signature verification of %q failed: %w", savePath, err)
+		}
+	}
+	sum, err := a.fileChecksum(partPath, checksum.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %q: %w", partPath, err)
+	}
+
+	changed := sum.Hex != existing
+	if changed {
+		if err := a.fsys.Rename(partPath, savePath); err != nil {
+			return nil, fmt.Errorf("failed to move %q to %q: %w", partPath, savePath, err)
+		}
+	} else {
+		_ = a.fsys.Remove(partPath)
+	}
+	if err := a.writeMetadata(savePath, &metadata{
+		URL:          url,
+		ETag:         res.etag,
+		LastModified: res.lastModified,
+		SHA256:       sum.Hex,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to write metadata for %q: %w", savePath, err)
+	}
+
+	return &base.Result{
+		Label: moduleName,
+		Message: func() *string {
+			s := fmt.Sprintf("downloaded file to %s", savePath)
+			if !changed {
+				s = fmt.Sprintf("%q unchanged on server", savePath)
+			}
+			return &s
+		}(),
+		Success: true,
+		Changed: changed,
+		Return:  starlark.None,
+	}, nil
+}
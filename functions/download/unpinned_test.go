@@ -0,0 +1,99 @@
+package download
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.starlark.net/starlark"
+)
+
+func TestRun_Unpinned(t *testing.T) {
+	type server struct {
+		body       string
+		etag       string
+		honorsETag bool
+	}
+	tests := []struct {
+		name        string
+		first       server
+		second      server
+		wantChanged bool
+		wantContent string
+	}{
+		{
+			name:        "304 not modified",
+			first:       server{body: "v1", etag: `"1"`, honorsETag: true},
+			second:      server{body: "v1", etag: `"1"`, honorsETag: true},
+			wantChanged: false,
+			wantContent: "v1",
+		},
+		{
+			name:        "content changed",
+			first:       server{body: "v1", etag: `"1"`, honorsETag: true},
+			second:      server{body: "v2", etag: `"2"`, honorsETag: true},
+			wantChanged: true,
+			wantContent: "v2",
+		},
+		{
+			name:        "server ignores validators but content is identical",
+			first:       server{body: "v1"},
+			second:      server{body: "v1"},
+			wantChanged: false,
+			wantContent: "v1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			current := tt.first
+			action := &downloadAction{
+				fsys: fs,
+				httpClient: clientFunc(func(req *http.Request) (*http.Response, error) {
+					if current.honorsETag && req.Header.Get("If-None-Match") == current.etag {
+						return response(http.StatusNotModified, ""), nil
+					}
+					resp := response(http.StatusOK, current.body)
+					resp.Header = http.Header{}
+					if current.etag != "" {
+						resp.Header.Set("ETag", current.etag)
+					}
+					return resp, nil
+				}),
+			}
+			kwargs := []starlark.Tuple{
+				{starlark.String("url"), starlark.String("https://example.com/ca.pem")},
+				{starlark.String("save_to"), starlark.String("/etc/ca.pem")},
+				{starlark.String("sha256"), starlark.None},
+				{starlark.String("allow_unpinned"), starlark.True},
+			}
+
+			result, err := action.Run(context.TODO(), "", "unpinned", &starlark.Thread{}, nil, kwargs)
+			assert.NoError(t, err)
+			assert.True(t, result.Changed)
+			meta := action.readMetadata("/etc/ca.pem")
+			assert.NotNil(t, meta)
+			assert.Equal(t, tt.first.etag, meta.ETag)
+
+			current = tt.second
+			result, err = action.Run(context.TODO(), "", "unpinned", &starlark.Thread{}, nil, kwargs)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, result.Changed, *result.Message)
+			b, err := afero.ReadFile(fs, "/etc/ca.pem")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantContent, string(b))
+		})
+	}
+}
+
+func TestRun_UnpinnedRequiresOptIn(t *testing.T) {
+	action := &downloadAction{fsys: afero.NewMemMapFs(), httpClient: &http.Client{}}
+	_, err := action.Run(context.TODO(), "", "unpinned", &starlark.Thread{}, nil, []starlark.Tuple{
+		{starlark.String("url"), starlark.String("https://example.com/ca.pem")},
+		{starlark.String("save_to"), starlark.String("/etc/ca.pem")},
+		{starlark.String("sha256"), starlark.None},
+	})
+	assert.ErrorContains(t, err, "allow_unpinned")
+}
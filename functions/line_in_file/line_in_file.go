@@ -0,0 +1,257 @@
+package lineinfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/diffutils"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/textfile"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+type lineInFileAction struct {
+	fsys afero.Fs
+}
+
+var _ base.Runnable = (*lineInFileAction)(nil)
+
+type parsedArgs struct {
+	path         string
+	line         *string
+	state        string
+	regexp       *regexp.Regexp
+	insertAfter  string
+	insertBefore string
+	create       bool
+	mode         int64
+	whatIf       bool
+}
+
+func (a *lineInFileAction) parseArgs(label string, kwargs []starlark.Tuple) (*parsedArgs, error) {
+	path, err := starlarkhelpers.FindValueinKwargs(kwargs, "path")
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := fileutils.ExpandPath(*path)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "state", "present")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find state in kwargs: %w", err)
+	}
+	if *state != "present" && *state != "absent" {
+		return nil, fmt.Errorf("state must be %q or %q in line_in_file(label=%q), got %q", "present", "absent", label, *state)
+	}
+
+	line, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "line")
+	if err != nil {
+		return nil, err
+	}
+	re, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "regexp")
+	if err != nil {
+		return nil, err
+	}
+	if *state == "present" && line == nil {
+		return nil, fmt.Errorf("line must be provided to line_in_file(label=%q) when state is %q", label, "present")
+	}
+	if *state == "absent" && line == nil && re == nil {
+		return nil, fmt.Errorf("line or regexp must be provided to line_in_file(label=%q) when state is %q", label, "absent")
+	}
+
+	var compiled *regexp.Regexp
+	if re != nil {
+		compiled, err = regexp.Compile(*re)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", *re, err)
+		}
+	}
+
+	insertAfter, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "insert_after")
+	if err != nil {
+		return nil, err
+	}
+	insertBefore, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "insert_before")
+	if err != nil {
+		return nil, err
+	}
+
+	create, err := starlarkhelpers.FindBoolInKwargs(kwargs, "create", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find create in kwargs: %w", err)
+	}
+	mode, err := starlarkhelpers.FindIntInKwargs(kwargs, "mode", 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find mode in kwargs: %w", err)
+	}
+	whatIf, err := starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find what_if in kwargs: %w", err)
+	}
+
+	p := &parsedArgs{
+		path:   filePath,
+		line:   line,
+		state:  *state,
+		regexp: compiled,
+		create: create,
+		mode:   mode,
+		whatIf: whatIf,
+	}
+	if insertAfter != nil {
+		p.insertAfter = *insertAfter
+	}
+	if insertBefore != nil {
+		p.insertBefore = *insertBefore
+	}
+	return p, nil
+}
+
+// ensurePresent makes sure args.line is in doc. If a regexp is given, the last matching line is replaced.
+func ensurePresent(doc *textfile.Document, args *parsedArgs) error {
+	if args.regexp != nil {
+		for i := len(doc.Lines) - 1; i >= 0; i-- {
+			if args.regexp.MatchString(doc.Lines[i]) {
+				doc.Lines[i] = *args.line
+				return nil
+			}
+		}
+	}
+	for _, l := range doc.Lines {
+		if l == *args.line {
+			return nil
+		}
+	}
+	idx, err := doc.InsertionPoint(args.insertAfter, args.insertBefore)
+	if err != nil {
+		return err
+	}
+	doc.Insert(idx, *args.line)
+	return nil
+}
+
+// ensureAbsent removes every line matching args.regexp, or equal to args.line if no regexp is given.
+func ensureAbsent(doc *textfile.Document, args *parsedArgs) {
+	kept := doc.Lines[:0]
+	for _, l := range doc.Lines {
+		if args.regexp != nil && args.regexp.MatchString(l) {
+			continue
+		}
+		if args.regexp == nil && l == *args.line {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	doc.Lines = kept
+}
+
+func (a *lineInFileAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	label string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to line_in_file module")
+	}
+	parsed, err := a.parseArgs(label, kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := textfile.Read(a.fsys, parsed.path)
+	if err != nil {
+		return nil, err
+	}
+	if !doc.Exists {
+		if parsed.state == "absent" {
+			return &base.Result{
+				Label:   label,
+				Message: func() *string { s := fmt.Sprintf("file %q does not exist", parsed.path); return &s }(),
+				Success: true,
+				Changed: false,
+			}, nil
+		}
+		if !parsed.create {
+			return nil, fmt.Errorf("file %q does not exist and create is false", parsed.path)
+		}
+	}
+
+	before := doc.String()
+	if parsed.state == "present" {
+		if err := ensurePresent(doc, parsed); err != nil {
+			return nil, err
+		}
+	} else {
+		ensureAbsent(doc, parsed)
+	}
+	after := doc.String()
+
+	if doc.Exists && before == after {
+		return &base.Result{
+			Label:   label,
+			Message: func() *string { s := fmt.Sprintf("file %q already in desired state", parsed.path); return &s }(),
+			Success: true,
+			Changed: false,
+		}, nil
+	}
+
+	if !parsed.whatIf {
+		mode := os.FileMode(parsed.mode)
+		if doc.Exists {
+			mode = doc.Mode
+		}
+		if err := textfile.Write(a.fsys, parsed.path, after, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := diffutils.GitDiff(before, after)
+	return &base.Result{
+		Label:   label,
+		Message: func() *string { s := fmt.Sprintf("updated file %q", parsed.path); return &s }(),
+		Success: true,
+		Changed: true,
+		Diff:    &diff,
+	}, nil
+}
+
+func New(ctx context.Context, fsys afero.Fs) *base.Module {
+	var (
+		path         string
+		line         string
+		state        string
+		re           string
+		insertAfter  string
+		insertBefore string
+		create       bool
+		mode         int64
+	)
+
+	return base.NewModule(
+		ctx,
+		"line_in_file",
+		[]base.ArgPair{
+			{Key: "path", Type: &path},
+			{Key: "line??", Type: &line},
+			{Key: "state??", Type: &state},
+			{Key: "regexp??", Type: &re},
+			{Key: "insert_after??", Type: &insertAfter},
+			{Key: "insert_before??", Type: &insertBefore},
+			{Key: "create??", Type: &create},
+			{Key: "mode??", Type: &mode},
+		},
+		&lineInFileAction{
+			fsys: fsys,
+		},
+	)
+}
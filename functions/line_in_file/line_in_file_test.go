@@ -0,0 +1,133 @@
+package lineinfile
+
+import (
+	"context"
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+func TestLineInFileAction_Run(t *testing.T) {
+	const sshdConfig = "Port 22\n#PermitRootLogin yes\nPasswordAuthentication yes\n"
+
+	tests := []struct {
+		name            string
+		files           []FileDefinition
+		kwargs          []starlark.Tuple
+		wantErr         bool
+		expectedChanged bool
+		expectedContent string
+	}{
+		{
+			name:  "replaces last regexp match",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("regexp"), starlark.String("^#?PermitRootLogin")},
+				{starlark.String("line"), starlark.String("PermitRootLogin no")},
+			},
+			expectedChanged: true,
+			expectedContent: "Port 22\nPermitRootLogin no\nPasswordAuthentication yes\n",
+		},
+		{
+			name:  "no change when line already present",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("line"), starlark.String("Port 22")},
+			},
+			expectedChanged: false,
+			expectedContent: sshdConfig,
+		},
+		{
+			name:  "inserts after last match",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("line"), starlark.String("ListenAddress 0.0.0.0")},
+				{starlark.String("insert_after"), starlark.String("^Port")},
+			},
+			expectedChanged: true,
+			expectedContent: "Port 22\nListenAddress 0.0.0.0\n#PermitRootLogin yes\nPasswordAuthentication yes\n",
+		},
+		{
+			name:  "inserts at beginning of file",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("line"), starlark.String("# managed by starcm")},
+				{starlark.String("insert_before"), starlark.String("BOF")},
+			},
+			expectedChanged: true,
+			expectedContent: "# managed by starcm\n" + sshdConfig,
+		},
+		{
+			name:  "removes matching lines",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("regexp"), starlark.String("^Password")},
+				{starlark.String("state"), starlark.String("absent")},
+			},
+			expectedChanged: true,
+			expectedContent: "Port 22\n#PermitRootLogin yes\n",
+		},
+		{
+			name: "creates missing file when create is true",
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/motd")},
+				{starlark.String("line"), starlark.String("hello")},
+				{starlark.String("create"), starlark.True},
+			},
+			expectedChanged: true,
+			expectedContent: "hello\n",
+		},
+		{
+			name: "errors on missing file without create",
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/motd")},
+				{starlark.String("line"), starlark.String("hello")},
+			},
+			wantErr: true,
+		},
+		{
+			name:  "errors on invalid state",
+			files: []FileDefinition{{Path: "/etc/ssh/sshd_config", Content: sshdConfig}},
+			kwargs: []starlark.Tuple{
+				{starlark.String("path"), starlark.String("/etc/ssh/sshd_config")},
+				{starlark.String("line"), starlark.String("Port 22")},
+				{starlark.String("state"), starlark.String("gone")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(tt.files...)
+			action := &lineInFileAction{fsys: fs}
+			thread := starlark.Thread{Name: "test"}
+			result, err := action.Run(context.Background(), "", "line_in_file_test", &thread, nil, tt.kwargs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, result.Success)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+			if tt.expectedChanged {
+				require.NotNil(t, result.Diff)
+			}
+
+			path := string(tt.kwargs[0][1].(starlark.String))
+			b, err := afero.ReadFile(fs, path)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(b))
+		})
+	}
+}
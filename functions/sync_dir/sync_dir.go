@@ -0,0 +1,516 @@
+package syncdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/sha256"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+type syncDirAction struct {
+	fsys afero.Fs
+}
+
+var _ base.Runnable = (*syncDirAction)(nil)
+
+// change describes a single filesystem change made (or planned, with what_if) by sync_dir.
+type change struct {
+	// op is one of "create", "update", "mode" or "delete".
+	op   string
+	path string
+}
+
+type syncer struct {
+	fsys     afero.Fs
+	src      string
+	dest     string
+	excludes []string
+	purge    bool
+	whatIf   bool
+	changes  []change
+}
+
+func (a *syncDirAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	label string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to sync_dir module")
+	}
+
+	source, err := starlarkhelpers.FindValueinKwargs(kwargs, "source")
+	if err != nil {
+		return nil, err
+	}
+	destination, err := starlarkhelpers.FindValueinKwargs(kwargs, "destination")
+	if err != nil {
+		return nil, err
+	}
+
+	// source is resolved relative to the calling file, like template paths.
+	srcRoot := *source
+	if !filepath.IsAbs(srcRoot) {
+		srcRoot = filepath.Join(workingDirectory, srcRoot)
+	}
+	destRoot, err := fileutils.ExpandPath(*destination)
+	if err != nil {
+		return nil, err
+	}
+
+	purge, err := starlarkhelpers.FindBoolInKwargs(kwargs, "purge", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find purge in kwargs: %w", err)
+	}
+	whatIf, err := starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find what_if in kwargs: %w", err)
+	}
+	excludes, err := findExcludes(kwargs)
+	if err != nil {
+		return nil, err
+	}
+
+	isDir, err := fileutils.IsDir(a.fsys, srcRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source %q: %w", srcRoot, err)
+	}
+	if !isDir {
+		return nil, fmt.Errorf("source %q must be a directory", srcRoot)
+	}
+
+	s := &syncer{
+		fsys:     a.fsys,
+		src:      srcRoot,
+		dest:     destRoot,
+		excludes: excludes,
+		purge:    purge,
+		whatIf:   whatIf,
+	}
+	if err := s.copyTree(); err != nil {
+		return nil, err
+	}
+	if purge {
+		if err := s.purgeTree(); err != nil {
+			return nil, err
+		}
+	}
+
+	diff := s.diff()
+	if diff != "" {
+		logging.Log(label, deck.V(2), "info", "diff:\n%s", diff)
+	}
+
+	ret, err := s.summary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &base.Result{
+		Label: label,
+		Message: func() *string {
+			s := fmt.Sprintf("synced %q to %q: %d change(s)", srcRoot, destRoot, len(s.changes))
+			return &s
+		}(),
+		Success: true,
+		Changed: len(s.changes) > 0,
+		Diff:    &diff,
+		Return:  ret,
+	}, nil
+}
+
+func findExcludes(kwargs []starlark.Tuple) ([]string, error) {
+	v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, "exclude")
+	if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
+		return nil, err
+	}
+	if v == nil || v == starlark.None {
+		return nil, nil
+	}
+	iterable, ok := v.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("exclude must be a list of glob patterns, got %s", v.Type())
+	}
+	var excludes []string
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var item starlark.Value
+	for iter.Next(&item) {
+		pattern, ok := starlark.AsString(item)
+		if !ok {
+			return nil, fmt.Errorf("exclude patterns must be strings, got %s", item.Type())
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		excludes = append(excludes, pattern)
+	}
+	return excludes, nil
+}
+
+// excluded reports whether rel (a slash-separated path relative to the sync root) matches an exclude pattern.
+// Patterns are matched against both the full relative path and the base name.
+func (s *syncer) excluded(rel string) bool {
+	for _, pattern := range s.excludes {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *syncer) record(op, rel string) {
+	s.changes = append(s.changes, change{op: op, path: rel})
+}
+
+func (s *syncer) copyTree() error {
+	return afero.Walk(s.fsys, s.src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.src, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && s.excluded(rel) {
+			logging.Log("sync_dir", deck.V(3), "info", "excluding %q", rel)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(s.dest, filepath.FromSlash(rel))
+
+		switch {
+		case info.IsDir():
+			return s.syncDir(rel, target, info)
+		case info.Mode().IsRegular():
+			return s.syncFile(p, rel, target, info)
+		case info.Mode()&os.ModeSymlink != 0:
+			return s.syncLink(p, rel, target)
+		default:
+			return fmt.Errorf("unsupported file type %s for %q", info.Mode().Type(), p)
+		}
+	})
+}
+
+func (s *syncer) syncDir(rel, target string, info os.FileInfo) error {
+	existing, err := s.fsys.Stat(target)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat %q: %w", target, err)
+		}
+		s.record("create", rel+"/")
+		if s.whatIf {
+			return nil
+		}
+		if err := s.fsys.MkdirAll(target, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", target, err)
+		}
+		// MkdirAll is subject to umask, so set the mode explicitly.
+		return s.fsys.Chmod(target, info.Mode().Perm())
+	}
+	if !existing.IsDir() {
+		return fmt.Errorf("%q exists and is not a directory", target)
+	}
+	if existing.Mode().Perm() != info.Mode().Perm() {
+		s.record("mode", rel+"/")
+		if s.whatIf {
+			return nil
+		}
+		return s.fsys.Chmod(target, info.Mode().Perm())
+	}
+	return nil
+}
+
+func (s *syncer) hash(p string) (string, error) {
+	f, err := s.fsys.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return sha256.FromReader(f)
+}
+
+// lstat stats p without following a symlink at p, when the filesystem supports symlinks.
+func (s *syncer) lstat(p string) (os.FileInfo, error) {
+	if l, ok := s.fsys.(afero.Lstater); ok {
+		info, _, err := l.LstatIfPossible(p)
+		return info, err
+	}
+	return s.fsys.Stat(p)
+}
+
+func (s *syncer) syncFile(src, rel, target string, info os.FileInfo) error {
+	existing, err := s.lstat(target)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat %q: %w", target, err)
+		}
+		s.record("create", rel)
+		return s.copyFile(src, target, info.Mode().Perm())
+	}
+	if existing.IsDir() {
+		return fmt.Errorf("%q exists and is a directory, not a file", target)
+	}
+	if existing.Mode()&os.ModeSymlink != 0 {
+		// Replace the link rather than writing through it.
+		s.record("update", rel)
+		if s.whatIf {
+			return nil
+		}
+		if err := s.fsys.Remove(target); err != nil {
+			return fmt.Errorf("failed to remove %q: %w", target, err)
+		}
+		return s.copyFile(src, target, info.Mode().Perm())
+	}
+
+	srcHash, err := s.hash(src)
+	if err != nil {
+		return fmt.Errorf("failed to hash %q: %w", src, err)
+	}
+	destHash, err := s.hash(target)
+	if err != nil {
+		return fmt.Errorf("failed to hash %q: %w", target, err)
+	}
+	if srcHash != destHash {
+		s.record("update", rel)
+		return s.copyFile(src, target, info.Mode().Perm())
+	}
+	if existing.Mode().Perm() != info.Mode().Perm() {
+		s.record("mode", rel)
+		if s.whatIf {
+			return nil
+		}
+		return s.fsys.Chmod(target, info.Mode().Perm())
+	}
+	return nil
+}
+
+// syncLink recreates the symlink src at target with the same link text, so that relative links keep
+// pointing into the synced tree. The link is not followed. Filesystems without symlinks skip it with a warning.
+func (s *syncer) syncLink(src, rel, target string) error {
+	reader, canRead := s.fsys.(afero.LinkReader)
+	linker, canLink := s.fsys.(afero.Linker)
+	if !canRead || !canLink {
+		logging.Log("sync_dir", nil, "warn", "skipping symlink %q: the filesystem does not support symlinks", src)
+		return nil
+	}
+	link, err := reader.ReadlinkIfPossible(src)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %q: %w", src, err)
+	}
+
+	existing, err := s.lstat(target)
+	exists := err == nil
+	switch {
+	case os.IsNotExist(err):
+		s.record("create", rel)
+	case err != nil:
+		return fmt.Errorf("failed to stat %q: %w", target, err)
+	case existing.IsDir():
+		return fmt.Errorf("%q exists and is a directory, not a symlink", target)
+	case existing.Mode()&os.ModeSymlink != 0:
+		current, err := reader.ReadlinkIfPossible(target)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %q: %w", target, err)
+		}
+		if current == link {
+			return nil
+		}
+		s.record("update", rel)
+	default:
+		s.record("update", rel)
+	}
+	if s.whatIf {
+		return nil
+	}
+	if exists {
+		if err := s.fsys.Remove(target); err != nil {
+			return fmt.Errorf("failed to remove %q: %w", target, err)
+		}
+	}
+	if err := linker.SymlinkIfPossible(link, target); err != nil {
+		return fmt.Errorf("failed to create symlink %q: %w", target, err)
+	}
+	return nil
+}
+
+func (s *syncer) copyFile(src, target string, mode os.FileMode) error {
+	if s.whatIf {
+		return nil
+	}
+	in, err := s.fsys.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := s.fsys.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to open %q for writing: %w", target, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy %q to %q: %w", src, target, err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %q: %w", target, err)
+	}
+	// OpenFile only applies mode on creation, so set it explicitly for existing files.
+	return s.fsys.Chmod(target, mode)
+}
+
+// purgeTree removes everything under dest that has no counterpart in src, leaving excluded paths alone.
+func (s *syncer) purgeTree() error {
+	if _, err := s.fsys.Stat(s.dest); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var unmanaged []string
+	err := afero.Walk(s.fsys, s.dest, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dest, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if s.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// A dangling symlink in src still counts as a counterpart, so lstat rather than stat.
+		if _, err := s.lstat(filepath.Join(s.src, filepath.FromSlash(rel))); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		unmanaged = append(unmanaged, p)
+		if info.IsDir() {
+			s.record("delete", rel+"/")
+			return filepath.SkipDir
+		}
+		s.record("delete", rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if s.whatIf {
+		return nil
+	}
+	for _, p := range unmanaged {
+		if err := s.fsys.RemoveAll(p); err != nil {
+			return fmt.Errorf("failed to remove %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// diff renders one line per changed path, prefixed like diffutils.GitDiff output.
+func (s *syncer) diff() string {
+	var b strings.Builder
+	for _, c := range s.changes {
+		prefix := "~ "
+		switch c.op {
+		case "create":
+			prefix = "+ "
+		case "delete":
+			prefix = "- "
+		}
+		fmt.Fprintf(&b, "%s%s\n", prefix, c.path)
+	}
+	return b.String()
+}
+
+// summary groups changed paths by operation for result.return.
+func (s *syncer) summary() (*starlark.Dict, error) {
+	grouped := map[string][]string{
+		"created":      nil,
+		"updated":      nil,
+		"mode_changed": nil,
+		"deleted":      nil,
+	}
+	keys := map[string]string{
+		"create": "created",
+		"update": "updated",
+		"mode":   "mode_changed",
+		"delete": "deleted",
+	}
+	for _, c := range s.changes {
+		k := keys[c.op]
+		grouped[k] = append(grouped[k], c.path)
+	}
+
+	d := starlark.NewDict(len(grouped))
+	names := make([]string, 0, len(grouped))
+	for k := range grouped {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		elems := make([]starlark.Value, 0, len(grouped[k]))
+		for _, p := range grouped[k] {
+			elems = append(elems, starlark.String(p))
+		}
+		if err := d.SetKey(starlark.String(k), starlark.NewList(elems)); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func New(ctx context.Context, fsys afero.Fs) *base.Module {
+	var (
+		source      string
+		destination string
+		purge       bool
+		exclude     *starlark.List
+	)
+
+	return base.NewModule(
+		ctx,
+		"sync_dir",
+		[]base.ArgPair{
+			{Key: "source", Type: &source},
+			{Key: "destination", Type: &destination},
+			{Key: "purge??", Type: &purge},
+			{Key: "exclude??", Type: &exclude},
+		},
+		&syncDirAction{
+			fsys: fsys,
+		},
+	)
+}
@@ -0,0 +1,313 @@
+package syncdir
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+func TestSyncDirAction_Run(t *testing.T) {
+	tests := []struct {
+		name            string
+		setupFs         func() afero.Fs
+		kwargs          []starlark.Tuple
+		wantErr         bool
+		expectedChanged bool
+		expectedDiff    string
+		assertFs        func(t *testing.T, fs afero.Fs)
+	}{
+		{
+			name: "copies new tree",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "a"},
+					FileDefinition{Path: "/repo/files/sub/b.sh", Content: "b", Mode: 0755},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+			},
+			expectedChanged: true,
+			expectedDiff:    "+ ./\n+ a.txt\n+ sub/\n+ sub/b.sh\n",
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/etc/app/sub/b.sh")
+				require.NoError(t, err)
+				require.Equal(t, "b", string(b))
+				info, err := fs.Stat("/etc/app/sub/b.sh")
+				require.NoError(t, err)
+				require.Equal(t, "-rwxr-xr-x", info.Mode().String())
+			},
+		},
+		{
+			name: "no change when destination matches",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "a"},
+					FileDefinition{Path: "/etc/app/a.txt", Content: "a"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+			},
+			expectedChanged: false,
+		},
+		{
+			name: "updates content and mode",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "new"},
+					FileDefinition{Path: "/repo/files/b.txt", Content: "b", Mode: 0600},
+					FileDefinition{Path: "/etc/app/a.txt", Content: "old"},
+					FileDefinition{Path: "/etc/app/b.txt", Content: "b"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+			},
+			expectedChanged: true,
+			expectedDiff:    "~ a.txt\n~ b.txt\n",
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/etc/app/a.txt")
+				require.NoError(t, err)
+				require.Equal(t, "new", string(b))
+				info, err := fs.Stat("/etc/app/b.txt")
+				require.NoError(t, err)
+				require.Equal(t, "-rw-------", info.Mode().String())
+			},
+		},
+		{
+			name: "purge removes unmanaged files but keeps excluded ones",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "a"},
+					FileDefinition{Path: "/etc/app/a.txt", Content: "a"},
+					FileDefinition{Path: "/etc/app/stale.txt", Content: "stale"},
+					FileDefinition{Path: "/etc/app/old/c.txt", Content: "c"},
+					FileDefinition{Path: "/etc/app/local.conf", Content: "keep"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+				{starlark.String("purge"), starlark.True},
+				{starlark.String("exclude"), starlark.NewList([]starlark.Value{starlark.String("*.conf")})},
+			},
+			expectedChanged: true,
+			expectedDiff:    "- old/\n- stale.txt\n",
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				exists, err := afero.Exists(fs, "/etc/app/stale.txt")
+				require.NoError(t, err)
+				require.False(t, exists)
+				exists, err = afero.Exists(fs, "/etc/app/old")
+				require.NoError(t, err)
+				require.False(t, exists)
+				exists, err = afero.Exists(fs, "/etc/app/local.conf")
+				require.NoError(t, err)
+				require.True(t, exists)
+			},
+		},
+		{
+			name: "excluded source files are not copied",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "a"},
+					FileDefinition{Path: "/repo/files/.git/HEAD", Content: "ref"},
+					FileDefinition{Path: "/etc/app", IsDir: true},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+				{starlark.String("exclude"), starlark.NewList([]starlark.Value{starlark.String(".git")})},
+			},
+			expectedChanged: true,
+			expectedDiff:    "+ a.txt\n",
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				exists, err := afero.Exists(fs, "/etc/app/.git")
+				require.NoError(t, err)
+				require.False(t, exists)
+			},
+		},
+		{
+			name: "what_if reports changes without writing",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files/a.txt", Content: "a"},
+					FileDefinition{Path: "/etc/app/stale.txt", Content: "stale"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+				{starlark.String("purge"), starlark.True},
+				{starlark.String("what_if"), starlark.True},
+			},
+			expectedChanged: true,
+			expectedDiff:    "+ a.txt\n- stale.txt\n",
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				exists, err := afero.Exists(fs, "/etc/app/a.txt")
+				require.NoError(t, err)
+				require.False(t, exists)
+				exists, err = afero.Exists(fs, "/etc/app/stale.txt")
+				require.NoError(t, err)
+				require.True(t, exists)
+			},
+		},
+		{
+			name: "source must be a directory",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/repo/files", Content: "not a dir"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String("/etc/app")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := tt.setupFs()
+			action := &syncDirAction{fsys: fs}
+			thread := starlark.Thread{Name: "test"}
+			result, err := action.Run(context.Background(), "/repo", "sync_dir_test", &thread, nil, tt.kwargs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, result.Success)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+			require.NotNil(t, result.Diff)
+			require.Equal(t, tt.expectedDiff, *result.Diff)
+			if tt.assertFs != nil {
+				tt.assertFs(t, fs)
+			}
+		})
+	}
+}
+
+// MemMapFs has no symlinks, so these cases run against a temporary directory.
+func TestSyncDirAction_Run_Symlinks(t *testing.T) {
+	tests := []struct {
+		name            string
+		setup           func(t *testing.T, src, dest string)
+		kwargs          []starlark.Tuple
+		expectedChanged bool
+		expectedDiff    string
+		expectedLinks   map[string]string
+		assertDest      func(t *testing.T, dest string)
+	}{
+		{
+			name: "copies symlinks as links",
+			setup: func(t *testing.T, src, dest string) {
+				require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644))
+				require.NoError(t, os.Symlink("a.txt", filepath.Join(src, "current")))
+				require.NoError(t, os.Symlink("missing", filepath.Join(src, "dangling")))
+			},
+			expectedChanged: true,
+			expectedDiff:    "+ ./\n+ a.txt\n+ current\n+ dangling\n",
+			expectedLinks:   map[string]string{"current": "a.txt", "dangling": "missing"},
+		},
+		{
+			name: "no change when links match",
+			setup: func(t *testing.T, src, dest string) {
+				require.NoError(t, os.Symlink("a.txt", filepath.Join(src, "current")))
+				require.NoError(t, os.Mkdir(dest, 0755))
+				require.NoError(t, os.Symlink("a.txt", filepath.Join(dest, "current")))
+			},
+			kwargs:          []starlark.Tuple{{starlark.String("purge"), starlark.True}},
+			expectedChanged: false,
+			expectedLinks:   map[string]string{"current": "a.txt"},
+		},
+		{
+			name: "retargets links and replaces files",
+			setup: func(t *testing.T, src, dest string) {
+				require.NoError(t, os.Symlink("v2", filepath.Join(src, "current")))
+				require.NoError(t, os.Symlink("b.txt", filepath.Join(src, "latest")))
+				require.NoError(t, os.Mkdir(dest, 0755))
+				require.NoError(t, os.Symlink("v1", filepath.Join(dest, "current")))
+				require.NoError(t, os.WriteFile(filepath.Join(dest, "latest"), []byte("old"), 0644))
+			},
+			expectedChanged: true,
+			expectedDiff:    "~ current\n~ latest\n",
+			expectedLinks:   map[string]string{"current": "v2", "latest": "b.txt"},
+		},
+		{
+			name: "replaces a link with a file instead of writing through it",
+			setup: func(t *testing.T, src, dest string) {
+				require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644))
+				require.NoError(t, os.Mkdir(dest, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dest, "outside"), []byte("keep"), 0644))
+				require.NoError(t, os.Symlink("outside", filepath.Join(dest, "a.txt")))
+			},
+			expectedChanged: true,
+			expectedDiff:    "~ a.txt\n",
+			assertDest: func(t *testing.T, dest string) {
+				b, err := os.ReadFile(filepath.Join(dest, "outside"))
+				require.NoError(t, err)
+				require.Equal(t, "keep", string(b))
+				info, err := os.Lstat(filepath.Join(dest, "a.txt"))
+				require.NoError(t, err)
+				require.True(t, info.Mode().IsRegular())
+			},
+		},
+		{
+			name: "what_if does not create links",
+			setup: func(t *testing.T, src, dest string) {
+				require.NoError(t, os.Symlink("a.txt", filepath.Join(src, "current")))
+				require.NoError(t, os.Mkdir(dest, 0755))
+			},
+			kwargs:          []starlark.Tuple{{starlark.String("what_if"), starlark.True}},
+			expectedChanged: true,
+			expectedDiff:    "+ current\n",
+			assertDest: func(t *testing.T, dest string) {
+				_, err := os.Lstat(filepath.Join(dest, "current"))
+				require.True(t, os.IsNotExist(err))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			src := filepath.Join(root, "files")
+			dest := filepath.Join(root, "app")
+			require.NoError(t, os.Mkdir(src, 0755))
+			tt.setup(t, src, dest)
+
+			kwargs := append([]starlark.Tuple{
+				{starlark.String("source"), starlark.String("files")},
+				{starlark.String("destination"), starlark.String(dest)},
+			}, tt.kwargs...)
+			action := &syncDirAction{fsys: afero.NewOsFs()}
+			thread := starlark.Thread{Name: "test"}
+			result, err := action.Run(context.Background(), root, "sync_dir_test", &thread, nil, kwargs)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+			require.Equal(t, tt.expectedDiff, *result.Diff)
+			for name, want := range tt.expectedLinks {
+				got, err := os.Readlink(filepath.Join(dest, name))
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+			}
+			if tt.assertDest != nil {
+				tt.assertDest(t, dest)
+			}
+		})
+	}
+}
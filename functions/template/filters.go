@@ -0,0 +1,75 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/noirbizarre/gonja/exec"
+	"go.starlark.net/starlark"
+)
+
+// callFilter calls a Starlark filter with Go arguments and converts the result back to Go.
+func callFilter(thread *starlark.Thread, name string, fn starlark.Callable, args []any, kwargs map[string]any) (any, error) {
+	sargs := make(starlark.Tuple, 0, len(args))
+	for _, arg := range args {
+		v, err := starlarkhelpers.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", name, err)
+		}
+		sargs = append(sargs, v)
+	}
+	keys := make([]string, 0, len(kwargs))
+	for k := range kwargs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var skwargs []starlark.Tuple
+	for _, k := range keys {
+		v, err := starlarkhelpers.FromGo(kwargs[k])
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", name, err)
+		}
+		skwargs = append(skwargs, starlark.Tuple{starlark.String(k), v})
+	}
+	result, err := starlark.Call(thread, fn, sargs, skwargs)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", name, err)
+	}
+	return starlarkhelpers.ToGo(result)
+}
+
+// jinjaFilters adapts Starlark functions to gonja filters. The filtered value is the first argument.
+func jinjaFilters(thread *starlark.Thread, filters map[string]starlark.Callable) exec.FilterSet {
+	set := exec.FilterSet{}
+	for name, fn := range filters {
+		set[name] = func(_ *exec.Evaluator, in *exec.Value, params *exec.VarArgs) *exec.Value {
+			args := []any{in.Interface()}
+			for _, arg := range params.Args {
+				args = append(args, arg.Interface())
+			}
+			kwargs := make(map[string]any, len(params.KwArgs))
+			for k, v := range params.KwArgs {
+				kwargs[k] = v.Interface()
+			}
+			result, err := callFilter(thread, name, fn, args, kwargs)
+			if err != nil {
+				return exec.AsValue(err)
+			}
+			return exec.AsValue(result)
+		}
+	}
+	return set
+}
+
+// goFuncs adapts Starlark functions to text/template functions. As with any template function, a piped
+// value is passed as the last argument.
+func goFuncs(thread *starlark.Thread, filters map[string]starlark.Callable) map[string]any {
+	funcs := make(map[string]any, len(filters))
+	for name, fn := range filters {
+		funcs[name] = func(args ...any) (any, error) {
+			return callFilter(thread, name, fn, args, nil)
+		}
+	}
+	return funcs
+}
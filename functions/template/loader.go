@@ -0,0 +1,62 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/workspace"
+	"github.com/google/deck"
+	"github.com/noirbizarre/gonja/loaders"
+	"github.com/spf13/afero"
+)
+
+// fsLoader lets {% include %}, {% extends %} and {% import %} read templates from the same filesystem and with
+// the same path rules as load(): "//" paths are relative to the workspace, absolute paths are used as is, and
+// other paths are looked up next to the template being rendered and then in the workspace.
+type fsLoader struct {
+	fsys          afero.Fs
+	workspacePath string
+	// rootDir is the directory of the template passed to template().
+	rootDir string
+	// check, if set, validates every template before it is parsed.
+	check func(path string, src []byte) error
+}
+
+var _ loaders.Loader = (*fsLoader)(nil)
+
+func (l *fsLoader) candidates(name string) []string {
+	if workspace.IsWorkspaceRelative(name) || filepath.IsAbs(name) {
+		return []string{workspace.Resolve(l.workspacePath, "", name)}
+	}
+	return []string{
+		workspace.Resolve(l.workspacePath, l.rootDir, name),
+		workspace.Resolve(l.workspacePath, "", name),
+	}
+}
+
+func (l *fsLoader) Get(name string) (io.Reader, error) {
+	var tried []string
+	for _, p := range l.candidates(name) {
+		b, err := afero.ReadFile(l.fsys, p)
+		if errors.Is(err, os.ErrNotExist) {
+			tried = append(tried, p)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logging.Log("template", deck.V(3), "info", "resolved template %q to %q", name, p)
+		if l.check != nil {
+			if err := l.check(p, b); err != nil {
+				return nil, err
+			}
+		}
+		return bytes.NewReader(b), nil
+	}
+	return nil, fmt.Errorf("template %q not found, tried %q", name, tried)
+}
@@ -0,0 +1,412 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/noirbizarre/gonja/exec"
+	"github.com/noirbizarre/gonja/tokens"
+)
+
+// gonja resolves unknown names to nil at render time without any hook, so strict mode checks the template
+// source instead: every name that is read must be passed in data, be a gonja global, or be bound by the
+// template before it is read (for, set, macro, import, with), or, inside a macro, anywhere at the top level. Names bound by for, macro, call and with only
+// count inside their block, as in Jinja. Attributes, filters, tests and keyword arguments are not variables
+// and are not checked. A name checked with "is defined" or given a "|default" is optional within that tag,
+// and a name checked by an if or elif is optional in that branch.
+
+var (
+	// jinjaKeywords are lexed as names but are part of the expression syntax.
+	jinjaKeywords = map[string]bool{
+		"and": true, "or": true, "not": true, "in": true, "is": true, "if": true, "else": true,
+		"true": true, "false": true, "none": true, "True": true, "False": true, "None": true,
+		"recursive": true, "with": true, "without": true, "context": true, "ignore": true, "missing": true,
+		"as": true, "import": true,
+	}
+	// implicitNames are provided by gonja inside loops, macros and blocks.
+	implicitNames = map[string]bool{
+		"loop": true, "caller": true, "varargs": true, "kwargs": true, "self": true, "super": true,
+	}
+)
+
+// undefinedVariable is a name read by a template that is not defined anywhere.
+type undefinedVariable struct {
+	name      string
+	line, col int
+}
+
+// tag is the tokens between {{ }} or {% %}, without the delimiters.
+type tag struct {
+	block bool
+	toks  []*tokens.Token
+}
+
+func lexTags(src string) []tag {
+	var (
+		tags    []tag
+		current *tag
+	)
+	s := tokens.Lex(src)
+	for !s.End() {
+		tok := s.Next()
+		switch tok.Type {
+		case tokens.BlockBegin, tokens.VariableBegin:
+			current = &tag{block: tok.Type == tokens.BlockBegin}
+		case tokens.BlockEnd, tokens.VariableEnd:
+			if current != nil {
+				tags = append(tags, *current)
+				current = nil
+			}
+		default:
+			if current != nil {
+				current.toks = append(current.toks, tok)
+			}
+		}
+	}
+	return tags
+}
+
+func isName(tok *tokens.Token, val string) bool {
+	return tok != nil && tok.Type == tokens.Name && tok.Val == val
+}
+
+// boundNames returns the name tokens a tag assigns. For macro the first is the macro name, and the rest are
+// its parameters.
+func boundNames(t tag) []*tokens.Token {
+	if !t.block || len(t.toks) == 0 {
+		return nil
+	}
+	toks := t.toks
+	var names []*tokens.Token
+	switch toks[0].Val {
+	case "for":
+		for _, tok := range toks[1:] {
+			if isName(tok, "in") {
+				break
+			}
+			if tok.Type == tokens.Name {
+				names = append(names, tok)
+			}
+		}
+	case "set", "with":
+		// Names directly before a top-level "="; "{% set x %}...{% endset %}" binds x.
+		depth := 0
+		for i, tok := range toks[1:] {
+			switch tok.Type {
+			case tokens.Lparen, tokens.Lbracket, tokens.Lbrace:
+				depth++
+			case tokens.Rparen, tokens.Rbracket, tokens.Rbrace:
+				depth--
+			case tokens.Assign:
+				if depth == 0 && toks[0].Val == "set" {
+					return names
+				}
+			case tokens.Name:
+				next := tokenAt(toks, i+2)
+				if depth == 0 && (next == nil || next.Type == tokens.Assign || (toks[0].Val == "set" && next.Type == tokens.Comma)) {
+					names = append(names, tok)
+				}
+			}
+		}
+	case "macro", "call":
+		// "macro name(a, b=1)" binds name, a and b; "call(a) m(x)" binds only a.
+		i := 1
+		if toks[0].Val == "macro" {
+			if name := tokenAt(toks, 1); name != nil && name.Type == tokens.Name {
+				names = append(names, name)
+				i = 2
+			}
+		}
+		if open := tokenAt(toks, i); open == nil || open.Type != tokens.Lparen {
+			return names
+		}
+		depth := 0
+		for j := i; j < len(toks); j++ {
+			switch tok := toks[j]; tok.Type {
+			case tokens.Lparen, tokens.Lbracket, tokens.Lbrace:
+				depth++
+			case tokens.Rparen, tokens.Rbracket, tokens.Rbrace:
+				depth--
+				if depth == 0 {
+					return names
+				}
+			case tokens.Name:
+				if prev := toks[j-1]; depth == 1 && (prev.Type == tokens.Lparen || prev.Type == tokens.Comma) {
+					names = append(names, tok)
+				}
+			}
+		}
+	case "import":
+		for i, tok := range toks {
+			if isName(tok, "as") && i+1 < len(toks) {
+				names = append(names, toks[i+1])
+			}
+		}
+	case "from":
+		imported := false
+		for _, tok := range toks {
+			if isName(tok, "import") {
+				imported = true
+				continue
+			}
+			if imported && tok.Type == tokens.Name && !jinjaKeywords[tok.Val] {
+				names = append(names, tok)
+			}
+		}
+	}
+	return names
+}
+
+func tokenAt(toks []*tokens.Token, i int) *tokens.Token {
+	if i < 0 || i >= len(toks) {
+		return nil
+	}
+	return toks[i]
+}
+
+// readNames returns the variables a tag reads.
+func readNames(t tag) []*tokens.Token {
+	toks := t.toks
+	start := 0
+	if t.block {
+		if len(toks) == 0 {
+			return nil
+		}
+		switch toks[0].Val {
+		case "if", "elif", "for", "set", "with", "include", "extends", "import", "from", "call":
+			start = 1
+		default:
+			// endfor, else, block names, filter sections and macro definitions read no variables.
+			return nil
+		}
+	}
+	var names []*tokens.Token
+	depth := 0
+	for i := start; i < len(toks); i++ {
+		tok := toks[i]
+		switch tok.Type {
+		case tokens.Lparen, tokens.Lbracket, tokens.Lbrace:
+			depth++
+			continue
+		case tokens.Rparen, tokens.Rbracket, tokens.Rbrace:
+			depth--
+			continue
+		case tokens.Name:
+		default:
+			continue
+		}
+		prev, next := tokenAt(toks, i-1), tokenAt(toks, i+1)
+		switch {
+		case jinjaKeywords[tok.Val]:
+			continue
+		case prev != nil && (prev.Type == tokens.Dot || prev.Type == tokens.Pipe):
+			// attribute or filter name
+			continue
+		case isName(prev, "is") || (isName(prev, "not") && isName(tokenAt(toks, i-2), "is")):
+			// test name
+			continue
+		case depth > 0 && next != nil && next.Type == tokens.Assign:
+			// keyword argument
+			continue
+		case guarded(toks, i):
+			continue
+		}
+		names = append(names, tok)
+	}
+	return names
+}
+
+// guarded reports whether the name at toks[i] is checked with "is defined" or given a "|default".
+func guarded(toks []*tokens.Token, i int) bool {
+	next := tokenAt(toks, i+1)
+	if next == nil {
+		return false
+	}
+	if next.Type == tokens.Pipe {
+		f := tokenAt(toks, i+2)
+		return isName(f, "default") || isName(f, "d")
+	}
+	if isName(next, "is") {
+		test := tokenAt(toks, i+2)
+		if isName(test, "not") {
+			test = tokenAt(toks, i+3)
+		}
+		return isName(test, "defined") || isName(test, "undefined")
+	}
+	return false
+}
+
+// guardedNames returns the names a tag checks with "is defined" or gives a "|default".
+func guardedNames(t tag) map[string]bool {
+	names := map[string]bool{}
+	for i, tok := range t.toks {
+		if tok.Type == tokens.Name && guarded(t.toks, i) {
+			names[tok.Val] = true
+		}
+	}
+	return names
+}
+
+// scope is the names bound inside one block. if blocks only hold the names their condition checks with
+// "is defined": a set inside an if binds in the enclosing scope, as in Jinja.
+type scope struct {
+	kind  string
+	names map[string]bool
+}
+
+// scopeOpeners are the block tags that start a scope, ended by the matching "end" tag.
+var scopeOpeners = map[string]bool{"for": true, "macro": true, "call": true, "with": true, "if": true}
+
+// undefinedVariables returns the names read by src that are not in data, the globals, or bound by the
+// template where they are read. alsoBound are names bound by the including template, whose context included
+// templates share. The second result is the names this template passes on the same way: those in scope where
+// it includes, extends or imports another template, and those bound at its top level.
+func undefinedVariables(src string, data map[string]any, globals *exec.Context, alsoBound map[string]bool) ([]undefinedVariable, map[string]bool) {
+	tags := lexTags(src)
+	top := &scope{names: map[string]bool{}}
+	for name := range alsoBound {
+		top.names[name] = true
+	}
+
+	scopes := []*scope{top}
+	visible := func(name string) bool {
+		for _, s := range scopes {
+			if s.names[name] {
+				return true
+			}
+		}
+		return false
+	}
+	// binding is the innermost scope that set, import and macro names go into.
+	binding := func() *scope {
+		for i := len(scopes) - 1; i > 0; i-- {
+			if scopes[i].kind != "if" {
+				return scopes[i]
+			}
+		}
+		return top
+	}
+	inMacro := func() bool {
+		for _, s := range scopes {
+			if s.kind == "macro" {
+				return true
+			}
+		}
+		return false
+	}
+	shared := map[string]bool{}
+
+	// A macro body runs when the macro is called, so what it reads may be set at the top level after the
+	// macro is defined. Those reads are checked once the whole template has been seen.
+	var undefined, deferred []undefinedVariable
+	for _, t := range tags {
+		keyword := ""
+		if t.block && len(t.toks) > 0 {
+			keyword = t.toks[0].Val
+		}
+		guards := guardedNames(t)
+		inner := scopes[len(scopes)-1]
+		if (keyword == "elif" || keyword == "else") && (inner.kind == "if" || inner.kind == "for") {
+			// Each branch only sees its own condition's checks, and a for's else runs without the loop variables.
+			inner.names = map[string]bool{}
+			if keyword == "elif" {
+				for name := range guards {
+					inner.names[name] = true
+				}
+			}
+		}
+
+		bound := boundNames(t)
+		targets := map[*tokens.Token]bool{}
+		for _, tok := range bound {
+			targets[tok] = true
+		}
+		for _, tok := range readNames(t) {
+			name := tok.Val
+			if targets[tok] || guards[name] || visible(name) || implicitNames[name] || globals.Has(name) {
+				continue
+			}
+			if _, ok := data[name]; ok {
+				continue
+			}
+			u := undefinedVariable{name: name, line: tok.Line, col: tok.Col}
+			if inMacro() {
+				deferred = append(deferred, u)
+				continue
+			}
+			undefined = append(undefined, u)
+		}
+
+		switch {
+		case scopeOpeners[keyword]:
+			if keyword == "macro" && len(bound) > 0 {
+				binding().names[bound[0].Val] = true
+				bound = bound[1:]
+			}
+			s := &scope{kind: keyword, names: map[string]bool{}}
+			for _, tok := range bound {
+				s.names[tok.Val] = true
+			}
+			if keyword == "if" {
+				for name := range guards {
+					s.names[name] = true
+				}
+			}
+			scopes = append(scopes, s)
+		case strings.HasPrefix(keyword, "end") && scopeOpeners[strings.TrimPrefix(keyword, "end")]:
+			if len(scopes) > 1 {
+				scopes = scopes[:len(scopes)-1]
+			}
+		default:
+			for _, tok := range bound {
+				binding().names[tok.Val] = true
+			}
+		}
+
+		switch keyword {
+		case "include", "extends", "import", "from":
+			for _, s := range scopes {
+				for name := range s.names {
+					shared[name] = true
+				}
+			}
+		}
+	}
+	for _, u := range deferred {
+		if !top.names[u.name] {
+			undefined = append(undefined, u)
+		}
+	}
+	sort.Slice(undefined, func(i, j int) bool {
+		if undefined[i].line != undefined[j].line {
+			return undefined[i].line < undefined[j].line
+		}
+		return undefined[i].col < undefined[j].col
+	})
+	for name := range top.names {
+		shared[name] = true
+	}
+	return undefined, shared
+}
+
+// strictChecker checks the root template and every template it includes, extends or imports.
+type strictChecker struct {
+	data    map[string]any
+	globals *exec.Context
+	// bound accumulates the names bound by the templates checked so far.
+	bound map[string]bool
+}
+
+func (c *strictChecker) check(path string, src []byte) error {
+	undefined, bound := undefinedVariables(string(src), c.data, c.globals, c.bound)
+	c.bound = bound
+	if len(undefined) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(undefined))
+	for _, u := range undefined {
+		msgs = append(msgs, fmt.Sprintf("%s:%d:%d: undefined variable %q", path, u.line, u.col, u.name))
+	}
+	return fmt.Errorf("strict mode: %s", strings.Join(msgs, "\n"))
+}
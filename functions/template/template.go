@@ -2,30 +2,46 @@ package template
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	gotemplate "text/template"
 
 	"fmt"
 
+	"github.com/Masterminds/sprig/v3"
 	"github.com/discentem/starcm/functions/base"
 	"github.com/discentem/starcm/libraries/diffutils"
 	starcmfileutils "github.com/discentem/starcm/libraries/fileutils"
 	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/workspace"
 	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
 	"github.com/google/deck"
 
-	// TODO (discentem): consider replacing with a different template engine
 	"github.com/noirbizarre/gonja"
+	"github.com/noirbizarre/gonja/config"
 	"github.com/spf13/afero"
 	"go.starlark.net/starlark"
 )
 
+const (
+	// engineJinja renders with gonja, a Jinja2 implementation. It is the default.
+	engineJinja = "jinja"
+	// engineGo renders with text/template and the Sprig function library.
+	engineGo = "go"
+)
+
 type templateAction struct {
 	fsys afero.Fs
+	// workspacePath is the root for "//" template paths.
+	workspacePath string
 }
 
 type writeTemplateOptions struct {
 	persist bool
+	// mode is applied to the file after writing; zero means 0644.
+	mode os.FileMode
 }
 
 func (a *templateAction) writeTemplate(path string, data []byte, opts writeTemplateOptions) error {
@@ -33,6 +49,10 @@ func (a *templateAction) writeTemplate(path string, data []byte, opts writeTempl
 		logging.Log("template", deck.V(2), "info", "skipping write to disk because persist is false")
 		return nil
 	}
+	mode := opts.mode
+	if mode == 0 {
+		mode = 0644
+	}
 
 	// Ensure parent directories exist
 	dir := filepath.Dir(path)
@@ -41,7 +61,7 @@ func (a *templateAction) writeTemplate(path string, data []byte, opts writeTempl
 	}
 
 	// Create or truncate the file
-	f, err := a.fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	f, err := a.fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return fmt.Errorf("failed to open file for writing template: %w", err)
 	}
@@ -63,6 +83,11 @@ func (a *templateAction) writeTemplate(path string, data []byte, opts writeTempl
 		return fmt.Errorf("failed to sync template data to disk: %w", err)
 	}
 
+	// OpenFile only applies the mode when creating the file.
+	if err := a.fsys.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set mode of %q: %w", path, err)
+	}
+
 	return nil
 }
 
@@ -71,6 +96,15 @@ type parsedArgs struct {
 	data         map[string]any
 	destination  string
 	whatIf       bool
+	// mode is nil when not provided, in which case an existing file keeps its mode.
+	mode   *os.FileMode
+	owner  string
+	group  string
+	engine string
+	// strict fails rendering when the template reads a variable that is not defined.
+	strict bool
+	// filters are Starlark functions made available to the template, keyed by name.
+	filters map[string]starlark.Callable
 }
 
 func (a *templateAction) parseArgs(_ starlark.Tuple, kwargs []starlark.Tuple) (*parsedArgs, error) {
@@ -91,8 +125,15 @@ func (a *templateAction) parseArgs(_ starlark.Tuple, kwargs []starlark.Tuple) (*
 	if keyValsIdx == starlarkhelpers.IndexNotFound {
 		return nil, fmt.Errorf("%s is required in template() module", keyWordStr)
 	}
-	keyVals := kwargs[keyValsIdx][1].(*starlark.Dict)
-	gokv := starlarkhelpers.DictToGoMap(keyVals)
+	keyVals, ok := kwargs[keyValsIdx][1].(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a dict, got %s", keyWordStr, kwargs[keyValsIdx][1].Type())
+	}
+	converted, err := starlarkhelpers.ToGo(keyVals)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyWordStr, err)
+	}
+	gokv := converted.(map[string]any)
 
 	whatIf, err := starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
 	if err != nil {
@@ -110,158 +151,272 @@ func (a *templateAction) parseArgs(_ starlark.Tuple, kwargs []starlark.Tuple) (*
 		return nil, fmt.Errorf("destination is required in template() module if what_if is false")
 	}
 
-	return &parsedArgs{
+	engine, err := starlarkhelpers.FindValueInKwargsWithDefault(kwargs, "engine", engineJinja)
+	if err != nil {
+		return nil, err
+	}
+	if *engine != engineJinja && *engine != engineGo {
+		return nil, fmt.Errorf("engine must be %q or %q, got %q", engineJinja, engineGo, *engine)
+	}
+
+	strict, err := starlarkhelpers.FindBoolInKwargs(kwargs, "strict", false)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parsedArgs{
 		templatePath: *template,
 		data:         gokv,
 		destination:  *destination,
 		whatIf:       whatIf,
-	}, nil
-}
-
-var _ base.Runnable = (*templateAction)(nil)
+		engine:       *engine,
+		strict:       strict,
+	}
 
-func (a *templateAction) Run(ctx context.Context, workingDirectory string, moduleName string, thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (*base.Result, error) {
-	parsedArgs, err := a.parseArgs(args, kwargs)
-	if err != nil {
+	if v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, "filters"); err == nil && v != nil && v != starlark.None {
+		dict, ok := v.(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("filters must be a dict, got %s", v.Type())
+		}
+		p.filters = make(map[string]starlark.Callable, dict.Len())
+		for _, item := range dict.Items() {
+			name, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("filter names must be strings, got %s", item[0].Type())
+			}
+			fn, ok := item[1].(starlark.Callable)
+			if !ok {
+				return nil, fmt.Errorf("filter %q must be a function, got %s", string(name), item[1].Type())
+			}
+			p.filters[string(name)] = fn
+		}
+	} else if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
 		return nil, err
 	}
-	template := parsedArgs.templatePath
-	gokv := parsedArgs.data
-	destination := parsedArgs.destination
-	whatIf := parsedArgs.whatIf
 
-	isDir, err := starcmfileutils.IsDir(a.fsys, destination)
-	if err != nil && !os.IsNotExist(err) {
+	if v, err := starlarkhelpers.FindRawValueInKwargs(kwargs, "mode"); err == nil && v != nil && v != starlark.None {
+		var mode int64
+		if err := starlark.AsInt(v, &mode); err != nil {
+			return nil, fmt.Errorf("mode must be an int, got %s", v.Type())
+		}
+		fm := os.FileMode(mode).Perm()
+		p.mode = &fm
+	} else if err != nil && !errors.Is(err, starlarkhelpers.ErrIndexNotFound) {
 		return nil, err
 	}
-	if isDir {
-		return nil, fmt.Errorf("destination must be a file, not a directory")
+	for key, dst := range map[string]*string{"owner": &p.owner, "group": &p.group} {
+		v, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, key)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			*dst = *v
+		}
 	}
 
-	f, err := a.fsys.Open(filepath.Join(workingDirectory, template))
-	if err != nil {
-		return nil, err
+	return p, nil
+}
+
+var _ base.Runnable = (*templateAction)(nil)
+
+func (a *templateAction) render(thread *starlark.Thread, workingDirectory, moduleName string, parsed *parsedArgs) (string, error) {
+	template := parsed.templatePath
+	templatePath := filepath.Join(workingDirectory, template)
+	if workspace.IsWorkspaceRelative(template) {
+		templatePath = workspace.Resolve(a.workspacePath, "", template)
 	}
-	defer f.Close()
-	b, err := afero.ReadAll(f)
+	b, err := afero.ReadFile(a.fsys, templatePath)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	logging.Log(moduleName, deck.V(2), "info", "%v before rendering: %v", template, string(b))
-	logging.Log(moduleName, deck.V(2), "info", "data: %v", gokv)
-	tmpl, err := gonja.FromBytes(b)
+	logging.Log(moduleName, deck.V(2), "info", "data: %v", parsed.data)
+	if parsed.engine == engineGo {
+		return renderGo(templatePath, b, parsed, goFuncs(thread, parsed.filters))
+	}
+	loader := &fsLoader{
+		fsys:          a.fsys,
+		workspacePath: a.workspacePath,
+		rootDir:       filepath.Dir(templatePath),
+	}
+	// Inherit copies the config, so the shared default is never modified.
+	env := gonja.NewEnvironment(config.DefaultConfig.Inherit(), loader)
+	env.Filters.Update(jinjaFilters(thread, parsed.filters))
+	if parsed.strict {
+		checker := &strictChecker{data: parsed.data, globals: env.Globals}
+		if err := checker.check(templatePath, b); err != nil {
+			return "", err
+		}
+		loader.check = checker.check
+	}
+	tmpl, err := env.FromBytes(b)
 	if err != nil {
 		// If it fails here, it's likely a problem with the .tmpl file itself such as unexpected symbols
 		logging.Log("template", deck.V(1), "error", "failed to parse template", err)
-		return nil, err
+		return "", err
 	}
-	renderedTemplate, err := tmpl.Execute(gokv)
+	renderedTemplate, err := tmpl.Execute(parsed.data)
 	if err != nil {
 		logging.Log("template", deck.V(1), "error", "failed to render template", err)
-		return nil, err
+		return "", err
 	}
+	return renderedTemplate, nil
+}
 
-	destinationPath := filepath.Join(workingDirectory, destination)
-
-	// Check if destination file exists
-	destinationExists := true
-	info, err := a.fsys.Stat(destinationPath)
+// renderGo renders b with text/template and the Sprig function library, so existing Go templates can be reused as is.
+// In strict mode a missing map key is an error, reported by text/template with the template name and line.
+func renderGo(templatePath string, b []byte, parsed *parsedArgs, funcs map[string]any) (string, error) {
+	tmpl := gotemplate.New(filepath.Base(templatePath)).
+		Funcs(sprig.TxtFuncMap()).
+		Funcs(funcs)
+	if parsed.strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(string(b))
 	if err != nil {
-		if os.IsNotExist(err) {
-			if !whatIf {
-				logging.Log(moduleName, deck.V(2), "info", "destination file does not exist, will create it")
-			}
-			destinationExists = false
-		} else {
-			// If error is not "file doesn't exist", return the error
-			return nil, err
-		}
-	} else {
-		logging.Log(moduleName, deck.V(2), "info", "a.fsys.Stat(%s): %v", destinationPath, info.Name())
+		logging.Log("template", deck.V(1), "error", "failed to parse template: %v", err)
+		return "", err
 	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, parsed.data); err != nil {
+		logging.Log("template", deck.V(1), "error", "failed to render template: %v", err)
+		return "", err
+	}
+	return sb.String(), nil
+}
 
-	// Handle case where file doesn't exist
-	if !destinationExists {
-		// Create file with rendered template
-		if err := a.writeTemplate(
-			destinationPath,
-			[]byte(renderedTemplate),
-			writeTemplateOptions{
-				persist: !whatIf,
-			},
-		); err != nil {
-			return &base.Result{
-				Label:   moduleName,
-				Message: nil,
-				Success: false,
-				Changed: false,
-				Error:   err,
-			}, err
-		}
-
-		// Return success after creating new file
-		return &base.Result{
-			Label:   moduleName,
-			Message: &renderedTemplate,
-			Success: true,
-			Changed: true,
-			Error:   nil,
-		}, nil
+func (a *templateAction) Run(ctx context.Context, workingDirectory string, moduleName string, thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (*base.Result, error) {
+	parsed, err := a.parseArgs(args, kwargs)
+	if err != nil {
+		return nil, err
 	}
 
-	// At this point, we know the file exists, so read its contents
-	destinationBefore, err := afero.ReadFile(a.fsys, destinationPath)
+	renderedTemplate, err := a.render(thread, workingDirectory, moduleName, parsed)
 	if err != nil {
 		return nil, err
 	}
 
-	// If the file exists and the contents are the same, return a success
-	if string(destinationBefore) == renderedTemplate {
-		renderedStr := fmt.Sprint(renderedTemplate)
+	if parsed.destination == "not_provided" {
+		// Only rendering was requested, so there is nothing to compare against.
 		return &base.Result{
 			Label:   moduleName,
-			Message: &renderedStr,
+			Message: func() *string { s := fmt.Sprintf("rendered %q", parsed.templatePath); return &s }(),
 			Success: true,
 			Changed: false,
-			Error:   nil,
+			Return:  starlark.String(renderedTemplate),
 		}, nil
 	}
 
-	if err := a.writeTemplate(
-		destinationPath,
-		[]byte(renderedTemplate),
-		writeTemplateOptions{
-			persist: !whatIf,
-		},
-	); err != nil {
-		return &base.Result{
-			Label:   moduleName,
-			Message: nil,
-			Success: false,
-			Changed: false,
-			Error:   err,
-		}, err
+	isDir, err := starcmfileutils.IsDir(a.fsys, filepath.Join(workingDirectory, parsed.destination))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if isDir {
+		return nil, fmt.Errorf("destination must be a file, not a directory")
 	}
 
-	diff := diffutils.GitDiff(string(destinationBefore), renderedTemplate)
-	logging.Log(moduleName, deck.V(2), "info", "diff: %v", diff)
+	destinationPath := filepath.Join(workingDirectory, parsed.destination)
+	uid, gid, err := starcmfileutils.LookupIDs(parsed.owner, parsed.group)
+	if err != nil {
+		return nil, err
+	}
 
-	renderedStr := fmt.Sprint(renderedTemplate)
+	var (
+		destinationBefore []byte
+		mode              os.FileMode = 0644
+		contentChanged                = true
+		metadataChanged               = false
+	)
+	info, err := a.fsys.Stat(destinationPath)
+	switch {
+	case err == nil:
+		logging.Log(moduleName, deck.V(2), "info", "a.fsys.Stat(%s): %v", destinationPath, info.Name())
+		destinationBefore, err = afero.ReadFile(a.fsys, destinationPath)
+		if err != nil {
+			return nil, err
+		}
+		contentChanged = string(destinationBefore) != renderedTemplate
+		mode = info.Mode().Perm()
+		if parsed.mode != nil && *parsed.mode != mode {
+			metadataChanged = true
+		}
+		if curUID, curGID, ok := starcmfileutils.FileOwner(info); ok {
+			if (uid != -1 && uid != curUID) || (gid != -1 && gid != curGID) {
+				metadataChanged = true
+			}
+		}
+	case os.IsNotExist(err):
+		if !parsed.whatIf {
+			logging.Log(moduleName, deck.V(2), "info", "destination file does not exist, will create it")
+		}
+	default:
+		// If error is not "file doesn't exist", return the error
+		return nil, err
+	}
+	if parsed.mode != nil {
+		mode = *parsed.mode
+	}
 
-	return &base.Result{
+	result := &base.Result{
 		Label:   moduleName,
-		Message: &renderedStr,
 		Success: true,
-		Changed: true,
-		Error:   nil,
-	}, nil
+		Changed: contentChanged || metadataChanged,
+		Return:  starlark.String(renderedTemplate),
+	}
+	if !result.Changed {
+		result.Message = func() *string { s := fmt.Sprintf("%q already up to date", destinationPath); return &s }()
+		return result, nil
+	}
+
+	if !parsed.whatIf {
+		if contentChanged {
+			if err := a.writeTemplate(
+				destinationPath,
+				[]byte(renderedTemplate),
+				writeTemplateOptions{
+					persist: true,
+					mode:    mode,
+				},
+			); err != nil {
+				return nil, err
+			}
+		} else if err := a.fsys.Chmod(destinationPath, mode); err != nil {
+			return nil, fmt.Errorf("failed to set mode of %q: %w", destinationPath, err)
+		}
+		if uid != -1 || gid != -1 {
+			if err := a.fsys.Chown(destinationPath, uid, gid); err != nil {
+				return nil, fmt.Errorf("failed to set owner of %q: %w", destinationPath, err)
+			}
+		}
+	}
+
+	if contentChanged {
+		diff := diffutils.GitDiff(string(destinationBefore), renderedTemplate)
+		logging.Log(moduleName, deck.V(2), "info", "diff: %v", diff)
+		result.Diff = &diff
+	}
+	result.Message = func() *string {
+		verb := "rendered"
+		if parsed.whatIf {
+			verb = "would render"
+		}
+		s := fmt.Sprintf("%s %q to %q", verb, parsed.templatePath, destinationPath)
+		return &s
+	}()
+	return result, nil
 }
 
-func New(ctx context.Context, fsys afero.Fs) *base.Module {
+func New(ctx context.Context, fsys afero.Fs, workspacePath string) *base.Module {
 	var (
 		str         string
 		data        *starlark.Dict
 		destination string
+		mode        int64
+		owner       string
+		group       string
+		engine      string
+		strict      bool
+		filters     *starlark.Dict
 	)
 
 	return base.NewModule(
@@ -271,9 +426,16 @@ func New(ctx context.Context, fsys afero.Fs) *base.Module {
 			{Key: "template", Type: &str},
 			{Key: "data", Type: &data},
 			{Key: "destination?", Type: &destination},
+			{Key: "mode??", Type: &mode},
+			{Key: "owner??", Type: &owner},
+			{Key: "group??", Type: &group},
+			{Key: "engine??", Type: &engine},
+			{Key: "strict??", Type: &strict},
+			{Key: "filters??", Type: &filters},
 		},
 		&templateAction{
-			fsys: fsys,
+			fsys:          fsys,
+			workspacePath: workspacePath,
 		},
 	)
 }
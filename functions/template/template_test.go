@@ -2,6 +2,7 @@ package template
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
@@ -18,6 +19,15 @@ import (
 // FileDefinition represents a file to be created in the test filesystem
 type FileDefinition = aferohelpers.FileDefinition
 
+// mustDict converts m for use as template data in test tables, which are built before t is available.
+func mustDict(m map[string]any) *starlark.Dict {
+	d, err := starlarkhelpers.GoDictToStarlarkDict(m)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
 func TestTemplateAction_parseArgs(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -29,7 +39,7 @@ func TestTemplateAction_parseArgs(t *testing.T) {
 			name: "valid args",
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("path/to/template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"key": "value"})},
+				{starlark.String("data"), mustDict(map[string]any{"key": "value"})},
 				{starlark.String("destination"), starlark.String("path/to/output.txt")},
 			},
 			wantErr: false,
@@ -42,7 +52,7 @@ func TestTemplateAction_parseArgs(t *testing.T) {
 		{
 			name: "missing template",
 			kwargs: []starlark.Tuple{
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"key": "value"})},
+				{starlark.String("data"), mustDict(map[string]any{"key": "value"})},
 				{starlark.String("destination"), starlark.String("path/to/output.txt")},
 			},
 			wantErr: true,
@@ -59,7 +69,7 @@ func TestTemplateAction_parseArgs(t *testing.T) {
 			name: "missing destination",
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("path/to/template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"key": "value"})},
+				{starlark.String("data"), mustDict(map[string]any{"key": "value"})},
 			},
 			wantErr: true,
 		},
@@ -177,14 +187,15 @@ func TestTemplateAction_writeTemplate(t *testing.T) {
 
 func TestTemplateAction_Run(t *testing.T) {
 	tests := []struct {
-		name              string
-		setupFs           func() (afero.Fs, string)
-		args              starlark.Tuple
-		kwargs            []starlark.Tuple
-		expectedSuccess   bool
-		expectedChanged   bool
-		expectedOutput    string
-		expectedDiff      string
+		name            string
+		setupFs         func() (afero.Fs, string)
+		args            starlark.Tuple
+		kwargs          []starlark.Tuple
+		expectedSuccess bool
+		expectedChanged bool
+		expectedOutput  string
+		// expectedDiff lists substrings of the diff; go-cmp deliberately randomizes its exact whitespace.
+		expectedDiff      []string
 		expectOutputEqual bool
 		wantErr           bool
 	}{
@@ -202,7 +213,7 @@ func TestTemplateAction_Run(t *testing.T) {
 			},
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"name": "World"})},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
 				{starlark.String("destination"), starlark.String("output.txt")},
 			},
 			expectedSuccess:   true,
@@ -229,13 +240,12 @@ func TestTemplateAction_Run(t *testing.T) {
 			},
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"name": "World"})},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
 				{starlark.String("destination"), starlark.String("output.txt")},
 			},
 			expectedSuccess:   true,
 			expectedChanged:   false,
 			expectedOutput:    "Hello World!",
-			expectedDiff:      "",
 			expectOutputEqual: true,
 			wantErr:           false,
 		},
@@ -246,7 +256,7 @@ func TestTemplateAction_Run(t *testing.T) {
 			},
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("nonexistent.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"name": "World"})},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
 				{starlark.String("destination"), starlark.String("output.txt")},
 			},
 			expectedSuccess: false,
@@ -270,13 +280,13 @@ func TestTemplateAction_Run(t *testing.T) {
 			},
 			kwargs: []starlark.Tuple{
 				{starlark.String("template"), starlark.String("template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"name": "World"})},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
 				{starlark.String("destination"), starlark.String("output.txt")},
 			},
 			expectedSuccess:   true,
 			expectedChanged:   true,
 			expectedOutput:    "Hello World!",
-			expectedDiff:      "  []string{\n- \t\"Different content\",\n+ \t\"Hello World!\",\n  }\n",
+			expectedDiff:      []string{"- ", "\"Different content\"", "+ ", "\"Hello World!\""},
 			expectOutputEqual: true,
 			wantErr:           false,
 		},
@@ -300,12 +310,17 @@ func TestTemplateAction_Run(t *testing.T) {
 			require.Equal(t, tt.expectedSuccess, result.Success)
 			require.Equal(t, tt.expectedChanged, result.Changed)
 
-			if tt.expectOutputEqual && result.Message != nil {
-				require.Equal(t, tt.expectedOutput, *result.Message)
+			if tt.expectOutputEqual {
+				require.Equal(t, starlark.String(tt.expectedOutput), result.Return)
 			}
 
-			if tt.expectedDiff != "" && result.Diff != nil {
-				require.Equal(t, tt.expectedDiff, *result.Diff)
+			if tt.expectedChanged {
+				require.NotNil(t, result.Diff)
+				for _, want := range tt.expectedDiff {
+					require.Contains(t, *result.Diff, want)
+				}
+			} else {
+				require.Nil(t, result.Diff)
 			}
 
 			// Verify destination file content if it was successful
@@ -370,7 +385,7 @@ func TestTemplateAction_TemplateErrors(t *testing.T) {
 
 			kwargs := []starlark.Tuple{
 				{starlark.String("template"), starlark.String("template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(tt.data)},
+				{starlark.String("data"), mustDict(tt.data)},
 				{starlark.String("destination"), starlark.String("output.txt")},
 			}
 			thread := starlark.Thread{Name: "test"}
@@ -471,7 +486,7 @@ func TestTemplateAction_FileSystemEdgeCases(t *testing.T) {
 
 			kwargs := []starlark.Tuple{
 				{starlark.String("template"), starlark.String("template.tmpl")},
-				{starlark.String("data"), starlarkhelpers.GoDictToStarlarkDict(map[string]any{"key": "value"})},
+				{starlark.String("data"), mustDict(map[string]any{"key": "value"})},
 				{starlark.String("destination"), starlark.String(tt.destination)},
 			}
 
@@ -495,3 +510,384 @@ func TestTemplateAction_FileSystemEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplateAction_ModeAndWhatIf(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        *FileDefinition
+		kwargs          []starlark.Tuple
+		expectedChanged bool
+		expectedMode    os.FileMode
+		expectedContent string
+	}{
+		{
+			name:            "new file gets requested mode",
+			kwargs:          []starlark.Tuple{{starlark.String("mode"), starlark.MakeInt(0600)}},
+			expectedChanged: true,
+			expectedMode:    0600,
+			expectedContent: "Hello World!",
+		},
+		{
+			name:            "mode drift alone is a change",
+			existing:        &FileDefinition{Path: "output.txt", Content: "Hello World!", Mode: 0644},
+			kwargs:          []starlark.Tuple{{starlark.String("mode"), starlark.MakeInt(0600)}},
+			expectedChanged: true,
+			expectedMode:    0600,
+			expectedContent: "Hello World!",
+		},
+		{
+			name:            "existing mode is kept when mode is not given",
+			existing:        &FileDefinition{Path: "output.txt", Content: "old", Mode: 0640},
+			expectedChanged: true,
+			expectedMode:    0640,
+			expectedContent: "Hello World!",
+		},
+		{
+			name:     "what_if reports the change without writing",
+			existing: &FileDefinition{Path: "output.txt", Content: "old", Mode: 0644},
+			kwargs: []starlark.Tuple{
+				{starlark.String("mode"), starlark.MakeInt(0600)},
+				{starlark.String("what_if"), starlark.True},
+			},
+			expectedChanged: true,
+			expectedMode:    0644,
+			expectedContent: "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []FileDefinition{{Path: "template.tmpl", Content: "Hello {{ name }}!"}}
+			if tt.existing != nil {
+				files = append(files, *tt.existing)
+			}
+			fs := aferohelpers.NewMemFsWithFiles(files...)
+			action := &templateAction{fsys: fs}
+			kwargs := append([]starlark.Tuple{
+				{starlark.String("template"), starlark.String("template.tmpl")},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
+				{starlark.String("destination"), starlark.String("output.txt")},
+			}, tt.kwargs...)
+
+			result, err := action.Run(context.Background(), "", "template_test", &starlark.Thread{}, nil, kwargs)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+			require.Equal(t, starlark.String("Hello World!"), result.Return)
+
+			info, err := fs.Stat("output.txt")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedMode, info.Mode().Perm())
+			content, err := afero.ReadFile(fs, "output.txt")
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedContent, string(content))
+		})
+	}
+}
+
+func TestTemplateAction_IncludesAndInheritance(t *testing.T) {
+	fs := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/templates/base.conf.j2", Content: "# managed\n{% block body %}{% endblock %}\n"},
+		FileDefinition{Path: "/repo/templates/macros.j2", Content: "{% macro kv(k, v) %}{{ k }} = {{ v }}{% endmacro %}"},
+		FileDefinition{Path: "/repo/roles/web/header.j2", Content: "[{{ name }}]"},
+		FileDefinition{
+			Path: "/repo/roles/web/site.conf.j2",
+			Content: "{% extends \"//templates/base.conf.j2\" %}" +
+				"{% block body %}{% include \"header.j2\" %}\n" +
+				"{% from \"templates/macros.j2\" import kv %}{{ kv(\"port\", 8080) }}{% endblock %}",
+		},
+	)
+	action := &templateAction{fsys: fs, workspacePath: "/repo"}
+	result, err := action.Run(context.Background(), "/repo/roles/web", "template_test", &starlark.Thread{}, nil, []starlark.Tuple{
+		{starlark.String("template"), starlark.String("site.conf.j2")},
+		{starlark.String("data"), mustDict(map[string]any{"name": "web"})},
+		{starlark.String("what_if"), starlark.True},
+	})
+	require.NoError(t, err)
+	require.Equal(t, starlark.String("# managed\n[web]\nport = 8080"), result.Return)
+
+	_, err = action.Run(context.Background(), "/repo/roles/web", "template_test", &starlark.Thread{}, nil, []starlark.Tuple{
+		{starlark.String("template"), starlark.String("//templates/base.conf.j2")},
+		{starlark.String("data"), mustDict(map[string]any{})},
+		{starlark.String("what_if"), starlark.True},
+	})
+	require.NoError(t, err)
+
+	fs = aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/repo/t.j2", Content: "{% include \"missing.j2\" %}"})
+	action = &templateAction{fsys: fs, workspacePath: "/repo"}
+	_, err = action.Run(context.Background(), "/repo", "template_test", &starlark.Thread{}, nil, []starlark.Tuple{
+		{starlark.String("template"), starlark.String("t.j2")},
+		{starlark.String("data"), mustDict(map[string]any{})},
+		{starlark.String("what_if"), starlark.True},
+	})
+	require.ErrorContains(t, err, "missing.j2")
+}
+
+func TestTemplateAction_GoEngine(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		engine   string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "text/template with sprig functions",
+			content:  "Hello {{ .name | upper }}, {{ .missing | default \"nobody\" }}!",
+			engine:   "go",
+			expected: "Hello WORLD, nobody!",
+		},
+		{
+			name:     "jinja is the default",
+			content:  "Hello {{ name }}!",
+			expected: "Hello World!",
+		},
+		{
+			name:    "invalid go template",
+			content: "Hello {{ .name ",
+			engine:  "go",
+			wantErr: true,
+		},
+		{
+			name:    "unknown engine",
+			content: "Hello",
+			engine:  "mustache",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "template.tmpl", Content: tt.content})
+			action := &templateAction{fsys: fs}
+			kwargs := []starlark.Tuple{
+				{starlark.String("template"), starlark.String("template.tmpl")},
+				{starlark.String("data"), mustDict(map[string]any{"name": "World"})},
+				{starlark.String("what_if"), starlark.True},
+			}
+			if tt.engine != "" {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("engine"), starlark.String(tt.engine)})
+			}
+
+			result, err := action.Run(context.Background(), "", "template_test", &starlark.Thread{}, nil, kwargs)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, starlark.String(tt.expected), result.Return)
+		})
+	}
+}
+
+func TestTemplateAction_NestedData(t *testing.T) {
+	users := starlark.NewList(nil)
+	for _, u := range []struct {
+		name   string
+		admin  bool
+		groups starlark.Tuple
+	}{
+		{"alice", true, starlark.Tuple{starlark.String("wheel"), starlark.String("dev")}},
+		{"bob", false, starlark.Tuple{}},
+	} {
+		d := starlark.NewDict(3)
+		require.NoError(t, d.SetKey(starlark.String("name"), starlark.String(u.name)))
+		require.NoError(t, d.SetKey(starlark.String("admin"), starlark.Bool(u.admin)))
+		require.NoError(t, d.SetKey(starlark.String("groups"), u.groups))
+		require.NoError(t, users.Append(d))
+	}
+	data := starlark.NewDict(1)
+	require.NoError(t, data.SetKey(starlark.String("users"), users))
+
+	tests := []struct {
+		name    string
+		content string
+		engine  string
+	}{
+		{
+			name:    "jinja",
+			content: "{% for u in users %}{{ u.name }}{% if u.admin %}*{% endif %}:{{ u.groups|join(\",\") }};{% endfor %}",
+		},
+		{
+			name:    "go",
+			content: "{{ range .users }}{{ .name }}{{ if .admin }}*{{ end }}:{{ join \",\" .groups }};{{ end }}",
+			engine:  "go",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "template.tmpl", Content: tt.content})
+			action := &templateAction{fsys: fs}
+			kwargs := []starlark.Tuple{
+				{starlark.String("template"), starlark.String("template.tmpl")},
+				{starlark.String("data"), data},
+				{starlark.String("what_if"), starlark.True},
+			}
+			if tt.engine != "" {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("engine"), starlark.String(tt.engine)})
+			}
+			result, err := action.Run(context.Background(), "", "template_test", &starlark.Thread{}, nil, kwargs)
+			require.NoError(t, err)
+			require.Equal(t, starlark.String("alice*:wheel,dev;bob:;"), result.Return)
+		})
+	}
+}
+
+func TestTemplateAction_Strict(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []FileDefinition
+		engine  string
+		wantErr string
+	}{
+		{
+			name: "defined variables render",
+			files: []FileDefinition{{Path: "t.j2", Content: "{% set greeting = \"hi\" %}" +
+				"{% for u in users %}{{ greeting }} {{ u.name|upper }}{% if loop.last %}.{% endif %}{% endfor %}" +
+				"{{ port|default(80) }}{% if debug is defined %}{{ debug }}{% endif %}{{ range(2)|length }}"}},
+		},
+		{
+			name:    "typo reports file and line",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{{ users }}\n{{ nmae }}"}},
+			wantErr: "t.j2:2:4: undefined variable \"nmae\"",
+		},
+		{
+			name: "included template is checked",
+			files: []FileDefinition{
+				{Path: "t.j2", Content: "{% for u in users %}{% include \"row.j2\" %}{% endfor %}"},
+				{Path: "row.j2", Content: "{{ u.name }}\n{{ nmae }}"},
+			},
+			wantErr: "row.j2:2:4: undefined variable \"nmae\"",
+		},
+		{
+			name: "macro parameters are defined",
+			files: []FileDefinition{{Path: "t.j2", Content: "{% macro kv(key, value=1) %}{{ key }}={{ value }}{% endmacro %}" +
+				"{{ kv(\"a\", value=users) }}"}},
+		},
+		{
+			name:    "loop variables end with the loop",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{% for itme in users %}{{ itme.name }}{% endfor %}\n{{ itme }}"}},
+			wantErr: "t.j2:2:4: undefined variable \"itme\"",
+		},
+		{
+			name:    "is defined only guards its own branch",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{% if debug is defined %}{{ debug }}{% else %}{{ debug }}{% endif %}"}},
+			wantErr: "t.j2:1:50: undefined variable \"debug\"",
+		},
+		{
+			name:    "is defined does not guard the rest of the template",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{% if debug is defined %}{{ debug }}{% endif %}\n{{ debug }}"}},
+			wantErr: "t.j2:2:4: undefined variable \"debug\"",
+		},
+		{
+			name:    "default only applies where it is given",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{{ port|default(80) }}\n{{ port }}"}},
+			wantErr: "t.j2:2:4: undefined variable \"port\"",
+		},
+		{
+			name:    "variables are read before they are set",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{{ greeting }}\n{% set greeting = \"hi\" %}"}},
+			wantErr: "t.j2:1:4: undefined variable \"greeting\"",
+		},
+		{
+			name: "set inside if, and top-level names read by macros, are in scope",
+			files: []FileDefinition{{Path: "t.j2", Content: "{% macro row(x) %}{{ who }}: {{ x }}{% endmacro %}" +
+				"{% if users %}{% set who = \"all\" %}{% else %}{% set who = \"none\" %}{% endif %}{{ row(users) }}"}},
+		},
+		{
+			name:    "macro parameters end with the macro",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{% macro row(x) %}{{ x }}{% endmacro %}\n{{ x }}"}},
+			wantErr: "t.j2:2:4: undefined variable \"x\"",
+		},
+		{
+			name:    "call arguments are read, not bound",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{% macro list(xs) %}{{ caller() }}{% endmacro %}{% call(u) list(usres) %}{{ u }}{% endcall %}"}},
+			wantErr: "undefined variable \"usres\"",
+		},
+		{
+			name:    "go engine fails on missing keys",
+			files:   []FileDefinition{{Path: "t.j2", Content: "{{ .users }}\n{{ .nmae }}"}},
+			engine:  "go",
+			wantErr: "t.j2:2:3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(tt.files...)
+			action := &templateAction{fsys: fs}
+			users, err := starlarkhelpers.FromGo([]any{map[string]any{"name": "alice"}})
+			require.NoError(t, err)
+			data := starlark.NewDict(1)
+			require.NoError(t, data.SetKey(starlark.String("users"), users))
+			kwargs := []starlark.Tuple{
+				{starlark.String("template"), starlark.String("t.j2")},
+				{starlark.String("data"), data},
+				{starlark.String("what_if"), starlark.True},
+				{starlark.String("strict"), starlark.True},
+			}
+			if tt.engine != "" {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("engine"), starlark.String(tt.engine)})
+			}
+			_, err = action.Run(context.Background(), "", "template_test", &starlark.Thread{}, nil, kwargs)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTemplateAction_Filters(t *testing.T) {
+	const src = `
+def shout(s, suffix = "!"):
+    return s.upper() + suffix
+
+def keys(d):
+    return sorted(d.keys())
+`
+	thread := &starlark.Thread{}
+	globals, err := starlark.ExecFile(thread, "filters.star", src, nil)
+	require.NoError(t, err)
+	filters := starlark.NewDict(2)
+	require.NoError(t, filters.SetKey(starlark.String("shout"), globals["shout"]))
+	require.NoError(t, filters.SetKey(starlark.String("keys"), globals["keys"]))
+
+	tests := []struct {
+		name     string
+		content  string
+		engine   string
+		expected string
+	}{
+		{
+			name:     "jinja",
+			content:  "{{ name|shout }} {{ name|shout(suffix=\"?\") }} {{ env|keys|join(\",\") }}",
+			expected: "WORLD! WORLD? a,b",
+		},
+		{
+			name:     "go",
+			content:  "{{ .name | shout }} {{ shout .name \"?\" }} {{ keys .env | join \",\" }}",
+			engine:   "go",
+			expected: "WORLD! WORLD? a,b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "t.tmpl", Content: tt.content})
+			action := &templateAction{fsys: fs}
+			data := mustDict(map[string]any{
+				"name": "world",
+				"env":  map[string]any{"b": 2, "a": 1},
+			})
+			kwargs := []starlark.Tuple{
+				{starlark.String("template"), starlark.String("t.tmpl")},
+				{starlark.String("data"), data},
+				{starlark.String("what_if"), starlark.True},
+				{starlark.String("filters"), filters},
+			}
+			if tt.engine != "" {
+				kwargs = append(kwargs, starlark.Tuple{starlark.String("engine"), starlark.String(tt.engine)})
+			}
+			result, err := action.Run(context.Background(), "", "template_test", thread, nil, kwargs)
+			require.NoError(t, err)
+			require.Equal(t, starlark.String(tt.expected), result.Return)
+		})
+	}
+}
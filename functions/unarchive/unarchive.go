@@ -0,0 +1,159 @@
+package unarchive
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/discentem/starcm/functions/base"
+	"github.com/discentem/starcm/libraries/archive"
+	"github.com/discentem/starcm/libraries/fileutils"
+	"github.com/discentem/starcm/libraries/logging"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+)
+
+type unarchiveAction struct {
+	fsys afero.Fs
+}
+
+var _ base.Runnable = (*unarchiveAction)(nil)
+
+func (a *unarchiveAction) Run(
+	ctx context.Context,
+	workingDirectory string,
+	label string,
+	thread *starlark.Thread,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (*base.Result, error) {
+	if a.fsys == nil {
+		return nil, fmt.Errorf("fsys must be provided to unarchive module")
+	}
+
+	src, err := starlarkhelpers.FindValueinKwargs(kwargs, "src")
+	if err != nil {
+		return nil, err
+	}
+	srcPath, err := fileutils.ExpandPath(*src)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := starlarkhelpers.FindValueinKwargs(kwargs, "dest")
+	if err != nil {
+		return nil, err
+	}
+	destPath, err := fileutils.ExpandPath(*dest)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "format")
+	if err != nil {
+		return nil, err
+	}
+	if format == nil {
+		detected, err := archive.DetectFormat(srcPath)
+		if err != nil {
+			return nil, fmt.Errorf("%w, pass format explicitly", err)
+		}
+		format = &detected
+	}
+
+	strip, err := starlarkhelpers.FindIntInKwargs(kwargs, "strip_components", 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find strip_components in kwargs: %w", err)
+	}
+	if strip < 0 {
+		return nil, fmt.Errorf("strip_components must not be negative, got %d", strip)
+	}
+	whatIf, err := starlarkhelpers.FindBoolInKwargs(kwargs, "what_if", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find what_if in kwargs: %w", err)
+	}
+
+	creates, err := starlarkhelpers.FindOptionalStringInKwargs(kwargs, "creates")
+	if err != nil {
+		return nil, err
+	}
+	if creates != nil {
+		createsPath, err := fileutils.ExpandPath(*creates)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := a.fsys.Stat(createsPath); err == nil {
+			return &base.Result{
+				Label: label,
+				Message: func() *string {
+					s := fmt.Sprintf("skipped extracting %q because %q exists", srcPath, createsPath)
+					return &s
+				}(),
+				Success: true,
+				Changed: false,
+				Return:  starlark.NewList(nil),
+			}, nil
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to stat %q: %w", createsPath, err)
+		}
+	}
+
+	logging.Log(label, deck.V(2), "info", "extracting %q (%s) to %q", srcPath, *format, destPath)
+	extracted, err := archive.Extract(a.fsys, srcPath, destPath, *format, archive.Options{
+		StripComponents: int(strip),
+		DryRun:          whatIf,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract %q: %w", srcPath, err)
+	}
+
+	files := make([]starlark.Value, 0, len(extracted.Extracted))
+	for _, f := range extracted.Extracted {
+		files = append(files, starlark.String(f))
+	}
+
+	var msg string
+	switch {
+	case whatIf:
+		msg = fmt.Sprintf("would extract %d entries from %q to %q, %d of them would change", len(extracted.Extracted), srcPath, destPath, len(extracted.Changed))
+	case len(extracted.Changed) == 0:
+		msg = fmt.Sprintf("all %d entries from %q are already extracted to %q", len(extracted.Extracted), srcPath, destPath)
+	default:
+		msg = fmt.Sprintf("extracted %d entries from %q to %q, %d of them changed", len(extracted.Extracted), srcPath, destPath, len(extracted.Changed))
+	}
+
+	return &base.Result{
+		Label:   label,
+		Message: &msg,
+		Success: true,
+		// Nothing is written in a what_if run, so it never changes anything.
+		Changed: !whatIf && len(extracted.Changed) > 0,
+		Return:  starlark.NewList(files),
+	}, nil
+}
+
+func New(ctx context.Context, fsys afero.Fs) *base.Module {
+	var (
+		src             string
+		dest            string
+		format          string
+		stripComponents int64
+		creates         string
+	)
+
+	return base.NewModule(
+		ctx,
+		"unarchive",
+		[]base.ArgPair{
+			{Key: "src", Type: &src},
+			{Key: "dest", Type: &dest},
+			{Key: "format??", Type: &format},
+			{Key: "strip_components??", Type: &stripComponents},
+			{Key: "creates??", Type: &creates},
+		},
+		&unarchiveAction{
+			fsys: fsys,
+		},
+	)
+}
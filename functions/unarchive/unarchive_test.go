@@ -0,0 +1,264 @@
+package unarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/discentem/starcm/libraries/archive"
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+// tarball returns a tar archive of name/content pairs; names ending in "/" are directories.
+func tarball(t *testing.T, entries ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		name, body := entries[i], entries[i+1]
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.String()
+}
+
+func gzipped(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.String()
+}
+
+func TestUnarchiveAction_Run(t *testing.T) {
+	pkg := tarball(t, "pkg-1.0/", "", "pkg-1.0/bin/tool", "tool", "pkg-1.0/README", "readme")
+
+	tests := []struct {
+		name            string
+		setupFs         func() afero.Fs
+		kwargs          []starlark.Tuple
+		wantErr         error
+		wantErrContains string
+		expectedChanged bool
+		expectedReturn  []string
+		assertFs        func(t *testing.T, fs afero.Fs)
+	}{
+		{
+			name: "extracts a new archive",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/pkg.tar", Content: pkg})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			expectedChanged: true,
+			expectedReturn:  []string{"pkg-1.0", "pkg-1.0/bin/tool", "pkg-1.0/README"},
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/opt/pkg-1.0/bin/tool")
+				require.NoError(t, err)
+				require.Equal(t, "tool", string(b))
+			},
+		},
+		{
+			name: "no change when already extracted",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/tmp/pkg.tar", Content: pkg},
+					FileDefinition{Path: "/opt/pkg-1.0/bin/tool", Content: "tool"},
+					FileDefinition{Path: "/opt/pkg-1.0/README", Content: "readme"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			expectedChanged: false,
+			expectedReturn:  []string{"pkg-1.0", "pkg-1.0/bin/tool", "pkg-1.0/README"},
+		},
+		{
+			name: "changed when a file differs",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/tmp/pkg.tar", Content: pkg},
+					FileDefinition{Path: "/opt/pkg-1.0/bin/tool", Content: "old tool"},
+					FileDefinition{Path: "/opt/pkg-1.0/README", Content: "readme"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			expectedChanged: true,
+			expectedReturn:  []string{"pkg-1.0", "pkg-1.0/bin/tool", "pkg-1.0/README"},
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/opt/pkg-1.0/bin/tool")
+				require.NoError(t, err)
+				require.Equal(t, "tool", string(b))
+			},
+		},
+		{
+			name: "creates skips extraction",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(
+					FileDefinition{Path: "/tmp/pkg.tar", Content: pkg},
+					FileDefinition{Path: "/opt/pkg-1.0/bin/tool", Content: "old tool"},
+				)
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+				{starlark.String("creates"), starlark.String("/opt/pkg-1.0/bin/tool")},
+			},
+			expectedChanged: false,
+			expectedReturn:  []string{},
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/opt/pkg-1.0/bin/tool")
+				require.NoError(t, err)
+				require.Equal(t, "old tool", string(b))
+			},
+		},
+		{
+			name: "strip_components drops leading directories",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/pkg.tar", Content: pkg})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt/pkg")},
+				{starlark.String("strip_components"), starlark.MakeInt(1)},
+			},
+			expectedChanged: true,
+			expectedReturn:  []string{"bin/tool", "README"},
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				b, err := afero.ReadFile(fs, "/opt/pkg/README")
+				require.NoError(t, err)
+				require.Equal(t, "readme", string(b))
+			},
+		},
+		{
+			name: "format overrides the file name",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/download", Content: gzipped(t, pkg)})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/download")},
+				{starlark.String("dest"), starlark.String("/opt")},
+				{starlark.String("format"), starlark.String("tar.gz")},
+			},
+			expectedChanged: true,
+			expectedReturn:  []string{"pkg-1.0", "pkg-1.0/bin/tool", "pkg-1.0/README"},
+		},
+		{
+			name: "format is required when it cannot be detected",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/download", Content: pkg})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/download")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			wantErrContains: "pass format explicitly",
+		},
+		{
+			name: "what_if writes nothing and changes nothing",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/pkg.tar", Content: pkg})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+				{starlark.String("what_if"), starlark.True},
+			},
+			expectedChanged: false,
+			expectedReturn:  []string{"pkg-1.0", "pkg-1.0/bin/tool", "pkg-1.0/README"},
+			assertFs: func(t *testing.T, fs afero.Fs) {
+				exists, err := afero.Exists(fs, "/opt/pkg-1.0")
+				require.NoError(t, err)
+				require.False(t, exists)
+			},
+		},
+		{
+			name: "parent traversal is refused",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/evil.tar", Content: tarball(t, "../../etc/passwd", "x")})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/evil.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			wantErr: archive.ErrUnsafePath,
+		},
+		{
+			name: "absolute paths are refused",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/evil.tar", Content: tarball(t, "/etc/passwd", "x")})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/evil.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+			},
+			wantErr: archive.ErrUnsafePath,
+		},
+		{
+			name: "negative strip_components",
+			setupFs: func() afero.Fs {
+				return aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/tmp/pkg.tar", Content: pkg})
+			},
+			kwargs: []starlark.Tuple{
+				{starlark.String("src"), starlark.String("/tmp/pkg.tar")},
+				{starlark.String("dest"), starlark.String("/opt")},
+				{starlark.String("strip_components"), starlark.MakeInt(-1)},
+			},
+			wantErrContains: "must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := tt.setupFs()
+			action := &unarchiveAction{fsys: fs}
+			thread := starlark.Thread{Name: "test"}
+			result, err := action.Run(context.Background(), "/repo", "unarchive_test", &thread, nil, tt.kwargs)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if tt.wantErrContains != "" {
+				require.ErrorContains(t, err, tt.wantErrContains)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, result.Success)
+			require.Equal(t, tt.expectedChanged, result.Changed)
+			var got []string
+			list := result.Return.(*starlark.List)
+			for i := 0; i < list.Len(); i++ {
+				got = append(got, string(list.Index(i).(starlark.String)))
+			}
+			if len(tt.expectedReturn) == 0 {
+				require.Empty(t, got)
+			} else {
+				require.Equal(t, tt.expectedReturn, got)
+			}
+			if tt.assertFs != nil {
+				tt.assertFs(t, fs)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarXz  = "tar.xz"
+	FormatTarZst = "tar.zst"
+	FormatZip    = "zip"
+)
+
+var suffixes = []struct {
+	suffix string
+	format string
+}{
+	{".tar.gz", FormatTarGz},
+	{".tgz", FormatTarGz},
+	{".tar.xz", FormatTarXz},
+	{".txz", FormatTarXz},
+	{".tar.zst", FormatTarZst},
+	{".tzst", FormatTarZst},
+	{".tar", FormatTar},
+	{".zip", FormatZip},
+}
+
+// ErrUnsafePath is returned when an archive entry would be written outside of the destination directory.
+var ErrUnsafePath = errors.New("archive entry escapes destination")
+
+// DetectFormat infers the archive format from the file name.
+func DetectFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	for _, s := range suffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.format, nil
+		}
+	}
+	return "", fmt.Errorf("cannot infer archive format from %q", name)
+}
+
+// Result lists the entries of an extracted archive, relative to dest.
+type Result struct {
+	// Extracted holds every entry, and Changed the ones that were written because they did not already exist
+	// with the same content, mode or link target.
+	Extracted []string
+	Changed   []string
+}
+
+type Options struct {
+	// StripComponents removes this many leading path components from every entry. Entries with
+	// fewer components are skipped.
+	StripComponents int
+	// DryRun lists the entries that would be extracted, and those that would change, without writing anything.
+	DryRun bool
+}
+
+// Extract unpacks the archive at src into dest. Entries that would land outside of dest, through ".."
+// components, absolute paths or symlinks, cause ErrUnsafePath.
+func Extract(fsys afero.Fs, src, dest, format string, opts Options) (*Result, error) {
+	f, err := fsys.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %q: %w", src, err)
+	}
+	defer f.Close()
+
+	x := &extractor{fsys: fsys, dest: filepath.Clean(dest), opts: opts, links: map[string]bool{}, traversed: map[string]bool{}}
+	if !opts.DryRun {
+		if err := fsys.MkdirAll(x.dest, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create destination %q: %w", dest, err)
+		}
+	}
+
+	switch format {
+	case FormatZip:
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		err = x.zip(f, info.Size())
+		return &x.result, err
+	case FormatTar:
+		err = x.tar(f)
+	case FormatTarGz:
+		gz, gzErr := gzip.NewReader(f)
+		if gzErr != nil {
+			return nil, fmt.Errorf("failed to read gzip stream: %w", gzErr)
+		}
+		defer gz.Close()
+		err = x.tar(gz)
+	case FormatTarXz:
+		xr, xzErr := xz.NewReader(f)
+		if xzErr != nil {
+			return nil, fmt.Errorf("failed to read xz stream: %w", xzErr)
+		}
+		err = x.tar(xr)
+	case FormatTarZst:
+		zr, zstErr := zstd.NewReader(f)
+		if zstErr != nil {
+			return nil, fmt.Errorf("failed to read zstd stream: %w", zstErr)
+		}
+		defer zr.Close()
+		err = x.tar(zr)
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+	return &x.result, err
+}
+
+type extractor struct {
+	fsys   afero.Fs
+	dest   string
+	opts   Options
+	result Result
+	// links holds the relative paths of the symlinks extracted so far. Entries below them are refused, since
+	// where they land on disk depends on the link target rather than on the entry name.
+	links map[string]bool
+	// traversed holds the directories that extracted symlink targets step through. They may not become
+	// symlinks later, which would move where the earlier links point.
+	traversed map[string]bool
+}
+
+// target strips and validates an entry name, returning the cleaned relative path and the
+// absolute path under dest. An empty rel means the entry should be skipped.
+func (x *extractor) target(name string) (rel string, abs string, err error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if strings.HasPrefix(name, "/") {
+		return "", "", fmt.Errorf("%w: %q is absolute", ErrUnsafePath, name)
+	}
+	if len(parts) <= x.opts.StripComponents {
+		return "", "", nil
+	}
+	rel = path.Clean(strings.Join(parts[x.opts.StripComponents:], "/"))
+	if rel == "." {
+		return "", "", nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	if link := x.throughLink(path.Dir(rel)); link != "" {
+		return "", "", fmt.Errorf("%w: %q is below symlink %q", ErrUnsafePath, name, link)
+	}
+	return rel, filepath.Join(x.dest, filepath.FromSlash(rel)), nil
+}
+
+// throughLink returns the first extracted symlink among dir and its parents, or "" if there is none.
+func (x *extractor) throughLink(dir string) string {
+	p := ""
+	for _, part := range strings.Split(dir, "/") {
+		if part == "." {
+			continue
+		}
+		p = path.Join(p, part)
+		if x.links[p] {
+			return p
+		}
+	}
+	return ""
+}
+
+// checkLink rejects symlinks whose target resolves outside of dest. The target is resolved one component at
+// a time and may not step through another extracted symlink, so that chained links like "a -> .." and
+// "a/b -> .." cannot climb out of dest together.
+func (x *extractor) checkLink(rel, linkname string) error {
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("%w: symlink %q points to absolute path %q", ErrUnsafePath, rel, linkname)
+	}
+	if x.traversed[rel] {
+		return fmt.Errorf("%w: symlink %q replaces a directory an earlier symlink points through", ErrUnsafePath, rel)
+	}
+	var resolved, steps []string
+	if dir := path.Dir(rel); dir != "." {
+		resolved = strings.Split(dir, "/")
+	}
+	for _, part := range strings.Split(linkname, "/") {
+		switch part {
+		case "", ".":
+		case "..":
+			if len(resolved) == 0 {
+				return fmt.Errorf("%w: symlink %q points to %q", ErrUnsafePath, rel, linkname)
+			}
+			steps = append(steps, strings.Join(resolved, "/"))
+			resolved = resolved[:len(resolved)-1]
+		default:
+			resolved = append(resolved, part)
+			if p := strings.Join(resolved, "/"); x.links[p] {
+				return fmt.Errorf("%w: symlink %q points through symlink %q", ErrUnsafePath, rel, p)
+			}
+		}
+	}
+	for _, step := range steps {
+		x.traversed[step] = true
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(rel, abs string, mode os.FileMode) error {
+	if info, err := x.fsys.Stat(abs); err == nil && info.IsDir() {
+		return nil
+	}
+	x.changed(rel)
+	if x.opts.DryRun {
+		return nil
+	}
+	if err := x.fsys.MkdirAll(abs, mode|0700); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", abs, err)
+	}
+	return nil
+}
+
+// changed records that the entry at rel is, or in a dry run would be, written.
+func (x *extractor) changed(rel string) {
+	x.result.Changed = append(x.result.Changed, rel)
+}
+
+func (x *extractor) writeFile(rel, abs string, r io.Reader, mode os.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	// An existing file with the same mode is left alone when its content matches. At most its size plus one
+	// byte of the entry is read to find out, and whatever was read is written first otherwise.
+	if info, err := x.fsys.Stat(abs); err == nil && info.Mode().IsRegular() && info.Mode().Perm() == mode {
+		head, err := io.ReadAll(io.LimitReader(r, info.Size()+1))
+		if err != nil {
+			return fmt.Errorf("failed to read entry for %q: %w", abs, err)
+		}
+		existing, err := afero.ReadFile(x.fsys, abs)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", abs, err)
+		}
+		if bytes.Equal(head, existing) {
+			return nil
+		}
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+	x.changed(rel)
+	if x.opts.DryRun {
+		return nil
+	}
+	if err := x.fsys.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", abs, err)
+	}
+	out, err := x.fsys.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create %q: %w", abs, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to write %q: %w", abs, err)
+	}
+	return x.fsys.Chmod(abs, mode)
+}
+
+func (x *extractor) symlink(rel, abs, linkname string) error {
+	if err := x.checkLink(rel, linkname); err != nil {
+		return err
+	}
+	x.links[rel] = true
+	if reader, ok := x.fsys.(afero.LinkReader); ok {
+		if existing, err := reader.ReadlinkIfPossible(abs); err == nil && existing == linkname {
+			return nil
+		}
+	}
+	x.changed(rel)
+	if x.opts.DryRun {
+		return nil
+	}
+	linker, ok := x.fsys.(afero.Linker)
+	if !ok {
+		return fmt.Errorf("cannot create symlink %q: filesystem does not support symlinks", rel)
+	}
+	if err := x.fsys.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %q: %w", abs, err)
+	}
+	_ = x.fsys.Remove(abs)
+	return linker.SymlinkIfPossible(linkname, abs)
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+		rel, abs, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if rel == "" {
+			continue
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(rel, abs, mode)
+		case tar.TypeReg:
+			err = x.writeFile(rel, abs, tr, mode)
+		case tar.TypeSymlink:
+			err = x.symlink(rel, abs, hdr.Linkname)
+		case tar.TypeLink:
+			// Hard links refer to an earlier entry in the same archive, so copy its content.
+			linkRel, linkAbs, linkErr := x.target(hdr.Linkname)
+			if linkErr != nil {
+				return linkErr
+			}
+			if linkRel == "" {
+				return fmt.Errorf("hard link %q points to stripped entry %q", hdr.Name, hdr.Linkname)
+			}
+			err = x.copyExtracted(linkAbs, rel, abs, mode)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return fmt.Errorf("unsupported tar entry type %q for %q", hdr.Typeflag, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+		x.result.Extracted = append(x.result.Extracted, rel)
+	}
+}
+
+func (x *extractor) copyExtracted(src, rel, dst string, mode os.FileMode) error {
+	if x.opts.DryRun {
+		x.changed(rel)
+		return nil
+	}
+	in, err := x.fsys.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open hard link target %q: %w", src, err)
+	}
+	defer in.Close()
+	return x.writeFile(rel, dst, in, mode)
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	for _, zf := range zr.File {
+		rel, abs, err := x.target(zf.Name)
+		if err != nil {
+			return err
+		}
+		if rel == "" {
+			continue
+		}
+		info := zf.FileInfo()
+		mode := info.Mode().Perm()
+
+		switch {
+		case info.IsDir():
+			err = x.mkdir(rel, abs, mode)
+		case info.Mode()&os.ModeSymlink != 0:
+			err = x.zipSymlink(zf, rel, abs)
+		default:
+			err = x.zipFile(zf, rel, abs, mode)
+		}
+		if err != nil {
+			return err
+		}
+		x.result.Extracted = append(x.result.Extracted, rel)
+	}
+	return nil
+}
+
+func (x *extractor) zipFile(zf *zip.File, rel, abs string, mode os.FileMode) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open zip entry %q: %w", zf.Name, err)
+	}
+	defer rc.Close()
+	return x.writeFile(rel, abs, rc, mode)
+}
+
+func (x *extractor) zipSymlink(zf *zip.File, rel, abs string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open zip entry %q: %w", zf.Name, err)
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(rc)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink %q: %w", zf.Name, err)
+	}
+	return x.symlink(rel, abs, string(target))
+}
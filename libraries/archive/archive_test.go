@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+type entry struct {
+	name     string
+	body     string
+	dir      bool
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case e.linkname != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.linkname, 0
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func compress(t *testing.T, format string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch format {
+	case FormatTar:
+		return data
+	case FormatTarGz:
+		w = gzip.NewWriter(&buf)
+	case FormatTarXz:
+		w, err = xz.NewWriter(&buf)
+	case FormatTarZst:
+		w, err = zstd.NewWriter(&buf)
+	}
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func buildZip(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	entries := []entry{
+		{name: "pkg-1.0/", dir: true},
+		{name: "pkg-1.0/bin/tool", body: "tool"},
+		{name: "pkg-1.0/README", body: "readme"},
+	}
+
+	for _, format := range []string{FormatTar, FormatTarGz, FormatTarXz, FormatTarZst} {
+		t.Run(format, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/tmp/pkg."+format, compress(t, format, buildTar(t, entries)), 0644))
+
+			extracted, err := Extract(fs, "/tmp/pkg."+format, "/opt/pkg", format, Options{StripComponents: 1})
+			require.NoError(t, err)
+			require.Equal(t, []string{"bin/tool", "README"}, extracted.Extracted)
+
+			b, err := afero.ReadFile(fs, "/opt/pkg/bin/tool")
+			require.NoError(t, err)
+			require.Equal(t, "tool", string(b))
+		})
+	}
+
+	t.Run(FormatZip, func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/tmp/pkg.zip", buildZip(t, entries[1:]), 0644))
+
+		extracted, err := Extract(fs, "/tmp/pkg.zip", "/opt/pkg", FormatZip, Options{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"pkg-1.0/bin/tool", "pkg-1.0/README"}, extracted.Extracted)
+
+		b, err := afero.ReadFile(fs, "/opt/pkg/pkg-1.0/README")
+		require.NoError(t, err)
+		require.Equal(t, "readme", string(b))
+	})
+
+	t.Run("dry run writes nothing", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/tmp/pkg.tar", buildTar(t, entries), 0644))
+
+		extracted, err := Extract(fs, "/tmp/pkg.tar", "/opt/pkg", FormatTar, Options{DryRun: true})
+		require.NoError(t, err)
+		require.Len(t, extracted.Extracted, 3)
+		require.Equal(t, extracted.Extracted, extracted.Changed)
+		exists, err := afero.Exists(fs, "/opt/pkg")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+}
+
+func TestExtract_Changed(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/tmp/pkg.tar", buildTar(t, []entry{
+		{name: "bin/", dir: true},
+		{name: "bin/tool", body: "tool"},
+		{name: "README", body: "readme"},
+	}), 0644))
+
+	extracted, err := Extract(fs, "/tmp/pkg.tar", "/opt/pkg", FormatTar, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bin", "bin/tool", "README"}, extracted.Changed)
+
+	extracted, err = Extract(fs, "/tmp/pkg.tar", "/opt/pkg", FormatTar, Options{})
+	require.NoError(t, err)
+	require.Len(t, extracted.Extracted, 3)
+	require.Empty(t, extracted.Changed)
+
+	// A longer, a shorter and a re-moded file all count as changed.
+	require.NoError(t, afero.WriteFile(fs, "/opt/pkg/bin/tool", []byte("tool v2"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/opt/pkg/README", []byte("read"), 0644))
+	extracted, err = Extract(fs, "/tmp/pkg.tar", "/opt/pkg", FormatTar, Options{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bin/tool", "README"}, extracted.Changed)
+	b, err := afero.ReadFile(fs, "/opt/pkg/README")
+	require.NoError(t, err)
+	require.Equal(t, "read", string(b))
+
+	require.NoError(t, fs.Chmod("/opt/pkg/bin/tool", 0600))
+	require.NoError(t, afero.WriteFile(fs, "/opt/pkg/bin/tool", []byte("tool"), 0600))
+	extracted, err = Extract(fs, "/tmp/pkg.tar", "/opt/pkg", FormatTar, Options{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"bin/tool", "README"}, extracted.Changed)
+	b, err = afero.ReadFile(fs, "/opt/pkg/bin/tool")
+	require.NoError(t, err)
+	require.Equal(t, "tool", string(b))
+}
+
+func TestExtract_UnsafePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		zip     bool
+	}{
+		{name: "parent traversal in tar", entries: []entry{{name: "../../etc/passwd", body: "x"}}},
+		{name: "absolute path in tar", entries: []entry{{name: "/etc/passwd", body: "x"}}},
+		{name: "traversal after strip", entries: []entry{{name: "a/../../passwd", body: "x"}}},
+		{name: "escaping symlink", entries: []entry{{name: "link", linkname: "../../etc"}}},
+		{name: "absolute symlink", entries: []entry{{name: "link", linkname: "/etc"}}},
+		{name: "parent traversal in zip", entries: []entry{{name: "../evil", body: "x"}}, zip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			format := FormatTar
+			data := func() []byte {
+				if tt.zip {
+					format = FormatZip
+					return buildZip(t, tt.entries)
+				}
+				return buildTar(t, tt.entries)
+			}()
+			require.NoError(t, afero.WriteFile(fs, "/tmp/evil", data, 0644))
+
+			_, err := Extract(fs, "/tmp/evil", "/opt/pkg", format, Options{})
+			require.ErrorIs(t, err, ErrUnsafePath)
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.tar":     FormatTar,
+		"a.TGZ":     FormatTarGz,
+		"a.tar.gz":  FormatTarGz,
+		"a.tar.xz":  FormatTarXz,
+		"a.tar.zst": FormatTarZst,
+		"a.zip":     FormatZip,
+	} {
+		got, err := DetectFormat(name)
+		require.NoError(t, err)
+		require.Equal(t, want, got, name)
+	}
+	_, err := DetectFormat("a.rar")
+	require.Error(t, err)
+}
+
+func TestExtract_ChainedSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{
+			// On paper d/a/b resolves to d, but on disk it is the parent of dest because d/a is a link.
+			name: "entry below a link",
+			entries: []entry{
+				{name: "d/", dir: true},
+				{name: "d/a", linkname: ".."},
+				{name: "d/a/b", linkname: ".."},
+				{name: "d/a/b/evil", body: "x"},
+			},
+		},
+		{
+			name: "link target through a link",
+			entries: []entry{
+				{name: "d/", dir: true},
+				{name: "d/a", linkname: ".."},
+				{name: "up", linkname: "d/a/.."},
+				{name: "up/evil", body: "x"},
+			},
+		},
+		{
+			name: "link replaces a directory an earlier link climbs out of",
+			entries: []entry{
+				{name: "up", linkname: "z/.."},
+				{name: "z", linkname: "."},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := afero.NewOsFs()
+			src := filepath.Join(root, "evil.tar")
+			dest := filepath.Join(root, "out", "dest")
+			require.NoError(t, afero.WriteFile(fs, src, buildTar(t, tt.entries), 0644))
+
+			_, err := Extract(fs, src, dest, FormatTar, Options{})
+			require.ErrorIs(t, err, ErrUnsafePath)
+			for _, p := range []string{filepath.Join(root, "evil"), filepath.Join(root, "out", "evil")} {
+				exists, err := afero.Exists(fs, p)
+				require.NoError(t, err)
+				require.False(t, exists, "%s was written outside of dest", p)
+			}
+		})
+	}
+
+	t.Run("links inside dest still extract", func(t *testing.T) {
+		root := t.TempDir()
+		fs := afero.NewOsFs()
+		src := filepath.Join(root, "pkg.tar")
+		dest := filepath.Join(root, "dest")
+		require.NoError(t, afero.WriteFile(fs, src, buildTar(t, []entry{
+			{name: "lib/", dir: true},
+			{name: "lib/libfoo.so.1", body: "foo"},
+			{name: "lib/libfoo.so", linkname: "libfoo.so.1"},
+			{name: "bin/foo", linkname: "../lib/libfoo.so.1"},
+		}), 0644))
+
+		_, err := Extract(fs, src, dest, FormatTar, Options{})
+		require.NoError(t, err)
+		b, err := afero.ReadFile(fs, filepath.Join(dest, "bin", "foo"))
+		require.NoError(t, err)
+		require.Equal(t, "foo", string(b))
+
+		extracted, err := Extract(fs, src, dest, FormatTar, Options{})
+		require.NoError(t, err)
+		require.Empty(t, extracted.Changed)
+	})
+}
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/spf13/afero"
+)
+
+// Cache is a content-addressed store of downloaded files, laid out as <dir>/<algo>/<first two hex chars>/<hex>.
+// Entries are copied in and out rather than hardlinked, so that changing the mode, owner or content of a
+// restored file never changes the cache entry, and touching the entry never changes the file.
+type Cache struct {
+	fsys afero.Fs
+	dir  string
+}
+
+// New returns a Cache rooted at dir. The directory is created on first use.
+func New(fsys afero.Fs, dir string) *Cache {
+	return &Cache{fsys: fsys, dir: dir}
+}
+
+// DefaultDir returns the per-user download cache directory, e.g. ~/.cache/starcm/downloads on Linux.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "starcm", "downloads"), nil
+}
+
+// Dir returns the root directory of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) path(sum checksum.Checksum) string {
+	return filepath.Join(c.dir, sum.Algo, sum.Hex[:2], sum.Hex)
+}
+
+// Restore copies the cached entry for sum to dst and reports whether there was one. Entries that no longer
+// match their checksum, for example because the cache directory was edited by hand, are evicted.
+func (c *Cache) Restore(sum checksum.Checksum, dst string) (bool, error) {
+	p := c.path(sum)
+	f, err := c.fsys.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	verifyErr := sum.Verify(f)
+	f.Close()
+	if verifyErr != nil {
+		_ = c.fsys.Remove(p)
+		return false, nil
+	}
+
+	now := time.Now()
+	// Record the access so that prune can tell recently used entries apart.
+	_ = c.fsys.Chtimes(p, now, now)
+	if err := c.copyFile(p, dst); err != nil {
+		return false, fmt.Errorf("failed to restore %q from cache: %w", dst, err)
+	}
+	return true, nil
+}
+
+// Put adds the file at src to the cache under sum. The caller must already have verified src.
+func (c *Cache) Put(sum checksum.Checksum, src string) error {
+	p := c.path(sum)
+	if _, err := c.fsys.Stat(p); err == nil {
+		return nil
+	}
+	if err := c.fsys.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return c.copyFile(src, p)
+}
+
+// ReadFile returns the content of the cached entry for sum and whether there was one. Corrupt entries are
+// evicted, as in Restore.
+func (c *Cache) ReadFile(sum checksum.Checksum) ([]byte, bool, error) {
+	p := c.path(sum)
+	data, err := afero.ReadFile(c.fsys, p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := sum.Verify(bytes.NewReader(data)); err != nil {
+		_ = c.fsys.Remove(p)
+		return nil, false, nil
+	}
+	now := time.Now()
+	_ = c.fsys.Chtimes(p, now, now)
+	return data, true, nil
+}
+
+// WriteFile adds data to the cache under sum. The caller must already have verified data.
+func (c *Cache) WriteFile(sum checksum.Checksum, data []byte) error {
+	p := c.path(sum)
+	if err := c.fsys.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp := p + ".tmp"
+	if err := afero.WriteFile(c.fsys, tmp, data, 0644); err != nil {
+		return err
+	}
+	return c.fsys.Rename(tmp, p)
+}
+
+// entryMode is the mode of cache entries and of the files restored from them, as for a fresh download.
+const entryMode = 0644
+
+// copyFile copies src to dst through a temporary file, so that dst is never left half written.
+func (c *Cache) copyFile(src, dst string) error {
+	in, err := c.fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := c.fsys.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entryMode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = c.fsys.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = c.fsys.Remove(tmp)
+		return err
+	}
+	return c.fsys.Rename(tmp, dst)
+}
+
+// PruneResult summarizes a Prune.
+type PruneResult struct {
+	Removed int
+	Freed   int64
+}
+
+// Prune removes entries that have not been used for longer than olderThan. A zero olderThan empties the cache.
+func (c *Cache) Prune(olderThan time.Duration) (PruneResult, error) {
+	var result PruneResult
+	cutoff := time.Now().Add(-olderThan)
+	err := afero.Walk(c.fsys, c.dir, func(p string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || (olderThan > 0 && info.ModTime().After(cutoff)) {
+			return nil
+		}
+		if err := c.fsys.Remove(p); err != nil {
+			return fmt.Errorf("failed to remove cache entry %q: %w", p, err)
+		}
+		result.Removed++
+		result.Freed += info.Size()
+		return nil
+	})
+	return result, err
+}
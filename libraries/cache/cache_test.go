@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+var helloWorld = checksum.Checksum{Algo: checksum.SHA256, Hex: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"}
+
+func TestPutRestore(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/cache")
+	require.NoError(t, afero.WriteFile(fs, "/downloads/a.txt", []byte("hello world"), 0644))
+
+	found, err := c.Restore(helloWorld, "/downloads/b.txt")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, c.Put(helloWorld, "/downloads/a.txt"))
+	b, err := afero.ReadFile(fs, "/cache/sha256/b9/"+helloWorld.Hex)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(b))
+
+	found, err = c.Restore(helloWorld, "/downloads/b.txt")
+	require.NoError(t, err)
+	require.True(t, found)
+	b, err = afero.ReadFile(fs, "/downloads/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(b))
+}
+
+func TestRestore_CopiesInsteadOfLinking(t *testing.T) {
+	dir := t.TempDir()
+	fs := afero.NewOsFs()
+	c := New(fs, filepath.Join(dir, "cache"))
+	src := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(src, []byte("hello world"), 0644))
+	require.NoError(t, c.Put(helloWorld, src))
+
+	dst := filepath.Join(dir, "b.txt")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	found, err := c.Restore(helloWorld, dst)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.NoError(t, os.Chtimes(dst, old, old))
+	require.NoError(t, os.Chmod(dst, 0700))
+
+	// Another restore touches the entry; neither that nor the chmod of dst may reach the other file.
+	found, err = c.Restore(helloWorld, filepath.Join(dir, "c.txt"))
+	require.NoError(t, err)
+	require.True(t, found)
+	entry, err := os.Stat(c.path(helloWorld))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), entry.Mode().Perm())
+	info, err := os.Stat(dst)
+	require.NoError(t, err)
+	require.Equal(t, old, info.ModTime())
+	for _, p := range []string{src, dst} {
+		info, err := os.Stat(p)
+		require.NoError(t, err)
+		require.False(t, os.SameFile(entry, info), p)
+	}
+}
+
+func TestRestore_EvictsCorruptEntries(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/cache")
+	require.NoError(t, afero.WriteFile(fs, "/cache/sha256/b9/"+helloWorld.Hex, []byte("tampered"), 0644))
+
+	found, err := c.Restore(helloWorld, "/downloads/b.txt")
+	require.NoError(t, err)
+	require.False(t, found)
+	exists, err := afero.Exists(fs, "/cache/sha256/b9/"+helloWorld.Hex)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestPrune(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/cache")
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, afero.WriteFile(fs, "/cache/sha256/aa/old", []byte("old"), 0644))
+	require.NoError(t, fs.Chtimes("/cache/sha256/aa/old", old, old))
+	require.NoError(t, afero.WriteFile(fs, "/cache/sha256/bb/new", []byte("new!"), 0644))
+
+	result, err := c.Prune(24 * time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: 3}, result)
+
+	result, err = c.Prune(0)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{Removed: 1, Freed: 4}, result)
+
+	result, err = New(fs, "/missing").Prune(0)
+	require.NoError(t, err)
+	require.Equal(t, PruneResult{}, result)
+}
+
+func TestReadWriteFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	c := New(fs, "/cache")
+
+	_, found, err := c.ReadFile(helloWorld)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, c.WriteFile(helloWorld, []byte("hello world")))
+	b, found, err := c.ReadFile(helloWorld)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "hello world", string(b))
+
+	require.NoError(t, afero.WriteFile(fs, "/cache/sha256/b9/"+helloWorld.Hex, []byte("tampered"), 0644))
+	_, found, err = c.ReadFile(helloWorld)
+	require.NoError(t, err)
+	require.False(t, found)
+}
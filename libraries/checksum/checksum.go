@@ -0,0 +1,141 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// Checksum is an expected digest, written as "algo:hex", e.g. "sha512:cf83e1...".
+type Checksum struct {
+	Algo string
+	Hex  string
+}
+
+func (c Checksum) String() string {
+	return c.Algo + ":" + c.Hex
+}
+
+// NewHash returns a hash.Hash for algo.
+func NewHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case SHA1:
+		return sha1.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q, must be one of %s, %s or %s", algo, SHA1, SHA256, SHA512)
+	}
+}
+
+// algoForLength guesses the algorithm of a bare hex digest from its length.
+func algoForLength(hexDigest string) (string, error) {
+	switch len(hexDigest) {
+	case sha1.Size * 2:
+		return SHA1, nil
+	case sha256.Size * 2:
+		return SHA256, nil
+	case sha512.Size * 2:
+		return SHA512, nil
+	default:
+		return "", fmt.Errorf("cannot infer checksum algorithm from %d hex characters", len(hexDigest))
+	}
+}
+
+// Parse parses "algo:hex". A bare hex digest is accepted when its length identifies the algorithm.
+func Parse(s string) (Checksum, error) {
+	s = strings.TrimSpace(s)
+	algo, digest, found := strings.Cut(s, ":")
+	if !found {
+		digest = s
+		var err error
+		if algo, err = algoForLength(digest); err != nil {
+			return Checksum{}, err
+		}
+	}
+	algo = strings.ToLower(algo)
+	digest = strings.ToLower(digest)
+	h, err := NewHash(algo)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != h.Size()*2 {
+		return Checksum{}, fmt.Errorf("%q is not a valid %s digest", digest, algo)
+	}
+	return Checksum{Algo: algo, Hex: digest}, nil
+}
+
+// FromReader computes the digest of r with algo.
+func FromReader(algo string, r io.Reader) (Checksum, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return Checksum{}, err
+	}
+	return Checksum{Algo: algo, Hex: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Verify reads r and reports an error if its digest does not match c.
+func (c Checksum) Verify(r io.Reader) error {
+	actual, err := FromReader(c.Algo, r)
+	if err != nil {
+		return err
+	}
+	if actual.Hex != c.Hex {
+		return fmt.Errorf("expected %s, got %s", c, actual)
+	}
+	return nil
+}
+
+var bsdLine = regexp.MustCompile(`^(SHA1|SHA256|SHA512) \((.+)\) = ([0-9a-fA-F]+)$`)
+
+// FromSumsFile finds the checksum for filename in the output of sha256sum and friends
+// ("<hex>  <name>" or "<hex> *<name>") or their BSD-style "--tag" output ("SHA256 (<name>) = <hex>").
+// Entries are matched on their base name so that "./dist/tool.tar.gz" matches "tool.tar.gz".
+func FromSumsFile(data []byte, filename string) (Checksum, error) {
+	want := path.Base(filename)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := bsdLine.FindStringSubmatch(line); m != nil {
+			if path.Base(m[2]) == want {
+				return Parse(strings.ToLower(m[1]) + ":" + m[3])
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		if path.Base(name) == want {
+			return Parse(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Checksum{}, err
+	}
+	return Checksum{}, fmt.Errorf("no checksum for %q found", want)
+}
@@ -0,0 +1,65 @@
+package checksum
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helloSHA1   = "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed"
+	helloSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	helloSHA512 = "309ecc489c12d6eb4cc40f50c902f2b4d0ed77ee511a7c7a9bcd3ca86d4cd86f989dd35bc5ff499670da34255b45b0cfd830e81f605dcf7dc5542e93ae9cd76f"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Checksum
+		wantErr bool
+	}{
+		{in: "sha1:" + helloSHA1, want: Checksum{SHA1, helloSHA1}},
+		{in: "SHA512:" + strings.ToUpper(helloSHA512), want: Checksum{SHA512, helloSHA512}},
+		{in: helloSHA256, want: Checksum{SHA256, helloSHA256}},
+		{in: "md5:5eb63bbbe01eeed093cb22bb8f5acdc3", wantErr: true},
+		{in: "sha256:" + helloSHA1, wantErr: true},
+		{in: "sha256:zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, c := range []Checksum{{SHA1, helloSHA1}, {SHA256, helloSHA256}, {SHA512, helloSHA512}} {
+		require.NoError(t, c.Verify(strings.NewReader("hello world")), c.Algo)
+		require.Error(t, c.Verify(strings.NewReader("goodbye world")), c.Algo)
+	}
+}
+
+func TestFromSumsFile(t *testing.T) {
+	sums := "# release checksums\n" +
+		"0000000000000000000000000000000000000000000000000000000000000000  tool-darwin.tar.gz\n" +
+		helloSHA256 + " *./dist/tool-linux.tar.gz\n" +
+		"SHA512 (tool-windows.zip) = " + helloSHA512 + "\n"
+
+	got, err := FromSumsFile([]byte(sums), "tool-linux.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, Checksum{SHA256, helloSHA256}, got)
+
+	got, err = FromSumsFile([]byte(sums), "https://example.com/releases/tool-windows.zip")
+	require.NoError(t, err)
+	require.Equal(t, Checksum{SHA512, helloSHA512}, got)
+
+	_, err = FromSumsFile([]byte(sums), "tool-freebsd.tar.gz")
+	require.Error(t, err)
+}
@@ -0,0 +1,126 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/discentem/starcm/libraries/logging"
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCustomDirs are the facts.d directories read when the workspace does not configure any.
+var DefaultCustomDirs = []string{"/etc/starcm/facts.d"}
+
+// DefaultCustomTimeout bounds each fact executable when CustomTimeout is not set.
+const DefaultCustomTimeout = 10 * time.Second
+
+// gatherCustom reads every facts.d directory into f.Custom, keyed by file name without its extension:
+// JSON and YAML files are parsed, and executables are run and their standard output parsed as JSON. A fact
+// that fails is left out and its error recorded in f.CustomErrors, so that one broken script does not fail
+// the run. When two directories provide the same fact, the later directory wins.
+func (g *Gatherer) gatherCustom(f *Facts) {
+	f.Custom = map[string]any{}
+	f.CustomErrors = map[string]string{}
+	for _, dir := range g.CustomDirs {
+		entries, err := afero.ReadDir(g.Fsys, dir)
+		if errors.Is(err, os.ErrNotExist) {
+			logging.Log("facts", deck.V(2), "info", "custom facts directory %q does not exist, skipping", dir)
+			continue
+		}
+		if err != nil {
+			logging.Log("facts", nil, "warn", "failed to read custom facts directory %q: %v", dir, err)
+			continue
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			p := filepath.Join(dir, entry.Name())
+			name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			value, err := g.customFact(p, entry)
+			if errors.Is(err, errNotAFact) {
+				logging.Log("facts", deck.V(2), "info", "%q is neither a JSON or YAML file nor executable, skipping", p)
+				continue
+			}
+			if err != nil {
+				logging.Log("facts", nil, "warn", "custom fact %q: %v", name, err)
+				delete(f.Custom, name)
+				f.CustomErrors[name] = fmt.Sprintf("%s: %v", p, err)
+				continue
+			}
+			delete(f.CustomErrors, name)
+			f.Custom[name] = value
+		}
+	}
+}
+
+var errNotAFact = errors.New("not a fact file")
+
+// customFact returns the value of the fact file at p.
+func (g *Gatherer) customFact(p string, info os.FileInfo) (any, error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".json":
+		data, err := afero.ReadFile(g.Fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		return starlarkhelpers.DecodeJSON(data)
+	case ".yaml", ".yml":
+		data, err := afero.ReadFile(g.Fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		var value any
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+	if info.Mode()&0111 == 0 {
+		return nil, errNotAFact
+	}
+
+	timeout := g.CustomTimeout
+	if timeout == 0 {
+		timeout = DefaultCustomTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := g.RunExecutable(ctx, p)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	value, err := starlarkhelpers.DecodeJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("output is not JSON: %w", err)
+	}
+	return value, nil
+}
+
+// runExecutable runs the fact executable at p and returns its standard output. Standard error is included in
+// the error when it fails.
+func runExecutable(ctx context.Context, p string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, p)
+	// A script killed on timeout can leave children holding its output open; stop waiting for them.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return out, err
+}
@@ -0,0 +1,285 @@
+package facts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+)
+
+// Facts describes the host starcm runs on.
+type Facts struct {
+	OS         OS
+	Kernel     Kernel
+	Arch       string
+	Hostname   string
+	CPUCount   int
+	Memory     Memory
+	Interfaces []Interface
+	Env        map[string]string
+	// Custom holds the facts from facts.d directories by name, and CustomErrors the ones that failed.
+	Custom       map[string]any
+	CustomErrors map[string]string
+}
+
+// OS is the operating system and, on Linux, the distribution from /etc/os-release.
+type OS struct {
+	// Family is runtime.GOOS, e.g. "linux" or "darwin".
+	Family string
+	// Distro is the os-release ID, e.g. "ubuntu", and Like its ID_LIKE, e.g. ["debian"].
+	Distro     string
+	Like       []string
+	Name       string
+	PrettyName string
+	Version    string
+	VersionID  string
+	Codename   string
+}
+
+// Kernel is read from /proc/sys/kernel.
+type Kernel struct {
+	Name    string
+	Release string
+	Version string
+}
+
+// Memory is read from /proc/meminfo.
+type Memory struct {
+	TotalBytes     int64
+	AvailableBytes int64
+}
+
+// Interface is a network interface and its addresses, without prefix lengths.
+type Interface struct {
+	Name      string
+	MAC       string
+	Up        bool
+	Loopback  bool
+	Addresses []string
+}
+
+// Gatherer collects Facts. Files are read through Fsys so that tests can fake /etc/os-release and /proc;
+// the other sources are functions for the same reason.
+type Gatherer struct {
+	Fsys       afero.Fs
+	GOOS       string
+	GOARCH     string
+	NumCPU     func() int
+	Hostname   func() (string, error)
+	Interfaces func() ([]Interface, error)
+	Environ    func() []string
+
+	// CustomDirs are the facts.d directories to read custom facts from.
+	CustomDirs []string
+	// CustomTimeout bounds each fact executable; zero means DefaultCustomTimeout.
+	CustomTimeout time.Duration
+	// RunExecutable runs a fact executable and returns its standard output.
+	RunExecutable func(ctx context.Context, path string) ([]byte, error)
+}
+
+// NewGatherer returns a Gatherer for the current host that reads files from fsys.
+func NewGatherer(fsys afero.Fs) *Gatherer {
+	return &Gatherer{
+		Fsys:       fsys,
+		GOOS:       runtime.GOOS,
+		GOARCH:     runtime.GOARCH,
+		NumCPU:     runtime.NumCPU,
+		Hostname:   os.Hostname,
+		Interfaces: netInterfaces,
+		Environ:    os.Environ,
+
+		CustomDirs:    DefaultCustomDirs,
+		RunExecutable: runExecutable,
+	}
+}
+
+// Gather collects the facts. Sources that are missing on this platform, like /proc on macOS, leave their
+// facts empty rather than failing.
+func (g *Gatherer) Gather() *Facts {
+	f := &Facts{
+		OS:       OS{Family: g.GOOS},
+		Arch:     g.GOARCH,
+		CPUCount: g.cpuCount(),
+		Env:      map[string]string{},
+	}
+
+	if release, ok := g.readFile("/etc/os-release"); ok {
+		f.OS = parseOSRelease(f.OS, release)
+	}
+
+	kernel := func(name string) string {
+		b, _ := g.readFile("/proc/sys/kernel/" + name)
+		return strings.TrimSpace(string(b))
+	}
+	f.Kernel = Kernel{Name: kernel("ostype"), Release: kernel("osrelease"), Version: kernel("version")}
+
+	f.Hostname = kernel("hostname")
+	if f.Hostname == "" {
+		if h, err := g.Hostname(); err == nil {
+			f.Hostname = h
+		} else {
+			logging.Log("facts", nil, "warn", "failed to read the hostname: %v", err)
+		}
+	}
+
+	if meminfo, ok := g.readFile("/proc/meminfo"); ok {
+		f.Memory = parseMeminfo(meminfo)
+	}
+
+	if ifaces, err := g.Interfaces(); err == nil {
+		f.Interfaces = ifaces
+	} else {
+		logging.Log("facts", nil, "warn", "failed to list network interfaces: %v", err)
+	}
+
+	for _, kv := range g.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			f.Env[k] = v
+		}
+	}
+
+	g.gatherCustom(f)
+	return f
+}
+
+// readFile returns the content of p, or false if it cannot be read.
+func (g *Gatherer) readFile(p string) ([]byte, bool) {
+	b, err := afero.ReadFile(g.Fsys, p)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logging.Log("facts", nil, "warn", "failed to read %q: %v", p, err)
+		} else {
+			logging.Log("facts", deck.V(2), "info", "%q does not exist, skipping", p)
+		}
+		return nil, false
+	}
+	return b, true
+}
+
+// cpuCount counts the processors in /proc/cpuinfo, falling back to NumCPU.
+func (g *Gatherer) cpuCount() int {
+	if cpuinfo, ok := g.readFile("/proc/cpuinfo"); ok {
+		n := 0
+		s := bufio.NewScanner(bytes.NewReader(cpuinfo))
+		for s.Scan() {
+			if key, _, ok := strings.Cut(s.Text(), ":"); ok && strings.TrimSpace(key) == "processor" {
+				n++
+			}
+		}
+		if n > 0 {
+			return n
+		}
+	}
+	return g.NumCPU()
+}
+
+// parseOSRelease fills o from the KEY=value lines of an os-release file.
+func parseOSRelease(o OS, release []byte) OS {
+	s := bufio.NewScanner(bytes.NewReader(release))
+	for s.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(s.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		switch key {
+		case "ID":
+			o.Distro = value
+		case "ID_LIKE":
+			o.Like = strings.Fields(value)
+		case "NAME":
+			o.Name = value
+		case "PRETTY_NAME":
+			o.PrettyName = value
+		case "VERSION":
+			o.Version = value
+		case "VERSION_ID":
+			o.VersionID = value
+		case "VERSION_CODENAME":
+			o.Codename = value
+		}
+	}
+	return o
+}
+
+// parseMeminfo reads MemTotal and MemAvailable, which /proc/meminfo reports in kB.
+func parseMeminfo(meminfo []byte) Memory {
+	var m Memory
+	s := bufio.NewScanner(bytes.NewReader(meminfo))
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "MemTotal":
+			m.TotalBytes = kb * 1024
+		case "MemAvailable":
+			m.AvailableBytes = kb * 1024
+		}
+	}
+	return m
+}
+
+// netInterfaces lists the host's interfaces, sorted by name.
+func netInterfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var result []Interface
+	for _, iface := range ifaces {
+		i := Interface{
+			Name:     iface.Name,
+			MAC:      iface.HardwareAddr.String(),
+			Up:       iface.Flags&net.FlagUp != 0,
+			Loopback: iface.Flags&net.FlagLoopback != 0,
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				i.Addresses = append(i.Addresses, ipnet.IP.String())
+			}
+		}
+		result = append(result, i)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].Name < result[b].Name })
+	return result, nil
+}
+
+// IPAddresses returns the addresses of the interfaces that are up and not loopback.
+func (f *Facts) IPAddresses() []string {
+	var ips []string
+	for _, iface := range f.Interfaces {
+		if iface.Up && !iface.Loopback {
+			ips = append(ips, iface.Addresses...)
+		}
+	}
+	return ips
+}
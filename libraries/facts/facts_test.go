@@ -0,0 +1,202 @@
+package facts
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const ubuntuOSRelease = `PRETTY_NAME="Ubuntu 22.04.3 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+VERSION="22.04.3 LTS (Jammy Jellyfish)"
+VERSION_CODENAME=jammy
+ID=ubuntu
+ID_LIKE=debian
+# comment
+`
+
+func fakeGatherer(fsys afero.Fs) *Gatherer {
+	return &Gatherer{
+		Fsys:     fsys,
+		GOOS:     "linux",
+		GOARCH:   "arm64",
+		NumCPU:   func() int { return 1 },
+		Hostname: func() (string, error) { return "from-os", nil },
+		Interfaces: func() ([]Interface, error) {
+			return []Interface{
+				{Name: "eth0", MAC: "02:42:ac:11:00:02", Up: true, Addresses: []string{"172.17.0.2", "fe80::42:acff:fe11:2"}},
+				{Name: "eth1", Addresses: []string{"10.0.0.5"}},
+				{Name: "lo", Up: true, Loopback: true, Addresses: []string{"127.0.0.1", "::1"}},
+			}, nil
+		},
+		Environ: func() []string { return []string{"HOME=/root", "EMPTY="} },
+	}
+}
+
+func TestGather(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []aferohelpers.FileDefinition
+		want  *Facts
+	}{
+		{
+			name: "linux",
+			files: []aferohelpers.FileDefinition{
+				{Path: "/etc/os-release", Content: ubuntuOSRelease},
+				{Path: "/proc/sys/kernel/ostype", Content: "Linux\n"},
+				{Path: "/proc/sys/kernel/osrelease", Content: "6.1.0-13-amd64\n"},
+				{Path: "/proc/sys/kernel/version", Content: "#1 SMP PREEMPT_DYNAMIC\n"},
+				{Path: "/proc/sys/kernel/hostname", Content: "web-1\n"},
+				{Path: "/proc/cpuinfo", Content: "processor\t: 0\nmodel name\t: x\n\nprocessor\t: 1\nmodel name\t: x\n"},
+				{Path: "/proc/meminfo", Content: "MemTotal:        2048 kB\nMemFree:          512 kB\nMemAvailable:    1024 kB\n"},
+			},
+			want: &Facts{
+				OS: OS{
+					Family:     "linux",
+					Distro:     "ubuntu",
+					Like:       []string{"debian"},
+					Name:       "Ubuntu",
+					PrettyName: "Ubuntu 22.04.3 LTS",
+					Version:    "22.04.3 LTS (Jammy Jellyfish)",
+					VersionID:  "22.04",
+					Codename:   "jammy",
+				},
+				Kernel:   Kernel{Name: "Linux", Release: "6.1.0-13-amd64", Version: "#1 SMP PREEMPT_DYNAMIC"},
+				Arch:     "arm64",
+				Hostname: "web-1",
+				CPUCount: 2,
+				Memory:   Memory{TotalBytes: 2048 * 1024, AvailableBytes: 1024 * 1024},
+			},
+		},
+		{
+			name: "no os-release or proc",
+			want: &Facts{
+				OS:       OS{Family: "linux"},
+				Arch:     "arm64",
+				Hostname: "from-os",
+				CPUCount: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := fakeGatherer(aferohelpers.NewMemFsWithFiles(tt.files...))
+			got := g.Gather()
+			ifaces, _ := g.Interfaces()
+			tt.want.Interfaces = ifaces
+			tt.want.Env = map[string]string{"HOME": "/root", "EMPTY": ""}
+			tt.want.Custom, tt.want.CustomErrors = map[string]any{}, map[string]string{}
+			require.Equal(t, tt.want, got)
+			require.Equal(t, []string{"172.17.0.2", "fe80::42:acff:fe11:2"}, got.IPAddresses())
+		})
+	}
+}
+
+func TestGather_InterfaceErrorsAreNotFatal(t *testing.T) {
+	g := fakeGatherer(afero.NewMemMapFs())
+	g.Interfaces = func() ([]Interface, error) { return nil, errors.New("no netlink") }
+	require.Empty(t, g.Gather().Interfaces)
+}
+
+func TestStruct(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(aferohelpers.FileDefinition{Path: "/etc/os-release", Content: ubuntuOSRelease})
+	predeclared := starlark.StringDict{"facts": fakeGatherer(fsys).Gather().Struct()}
+	_, err := starlark.ExecFile(&starlark.Thread{}, "main.star", `
+assert_eq = lambda a, b: fail(a, "!=", b) if a != b else None
+assert_eq(facts.os.family, "linux")
+assert_eq(facts.os.distro, "ubuntu")
+assert_eq(facts.os.like, ["debian"])
+assert_eq(facts.arch, "arm64")
+assert_eq(facts.hostname, "from-os")
+assert_eq(facts.cpu_count, 1)
+assert_eq(facts.memory.total_bytes, 0)
+assert_eq([i.name for i in facts.interfaces if i.up], ["eth0", "lo"])
+assert_eq(facts.ip_addresses, ["172.17.0.2", "fe80::42:acff:fe11:2"])
+assert_eq(facts.env["HOME"], "/root")
+`, predeclared)
+	require.NoError(t, err)
+
+	_, err = starlark.ExecFile(&starlark.Thread{}, "main.star", `facts.ip_addresses.append("1.2.3.4")`, predeclared)
+	require.ErrorContains(t, err, "frozen")
+}
+
+func TestGather_Custom(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		aferohelpers.FileDefinition{Path: "/etc/starcm/facts.d/rack.json", Content: `{"row": 4, "slot": "b"}`},
+		aferohelpers.FileDefinition{Path: "/etc/starcm/facts.d/role.yaml", Content: "role: web\nweight: 1.5\n"},
+		aferohelpers.FileDefinition{Path: "/etc/starcm/facts.d/README", Content: "not a fact"},
+		aferohelpers.FileDefinition{Path: "/etc/starcm/facts.d/broken.json", Content: `{`},
+		aferohelpers.FileDefinition{Path: "/repo/facts.d/role.yaml", Content: "role: db\n"},
+	)
+	for p, content := range map[string]string{
+		"/etc/starcm/facts.d/nginx.sh":   `{"version": "1.24.0"}`,
+		"/etc/starcm/facts.d/fails":      "",
+		"/etc/starcm/facts.d/slow":       "",
+		"/etc/starcm/facts.d/garbage.py": "not json",
+	} {
+		require.NoError(t, afero.WriteFile(fsys, p, []byte(content), 0755))
+	}
+
+	g := fakeGatherer(fsys)
+	g.CustomDirs = []string{"/etc/starcm/facts.d", "/repo/facts.d", "/missing"}
+	g.CustomTimeout = 10 * time.Millisecond
+	g.RunExecutable = func(ctx context.Context, p string) ([]byte, error) {
+		switch filepath.Base(p) {
+		case "fails":
+			return nil, errors.New("exit status 1: no nginx")
+		case "slow":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return afero.ReadFile(fsys, p)
+	}
+
+	f := g.Gather()
+	require.Equal(t, map[string]any{
+		"rack":  map[string]any{"row": int64(4), "slot": "b"},
+		"role":  map[string]any{"role": "db"},
+		"nginx": map[string]any{"version": "1.24.0"},
+	}, f.Custom)
+	require.Len(t, f.CustomErrors, 4)
+	require.Contains(t, f.CustomErrors["broken"], "/etc/starcm/facts.d/broken.json")
+	require.Contains(t, f.CustomErrors["fails"], "no nginx")
+	require.Contains(t, f.CustomErrors["slow"], "timed out after 10ms")
+	require.Contains(t, f.CustomErrors["garbage"], "output is not JSON")
+
+	_, err := starlark.ExecFileOptions(&syntax.FileOptions{TopLevelControl: true}, &starlark.Thread{}, "main.star", `
+if facts.custom["rack"]["row"] != 4 or facts.custom["nginx"]["version"] != "1.24.0":
+    fail(facts.custom)
+if sorted(facts.custom_errors.keys()) != ["broken", "fails", "garbage", "slow"]:
+    fail(facts.custom_errors)
+`, starlark.StringDict{"facts": f.Struct()})
+	require.NoError(t, err)
+}
+
+func TestRunExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fact executables are shell scripts")
+	}
+	dir := t.TempDir()
+	ok := filepath.Join(dir, "ok")
+	require.NoError(t, os.WriteFile(ok, []byte("#!/bin/sh\necho '{\"a\": 1}'\n"), 0755))
+	failing := filepath.Join(dir, "failing")
+	require.NoError(t, os.WriteFile(failing, []byte("#!/bin/sh\necho oops >&2\nexit 3\n"), 0755))
+
+	out, err := runExecutable(context.Background(), ok)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"a": 1}`, string(out))
+
+	_, err = runExecutable(context.Background(), failing)
+	require.ErrorContains(t, err, "exit status 3: oops")
+}
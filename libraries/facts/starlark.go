@@ -0,0 +1,125 @@
+package facts
+
+import (
+	"sort"
+	"sync"
+
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Struct returns the facts as the Starlark value of facts in the starcm module, e.g.
+// facts.os.distro == "ubuntu" or facts.memory.total_bytes.
+func (f *Facts) Struct() *starlarkstruct.Struct {
+	var ifaces []starlark.Value
+	for _, iface := range f.Interfaces {
+		ifaces = append(ifaces, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"name":      starlark.String(iface.Name),
+			"mac":       starlark.String(iface.MAC),
+			"up":        starlark.Bool(iface.Up),
+			"loopback":  starlark.Bool(iface.Loopback),
+			"addresses": stringList(iface.Addresses),
+		}))
+	}
+
+	env := starlark.NewDict(len(f.Env))
+	for _, k := range sortedKeys(f.Env) {
+		_ = env.SetKey(starlark.String(k), starlark.String(f.Env[k]))
+	}
+	env.Freeze()
+
+	custom := starlark.NewDict(len(f.Custom))
+	customErrors := starlark.NewDict(len(f.CustomErrors))
+	for _, name := range sortedKeys(f.Custom) {
+		v, err := starlarkhelpers.FromGo(f.Custom[name])
+		if err != nil {
+			// YAML allows values that Starlark does not, such as mappings with non-string keys.
+			_ = customErrors.SetKey(starlark.String(name), starlark.String(err.Error()))
+			continue
+		}
+		_ = custom.SetKey(starlark.String(name), v)
+	}
+	for _, name := range sortedKeys(f.CustomErrors) {
+		_ = customErrors.SetKey(starlark.String(name), starlark.String(f.CustomErrors[name]))
+	}
+	custom.Freeze()
+	customErrors.Freeze()
+
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"os": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"family":      starlark.String(f.OS.Family),
+			"distro":      starlark.String(f.OS.Distro),
+			"like":        stringList(f.OS.Like),
+			"name":        starlark.String(f.OS.Name),
+			"pretty_name": starlark.String(f.OS.PrettyName),
+			"version":     starlark.String(f.OS.Version),
+			"version_id":  starlark.String(f.OS.VersionID),
+			"codename":    starlark.String(f.OS.Codename),
+		}),
+		"kernel": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"name":    starlark.String(f.Kernel.Name),
+			"release": starlark.String(f.Kernel.Release),
+			"version": starlark.String(f.Kernel.Version),
+		}),
+		"arch":      starlark.String(f.Arch),
+		"hostname":  starlark.String(f.Hostname),
+		"cpu_count": starlark.MakeInt(f.CPUCount),
+		"memory": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"total_bytes":     starlark.MakeInt64(f.Memory.TotalBytes),
+			"available_bytes": starlark.MakeInt64(f.Memory.AvailableBytes),
+		}),
+		"interfaces":    frozenList(ifaces),
+		"ip_addresses":  stringList(f.IPAddresses()),
+		"env":           env,
+		"custom":        custom,
+		"custom_errors": customErrors,
+	})
+}
+
+// Lazy is the facts value of the starcm module. It gathers the facts the first time it is used, and only
+// then, so that scripts which load starcm for other functions do not wait on custom fact executables.
+type Lazy struct {
+	get func() *starlarkstruct.Struct
+}
+
+var _ starlark.HasAttrs = (*Lazy)(nil)
+
+// NewLazy returns a Lazy that gathers with g at most once.
+func NewLazy(g *Gatherer) *Lazy {
+	return &Lazy{get: sync.OnceValue(func() *starlarkstruct.Struct { return g.Gather().Struct() })}
+}
+
+func (l *Lazy) String() string        { return l.get().String() }
+func (l *Lazy) Type() string          { return "struct" }
+func (l *Lazy) Freeze()               {} // the gathered struct is frozen already
+func (l *Lazy) Truth() starlark.Bool  { return starlark.True }
+func (l *Lazy) Hash() (uint32, error) { return l.get().Hash() }
+
+func (l *Lazy) Attr(name string) (starlark.Value, error) { return l.get().Attr(name) }
+func (l *Lazy) AttrNames() []string                      { return l.get().AttrNames() }
+
+// frozenList returns values as a frozen Starlark list, so that configs cannot change the facts for each other.
+func frozenList(values []starlark.Value) *starlark.List {
+	l := starlark.NewList(values)
+	l.Freeze()
+	return l
+}
+
+func stringList(ss []string) *starlark.List {
+	values := make([]starlark.Value, 0, len(ss))
+	for _, s := range ss {
+		values = append(values, starlark.String(s))
+	}
+	return frozenList(values)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,6 +1,10 @@
 package fileutils
 
 import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/afero"
 )
 
@@ -11,3 +15,19 @@ func IsDir(fsys afero.Fs, path string) (bool, error) {
 	}
 	return fsinfo.IsDir(), nil
 }
+
+// ExpandPath expands a leading ~ and resolves p to an absolute path relative to the current working directory.
+func ExpandPath(p string) (string, error) {
+	expanded, err := homedir.Expand(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand home directory in path %q: %w", p, err)
+	}
+	if filepath.IsAbs(expanded) {
+		return expanded, nil
+	}
+	abs, err := filepath.Abs(expanded)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path %q: %w", p, err)
+	}
+	return abs, nil
+}
@@ -0,0 +1,36 @@
+package fileutils
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// LookupIDs resolves a user and group name, or numeric id, to a uid and gid. Empty names resolve to -1,
+// which Chown treats as "leave unchanged".
+func LookupIDs(owner, group string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("failed to look up user %q: %w", owner, lookupErr)
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, fmt.Errorf("user %q has non-numeric uid %q", owner, u.Uid)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("failed to look up group %q: %w", group, lookupErr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, fmt.Errorf("group %q has non-numeric gid %q", group, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
+}
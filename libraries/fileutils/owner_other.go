@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fileutils
+
+import "os"
+
+// FileOwner returns the uid and gid of info. Ownership is not reported on this platform, so ok is always false.
+func FileOwner(info os.FileInfo) (uid int, gid int, ok bool) {
+	return -1, -1, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package fileutils
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileOwner returns the uid and gid of info. ok is false if the filesystem does not report ownership,
+// as is the case for in-memory filesystems.
+func FileOwner(info os.FileInfo) (uid int, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,87 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Config describes how the HTTP client used by starcm modules talks to remote servers.
+// The zero value behaves like http.DefaultClient.
+type Config struct {
+	// CAFile is a PEM bundle of additional certificate authorities trusted alongside the system pool.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key used for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// Proxy overrides the proxy from the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+	Proxy string
+	// Timeout limits the total time of a request, including reading the body. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// New builds an *http.Client from cfg, reading certificate files from fsys.
+func New(fsys afero.Fs, cfg Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.CAFile != "" {
+		pem, err := afero.ReadFile(fsys, cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %q: %w", cfg.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", cfg.CAFile)
+		}
+		transport.TLSClientConfig = tlsConfig(transport)
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("both a client certificate and key must be provided for mutual TLS")
+		}
+		certPEM, err := afero.ReadFile(fsys, cfg.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate %q: %w", cfg.CertFile, err)
+		}
+		keyPEM, err := afero.ReadFile(fsys, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client key %q: %w", cfg.KeyFile, err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		transport.TLSClientConfig = tlsConfig(transport)
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.Proxy != "" {
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url %q: %w", cfg.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+	}, nil
+}
+
+func tlsConfig(t *http.Transport) *tls.Config {
+	if t.TLSClientConfig != nil {
+		return t.TLSClientConfig
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
@@ -0,0 +1,64 @@
+package httpclient
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	client, err := New(fs, Config{Proxy: "http://proxy.internal:3128", Timeout: 5 * time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, client.Timeout)
+	req, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+	proxy, err := client.Transport.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	require.Equal(t, "proxy.internal:3128", proxy.Host)
+
+	_, err = New(fs, Config{CAFile: "/missing.pem"})
+	require.Error(t, err)
+
+	require.NoError(t, afero.WriteFile(fs, "/empty.pem", []byte("not a cert"), 0644))
+	_, err = New(fs, Config{CAFile: "/empty.pem"})
+	require.ErrorContains(t, err, "no certificates found")
+
+	_, err = New(fs, Config{CertFile: "/client.pem"})
+	require.ErrorContains(t, err, "both a client certificate and key")
+}
+
+func TestLookupNetrc(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/home/me/.netrc", []byte(`
+machine artifacts.example.com
+  login deploy
+  password s3cret
+machine other.example.com login other password other
+default login anonymous password guest
+`), 0600))
+
+	tests := []struct {
+		host string
+		want *Credentials
+	}{
+		{host: "artifacts.example.com", want: &Credentials{Login: "deploy", Password: "s3cret"}},
+		{host: "other.example.com", want: &Credentials{Login: "other", Password: "other"}},
+		{host: "unknown.example.com", want: &Credentials{Login: "anonymous", Password: "guest"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := LookupNetrc(fs, "/home/me/.netrc", tt.host)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := LookupNetrc(fs, "/nonexistent/.netrc", "artifacts.example.com")
+	require.NoError(t, err)
+	require.Nil(t, got)
+}
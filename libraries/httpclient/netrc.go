@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
+)
+
+// Credentials is a login and password from a netrc file.
+type Credentials struct {
+	Login    string
+	Password string
+}
+
+// NetrcPath returns the netrc file to use: $NETRC if set, otherwise ~/.netrc.
+func NetrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// LookupNetrc returns the credentials for host from the netrc file at path, falling back to a
+// "default" entry. It returns nil if the file does not exist or has no matching entry.
+func LookupNetrc(fsys afero.Fs, path, host string) (*Credentials, error) {
+	b, err := afero.ReadFile(fsys, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netrc file %q: %w", path, err)
+	}
+
+	var (
+		match    *Credentials
+		fallback *Credentials
+		current  *Credentials
+	)
+	tokens := strings.Fields(string(b))
+	for i := 0; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			current = nil
+			if next() == host && match == nil {
+				match = &Credentials{}
+				current = match
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &Credentials{}
+				current = fallback
+			}
+		case "login":
+			if v := next(); current != nil {
+				current.Login = v
+			}
+		case "password":
+			if v := next(); current != nil {
+				current.Password = v
+			}
+		case "account":
+			next()
+		case "macdef":
+			// Macro definitions run until the next blank line, which strings.Fields cannot see,
+			// so stop parsing rather than misinterpret the macro body.
+			i = len(tokens)
+		}
+	}
+	if match != nil {
+		return match, nil
+	}
+	return fallback, nil
+}
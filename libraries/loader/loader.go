@@ -4,25 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/discentem/starcm/libraries/cache"
+	"github.com/discentem/starcm/libraries/facts"
+	"github.com/discentem/starcm/libraries/lockfile"
 	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/stdlib"
+	"github.com/discentem/starcm/libraries/vars"
+	"github.com/discentem/starcm/libraries/workspace"
 	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
 	"github.com/google/deck"
 	"github.com/spf13/afero"
 	"go.starlark.net/starlark"
-	"go.starlark.net/starlarkstruct"
 	"go.starlark.net/syntax"
 
+	starcmblockinfile "github.com/discentem/starcm/functions/block_in_file"
+	starcmconfigfile "github.com/discentem/starcm/functions/config_file"
 	starcmdownload "github.com/discentem/starcm/functions/download"
 	dynamicloading "github.com/discentem/starcm/functions/dynamic_loading"
 	starcmFile "github.com/discentem/starcm/functions/file"
+	starcmlineinfile "github.com/discentem/starcm/functions/line_in_file"
 	starcmshell "github.com/discentem/starcm/functions/shell"
+	starcmsyncdir "github.com/discentem/starcm/functions/sync_dir"
 	starcmtemplate "github.com/discentem/starcm/functions/template"
+	starcmunarchive "github.com/discentem/starcm/functions/unarchive"
 	starcmwrite "github.com/discentem/starcm/functions/write"
 	starcmshelllib "github.com/discentem/starcm/libraries/shell"
 )
@@ -74,86 +86,240 @@ type Loader struct {
 
 	// Fsys is the filesystem to use for loading modules.
 	Fsys afero.Fs
+
+	// HTTPClient is used by builtins that talk to remote servers, such as download.
+	HTTPClient *http.Client
+
+	// DownloadCache, if set, lets download serve repeated artifacts from disk.
+	DownloadCache *cache.Cache
+
+	// LoadTrace, if set, receives the load graph as an indented tree while modules are loaded.
+	LoadTrace io.Writer
+
+	// Lockfile, if set, records the checksum of every remote module that is loaded.
+	Lockfile *lockfile.Lockfile
+
+	// Locked makes loading fail when a remote module is missing from Lockfile or resolves differently.
+	Locked bool
+
+	// SearchPaths are directories tried, in order, for relative modules that do not exist next to the caller.
+	SearchPaths []string
+
+	// Repositories are the libraries that "@name//path.star" modules refer to, keyed by name.
+	Repositories map[string]workspace.Repository
+
+	// Facts gathers the host facts exposed as load("starcm", "facts"). It defaults to the real host, read
+	// through Fsys.
+	Facts *facts.Gatherer
+
+	// Vars are the input variables of the run, exposed as load("vars", "vars").
+	Vars *vars.Vars
+
+	// ModuleDefaults are keyword arguments passed to the starcm builtins when a call omits them, keyed by builtin name.
+	ModuleDefaults map[string]map[string]any
 }
 
 // Sequential implements sequential module loading.
 // Module paths starting with "//" will be loaded from WorkspacePath, which should be the mount path to the workspace source directory.
-// Absolute paths and relative paths (from the caller's location) are also supported.
+// Absolute paths and relative paths (from the caller's location) are also supported, as are remote modules
+// pinned by hash ("https://host/lib.star@sha256:<hex>"), which are fetched with HTTPClient and kept in DownloadCache,
+// and modules in named Repositories ("@name//lib.star").
+// Modules are cached by their absolute path, so a file is executed once no matter how it is referenced.
 func (l *Loader) Sequential(ctx context.Context) func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
 	type entry struct {
 		globals starlark.StringDict
 		err     error
 	}
 
-	var cache = make(map[string]*entry)
+	var (
+		// cache holds file modules by canonical path; builtins holds predeclared modules by name.
+		cache    = make(map[string]*entry)
+		builtins = make(map[string]*entry)
+		// extracted holds the archive repositories that have been unpacked, by name.
+		extracted = make(map[string]afero.Fs)
+		// chain holds the canonical paths of the modules being loaded, starting with the root file.
+		chain []string
+	)
 
 	var load func(_ *starlark.Thread, module string) (starlark.StringDict, error)
 	load = func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
-		if e, ok := cache[module]; ok {
-			if e == nil {
-				return nil, fmt.Errorf("cycle in load graph %q", module)
+		if chain == nil {
+			// The root file is executed by LoadFromFile rather than by load, but loading it again is still a cycle.
+			root := rootFile(thread)
+			chain = []string{root}
+			if root != "" {
+				cache[root] = nil
+				l.trace(0, "%s", root)
 			}
-			return e.globals, e.err
 		}
-
-		cache[module] = nil // mark as loading
+		depth := len(chain)
 
 		// Try resolving as a built-in module first
-		if builtin, err := l.Predeclared(module); builtin != nil || err != nil {
-			e := &entry{builtin, err}
-			cache[module] = e
+		if e, ok := builtins[module]; ok {
+			l.trace(depth, "%s (builtin, cached)", module)
 			return e.globals, e.err
 		}
+		if builtin, err := l.Predeclared(module); builtin != nil || err != nil {
+			l.trace(depth, "%s (builtin)", module)
+			builtins[module] = &entry{builtin, err}
+			return builtin, err
+		}
 
-		if path.Ext(module) != ".star" {
-			return nil, fmt.Errorf("module %q is not valid, modules should have a .star extension", module)
+		var (
+			key, filename string
+			read          func() ([]byte, error)
+		)
+		if isRemote(module) {
+			remote, err := parseRemote(module)
+			if err != nil {
+				return nil, err
+			}
+			key, filename = remote.String(), remote.String()
+			read = func() ([]byte, error) { return l.fetchRemote(ctx, remote) }
+		} else {
+			if path.Ext(module) != ".star" {
+				return nil, fmt.Errorf("module %q is not valid, modules should have a .star extension", module)
+			}
+			if caller := callerFile(thread); isRemote(caller) {
+				// A relative path in a remote module would point at an unpinned file.
+				return nil, fmt.Errorf("remote module %q can only load builtins and pinned remote modules, not %q", caller, module)
+			}
+			modulePath, err := l.resolveModulePath(thread, module)
+			if err != nil {
+				return nil, err
+			}
+			if isRepositoryModule(modulePath) {
+				key, filename = modulePath, modulePath
+				read = func() ([]byte, error) { return l.readRepositoryModule(extracted, modulePath) }
+			} else {
+				key, filename = canonicalPath(modulePath), modulePath
+				read = func() ([]byte, error) {
+					data, err := afero.ReadFile(l.Fsys, modulePath)
+					if err != nil {
+						return nil, fmt.Errorf("loading module %q: %s", modulePath, err)
+					}
+					return data, nil
+				}
+			}
 		}
 
-		modulePath := l.resolveModulePath(thread, module)
+		if e, ok := cache[key]; ok {
+			if e == nil {
+				return nil, fmt.Errorf("cycle in load graph: %s", formatChain(chain, key))
+			}
+			l.trace(depth, "%s (cached)", describe(module, key))
+			return e.globals, e.err
+		}
 
-		globals, err := l.execModule("exec "+module, modulePath, load)
-		e := &entry{globals, err}
-		cache[module] = e
+		l.trace(depth, "%s", describe(module, key))
+		cache[key] = nil // mark as loading
+		chain = append(chain, key)
+		globals, err := func() (starlark.StringDict, error) {
+			data, err := read()
+			if err != nil {
+				return nil, err
+			}
+			return l.execModule("exec "+module, filename, data, load)
+		}()
+		chain = chain[:len(chain)-1]
+		cache[key] = &entry{globals, err}
 		return globals, err
 	}
 
 	return load
 }
 
-// resolveModulePath determines the actual filesystem path to the module based on workspace, call stack, or absolute logic.
-func (l *Loader) resolveModulePath(thread *starlark.Thread, module string) string {
-	switch {
-	case strings.HasPrefix(module, "//"):
-		logging.Log("Loader.resolveModulePath", deck.V(4), "info", "resolving workspace-relative module path %q with WorkspacePath %q", module, l.WorkspacePath)
-		// Workspace-relative
-		stripped := module[2:]
-		resolved := filepath.Join(l.WorkspacePath, stripped)
-		logging.Log("Loader.resolveModulePath", deck.V(4), "info", "stripped to %q, resolved to %q", stripped, resolved)
-		return resolved
-	case filepath.IsAbs(module):
-		logging.Log("Loader.resolveModulePath", deck.V(4), "info", "resolving absolute module path %q", module)
+// callerFile returns the file of the module that is calling load.
+func callerFile(thread *starlark.Thread) string {
+	if len(thread.CallStack()) == 0 {
+		return ""
+	}
+	return thread.CallStack().At(0).Pos.Filename()
+}
+
+// rootFile returns the canonical path of the file at the bottom of thread's call stack.
+func rootFile(thread *starlark.Thread) string {
+	stack := thread.CallStack()
+	if len(stack) == 0 {
+		return ""
+	}
+	return canonicalPath(stack[len(stack)-1].Pos.Filename())
+}
+
+// formatChain renders the modules being loaded followed by next, e.g. "a.star -> b.star -> a.star".
+func formatChain(chain []string, next string) string {
+	var parts []string
+	for _, p := range chain {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(append(parts, next), " -> ")
+}
+
+// canonicalPath returns the absolute, cleaned form of p, which identifies a module in the load cache.
+func canonicalPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return filepath.Clean(p)
+}
+
+// describe returns "module -> key" for the load trace, or just module when it is already canonical.
+func describe(module, key string) string {
+	if module == key {
 		return module
-	case len(thread.CallStack()) > 0:
-		logging.Log("Loader.resolveModulePath", deck.V(4), "info", "resolving caller-relative module path %q", module)
-		// Relative to the caller module
-		caller := thread.CallStack().At(0)
-		callerDir := filepath.Dir(caller.Pos.Filename())
-		return filepath.Join(callerDir, module)
+	}
+	return module + " -> " + key
+}
 
-	default:
-		logging.Log("Loader.resolveModulePath", deck.V(4), "info", "resolving default workspace-relative module path %q", module)
-		// Default fallback: relative to workspace
-		return filepath.Join(l.WorkspacePath, module)
+// trace writes a line of the load graph to LoadTrace, indented by depth.
+func (l *Loader) trace(depth int, format string, args ...any) {
+	if l.LoadTrace == nil {
+		return
 	}
+	fmt.Fprintf(l.LoadTrace, strings.Repeat("  ", depth)+format+"\n", args...)
 }
 
-// execModule reads, parses, and executes a Starlark module file.
-func (l *Loader) execModule(threadName, modulePath string, loadFunc func(*starlark.Thread, string) (starlark.StringDict, error)) (starlark.StringDict, error) {
-	data, err := afero.ReadFile(l.Fsys, modulePath)
-	if err != nil {
-		return nil, fmt.Errorf("loading module %q: %s", modulePath, err)
+// resolveModulePath determines the actual filesystem path to the module based on workspace, call stack, or absolute logic.
+// Relative modules that do not exist next to the caller are looked up in SearchPaths. "@name//" modules are
+// resolved in Repositories, and relative modules loaded from an archive repository stay inside it.
+func (l *Loader) resolveModulePath(thread *starlark.Thread, module string) (string, error) {
+	if isRepositoryModule(module) {
+		return l.resolveRepositoryModule(module)
+	}
+	caller := callerFile(thread)
+	if isRepositoryModule(caller) && !workspace.IsWorkspaceRelative(module) && !filepath.IsAbs(module) {
+		name, rel, err := parseRepositoryModule(caller)
+		if err != nil {
+			return "", err
+		}
+		return l.resolveRepositoryModule(repositoryModule(name, path.Join(path.Dir(rel), module)))
+	}
+	var callerDir string
+	if caller != "" {
+		// Relative to the caller module
+		callerDir = filepath.Dir(caller)
 	}
+	resolved := workspace.Resolve(l.WorkspacePath, callerDir, module)
+	if !workspace.IsWorkspaceRelative(module) && !filepath.IsAbs(module) {
+		if exists, _ := afero.Exists(l.Fsys, resolved); !exists {
+			for _, dir := range l.SearchPaths {
+				candidate := filepath.Join(dir, module)
+				if exists, _ := afero.Exists(l.Fsys, candidate); exists {
+					resolved = candidate
+					break
+				}
+			}
+		}
+	}
+	logging.Log("Loader.resolveModulePath", deck.V(4), "info", "resolved module path %q to %q (WorkspacePath %q, caller directory %q)", module, resolved, l.WorkspacePath, callerDir)
+	return resolved, nil
+}
 
+// execModule parses and executes the source of a Starlark module. modulePath is the name used in backtraces
+// and as the base for relative loads.
+func (l *Loader) execModule(threadName, modulePath string, data []byte, loadFunc func(*starlark.Thread, string) (starlark.StringDict, error)) (starlark.StringDict, error) {
 	thread := &starlark.Thread{
 		Name: threadName,
 		Load: loadFunc,
@@ -201,6 +367,66 @@ func WithFsys(fsys afero.Fs) LoaderOption {
 	}
 }
 
+func WithHTTPClient(client *http.Client) LoaderOption {
+	return func(l *Loader) {
+		l.HTTPClient = client
+	}
+}
+
+func WithDownloadCache(c *cache.Cache) LoaderOption {
+	return func(l *Loader) {
+		l.DownloadCache = c
+	}
+}
+
+func WithLoadTrace(w io.Writer) LoaderOption {
+	return func(l *Loader) {
+		l.LoadTrace = w
+	}
+}
+
+func WithLockfile(lf *lockfile.Lockfile) LoaderOption {
+	return func(l *Loader) {
+		l.Lockfile = lf
+	}
+}
+
+func WithLocked(locked bool) LoaderOption {
+	return func(l *Loader) {
+		l.Locked = locked
+	}
+}
+
+func WithSearchPaths(dirs ...string) LoaderOption {
+	return func(l *Loader) {
+		l.SearchPaths = dirs
+	}
+}
+
+func WithRepositories(repos map[string]workspace.Repository) LoaderOption {
+	return func(l *Loader) {
+		l.Repositories = repos
+	}
+}
+
+func WithFacts(g *facts.Gatherer) LoaderOption {
+	return func(l *Loader) {
+		l.Facts = g
+	}
+}
+
+func WithVars(v *vars.Vars) LoaderOption {
+	return func(l *Loader) {
+		l.Vars = v
+	}
+}
+
+func WithModuleDefaults(defaults map[string]map[string]any) LoaderOption {
+	return func(l *Loader) {
+		l.ModuleDefaults = defaults
+	}
+}
+
 func NewLoader(ctx context.Context, opts ...LoaderOption) Loader {
 	l := Loader{}
 	for _, opt := range opts {
@@ -209,57 +435,152 @@ func NewLoader(ctx context.Context, opts ...LoaderOption) Loader {
 	return l
 }
 
-func Default(ctx context.Context, fsys afero.Fs, ex starcmshelllib.Executor, workspacePath string) Loader {
+// Default returns a Loader with the builtin starcm and stdlib modules. opts are applied after the defaults,
+// so they can override settings such as the HTTP client.
+func Default(ctx context.Context, fsys afero.Fs, ex starcmshelllib.Executor, workspacePath string, opts ...LoaderOption) Loader {
 	l := NewLoader(
 		ctx,
-		WithWorkspacePath(workspacePath),
-		WithFsys(fsys),
-		WithPredeclared(func(module string) (starlark.StringDict, error) {
-			switch module {
-			case "starcm":
-				return starlark.StringDict{
-					"download": starlark.NewBuiltin(
-						"download",
-						starcmdownload.New(
-							ctx,
-							*http.DefaultClient,
-							fsys,
-							os.Stdout,
-						).Function(),
-					),
-					"write": starlark.NewBuiltin(
-						"write",
-						starcmwrite.New(ctx, os.Stdout).Function(),
-					),
-					"exec": starlark.NewBuiltin(
-						"exec",
-						starcmshell.New(
-							ctx,
-							ex,
-						).Function(),
-					),
-					"file": starlark.NewBuiltin(
-						"file",
-						starcmFile.New(ctx, fsys).Function(),
-					),
-					"template": starlark.NewBuiltin(
-						"template",
-						starcmtemplate.New(ctx, fsys).Function(),
-					),
-					"load_dynamic": starlark.NewBuiltin(
-						"load_dynamic",
-						dynamicloading.New(ctx).Function(),
-					),
-				}, nil
-			case "stdlib":
-				return starlark.StringDict{
-					"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
-				}, nil
-			default:
-				// set both to nil to allow the loader to load a .star file from a path.
-				return nil, nil
-			}
-		}),
+		append([]LoaderOption{
+			WithWorkspacePath(workspacePath),
+			WithFsys(fsys),
+			WithHTTPClient(http.DefaultClient),
+		}, opts...)...,
 	)
+	if l.Predeclared == nil {
+		l.Predeclared = l.builtins(ctx, ex)
+	}
 	return l
 }
+
+// withModuleDefaults wraps each builtin in module that has ModuleDefaults, so that the defaults are passed as
+// keyword arguments unless the call sets them.
+func (l *Loader) withModuleDefaults(module starlark.StringDict) (starlark.StringDict, error) {
+	for name, defaults := range l.ModuleDefaults {
+		v, ok := module[name]
+		if !ok {
+			return nil, fmt.Errorf("module_defaults: %q is not a starcm builtin", name)
+		}
+		builtin, ok := v.(*starlark.Builtin)
+		if !ok {
+			return nil, fmt.Errorf("module_defaults: %q is not a starcm builtin", name)
+		}
+		keys := make([]string, 0, len(defaults))
+		for k := range defaults {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var defaultKwargs []starlark.Tuple
+		for _, k := range keys {
+			value, err := starlarkhelpers.FromGo(defaults[k])
+			if err != nil {
+				return nil, fmt.Errorf("module_defaults.%s.%s: %w", name, k, err)
+			}
+			defaultKwargs = append(defaultKwargs, starlark.Tuple{starlark.String(k), value})
+		}
+		module[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			set := make(map[string]bool, len(kwargs))
+			for _, kv := range kwargs {
+				set[string(kv[0].(starlark.String))] = true
+			}
+			merged := append([]starlark.Tuple(nil), kwargs...)
+			for _, kv := range defaultKwargs {
+				if !set[string(kv[0].(starlark.String))] {
+					merged = append(merged, kv)
+				}
+			}
+			// CallInternal keeps the caller's frame directly below the builtin, which modules use to find
+			// their working directory.
+			return builtin.CallInternal(thread, args, merged)
+		})
+	}
+	return module, nil
+}
+
+// builtins returns the predeclared modules backed by the loader's filesystem and HTTP client.
+func (l *Loader) builtins(ctx context.Context, ex starcmshelllib.Executor) func(module string) (starlark.StringDict, error) {
+	fsys := l.Fsys
+	httpClient := l.HTTPClient
+	workspacePath := l.WorkspacePath
+	var downloadOpts []starcmdownload.Option
+	if l.DownloadCache != nil {
+		downloadOpts = append(downloadOpts, starcmdownload.WithCache(l.DownloadCache))
+	}
+	gatherer := l.Facts
+	if gatherer == nil {
+		gatherer = facts.NewGatherer(fsys)
+	}
+	// Facts are gathered when a script first reads them, and only once however many scripts do.
+	hostFacts := facts.NewLazy(gatherer)
+	return func(module string) (starlark.StringDict, error) {
+		switch module {
+		case "starcm":
+			return l.withModuleDefaults(starlark.StringDict{
+				"facts": hostFacts,
+				"download": starlark.NewBuiltin(
+					"download",
+					starcmdownload.New(
+						ctx,
+						*httpClient,
+						fsys,
+						os.Stdout,
+						downloadOpts...,
+					).Function(),
+				),
+				"write": starlark.NewBuiltin(
+					"write",
+					starcmwrite.New(ctx, os.Stdout).Function(),
+				),
+				"exec": starlark.NewBuiltin(
+					"exec",
+					starcmshell.New(
+						ctx,
+						ex,
+					).Function(),
+				),
+				"file": starlark.NewBuiltin(
+					"file",
+					starcmFile.New(ctx, fsys).Function(),
+				),
+				"line_in_file": starlark.NewBuiltin(
+					"line_in_file",
+					starcmlineinfile.New(ctx, fsys).Function(),
+				),
+				"block_in_file": starlark.NewBuiltin(
+					"block_in_file",
+					starcmblockinfile.New(ctx, fsys).Function(),
+				),
+				"config_file": starlark.NewBuiltin(
+					"config_file",
+					starcmconfigfile.New(ctx, fsys).Function(),
+				),
+				"template": starlark.NewBuiltin(
+					"template",
+					starcmtemplate.New(ctx, fsys, workspacePath).Function(),
+				),
+				"unarchive": starlark.NewBuiltin(
+					"unarchive",
+					starcmunarchive.New(ctx, fsys).Function(),
+				),
+				"sync_dir": starlark.NewBuiltin(
+					"sync_dir",
+					starcmsyncdir.New(ctx, fsys).Function(),
+				),
+				"load_dynamic": starlark.NewBuiltin(
+					"load_dynamic",
+					dynamicloading.New(ctx).Function(),
+				),
+			})
+		case "vars":
+			v := l.Vars
+			if v == nil {
+				v = vars.New()
+			}
+			return starlark.StringDict{"vars": v.Module()}, nil
+		case "stdlib":
+			return stdlib.Modules(fsys, workspacePath), nil
+		default:
+			// set both to nil to allow the loader to load a .star file from a path.
+			return nil, nil
+		}
+	}
+}
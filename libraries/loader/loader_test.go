@@ -0,0 +1,483 @@
+package loading
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/discentem/starcm/libraries/cache"
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/discentem/starcm/libraries/facts"
+	"github.com/discentem/starcm/libraries/lockfile"
+	"github.com/discentem/starcm/libraries/vars"
+	"github.com/discentem/starcm/libraries/workspace"
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+)
+
+type FileDefinition = aferohelpers.FileDefinition
+
+func testLoader(fsys afero.Fs, opts ...LoaderOption) Loader {
+	return NewLoader(
+		context.Background(),
+		append([]LoaderOption{
+			WithFsys(fsys),
+			WithWorkspacePath("/repo"),
+			WithPredeclared(func(module string) (starlark.StringDict, error) {
+				if module == "stdlib" {
+					return starlark.StringDict{"answer": starlark.MakeInt(42)}, nil
+				}
+				return nil, nil
+			}),
+		}, opts...)...,
+	)
+}
+
+func runRoot(t *testing.T, l Loader, fsys afero.Fs, root string) error {
+	t.Helper()
+	b, err := afero.ReadFile(fsys, root)
+	require.NoError(t, err)
+	return LoadFromFile(context.Background(), root, b, l.Sequential(context.Background()))
+}
+
+func TestSequential_CachesByResolvedPath(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		// counter.star appends to a list each time it is executed.
+		FileDefinition{Path: "/repo/lib/counter.star", Content: "runs = []\nruns.append(1)\n"},
+		FileDefinition{Path: "/repo/lib/a.star", Content: "load(\"counter.star\", \"runs\")\na = runs\n"},
+		FileDefinition{Path: "/repo/app/b.star", Content: "load(\"../lib/counter.star\", \"runs\")\nb = runs\n"},
+		FileDefinition{
+			Path: "/repo/main.star",
+			Content: `load("//lib/counter.star", "runs")
+load("lib/counter.star", runs2 = "runs")
+load("/repo/lib/a.star", "a")
+load("app/b.star", "b")
+if len(runs) != 1 or runs != runs2 or a != runs or b != runs:
+    fail("counter.star executed more than once")
+`,
+		},
+	)
+	require.NoError(t, runRoot(t, testLoader(fsys), fsys, "/repo/main.star"))
+}
+
+func TestSequential_RelativeLoadsDoNotCollide(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/x/lib.star", Content: "name = \"x\"\n"},
+		FileDefinition{Path: "/repo/y/lib.star", Content: "name = \"y\"\n"},
+		FileDefinition{Path: "/repo/x/use.star", Content: "load(\"lib.star\", \"name\")\nx = name\n"},
+		FileDefinition{Path: "/repo/y/use.star", Content: "load(\"lib.star\", \"name\")\ny = name\n"},
+		FileDefinition{
+			Path:    "/repo/main.star",
+			Content: "load(\"x/use.star\", \"x\")\nload(\"y/use.star\", \"y\")\nif (x, y) != (\"x\", \"y\"):\n    fail(x, y)\n",
+		},
+	)
+	require.NoError(t, runRoot(t, testLoader(fsys), fsys, "/repo/main.star"))
+}
+
+func TestSequential_CycleShowsChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []FileDefinition
+		wantErr string
+	}{
+		{
+			name: "between modules",
+			files: []FileDefinition{
+				{Path: "/repo/main.star", Content: "load(\"a.star\", \"a\")\n"},
+				{Path: "/repo/a.star", Content: "load(\"//b.star\", \"b\")\na = 1\n"},
+				{Path: "/repo/b.star", Content: "load(\"a.star\", \"a\")\nb = 1\n"},
+			},
+			wantErr: "cycle in load graph: /repo/main.star -> /repo/a.star -> /repo/b.star -> /repo/a.star",
+		},
+		{
+			name: "back to the root file",
+			files: []FileDefinition{
+				{Path: "/repo/main.star", Content: "load(\"a.star\", \"a\")\nx = 1\n"},
+				{Path: "/repo/a.star", Content: "load(\"main.star\", \"x\")\na = 1\n"},
+			},
+			wantErr: "cycle in load graph: /repo/main.star -> /repo/a.star -> /repo/main.star",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := aferohelpers.NewMemFsWithFiles(tt.files...)
+			err := runRoot(t, testLoader(fsys), fsys, "/repo/main.star")
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSequential_TraceLoads(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/lib/a.star", Content: "load(\"stdlib\", \"answer\")\na = answer\n"},
+		FileDefinition{Path: "/repo/main.star", Content: "load(\"lib/a.star\", \"a\")\nload(\"//lib/a.star\", a2 = \"a\")\nload(\"stdlib\", \"answer\")\n"},
+	)
+	var trace bytes.Buffer
+	require.NoError(t, runRoot(t, testLoader(fsys, WithLoadTrace(&trace)), fsys, "/repo/main.star"))
+	require.Equal(t, `/repo/main.star
+  lib/a.star -> /repo/lib/a.star
+    stdlib (builtin)
+  //lib/a.star -> /repo/lib/a.star (cached)
+  stdlib (builtin, cached)
+`, trace.String())
+}
+
+func TestSequential_RemoteModules(t *testing.T) {
+	const lib = "greeting = \"hello\"\n"
+	const libSum = "sha256:821cf820abc7e55628407f1a4f737414fa52d386498aaa62464fa18e765068a6"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/lib.star":
+			fmt.Fprint(w, lib)
+		case "/relative.star":
+			fmt.Fprint(w, "load(\"lib.star\", \"greeting\")\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	sum, err := checksum.FromReader(checksum.SHA256, strings.NewReader(lib))
+	require.NoError(t, err)
+	require.Equal(t, libSum, sum.String())
+	relativeSum, err := checksum.FromReader(checksum.SHA256, strings.NewReader("load(\"lib.star\", \"greeting\")\n"))
+	require.NoError(t, err)
+	libSHA1, err := checksum.FromReader(checksum.SHA1, strings.NewReader(lib))
+	require.NoError(t, err)
+	libSHA512, err := checksum.FromReader(checksum.SHA512, strings.NewReader(lib))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		load         string
+		wantErr      string
+		wantRequests int
+	}{
+		{
+			name:         "pinned",
+			load:         srv.URL + "/lib.star@" + libSum,
+			wantRequests: 1,
+		},
+		{
+			name:    "unpinned",
+			load:    srv.URL + "/lib.star",
+			wantErr: "must be pinned",
+		},
+		{
+			name:    "sha1 pin",
+			load:    srv.URL + "/lib.star@" + libSHA1.String(),
+			wantErr: "must be pinned with sha256 or sha512",
+		},
+		{
+			name:         "sha512 pin",
+			load:         srv.URL + "/lib.star@" + libSHA512.String(),
+			wantRequests: 1,
+		},
+		{
+			name:         "wrong pin",
+			load:         srv.URL + "/lib.star@sha256:" + strings.Repeat("0", 64),
+			wantErr:      "does not match its pin",
+			wantRequests: 1,
+		},
+		{
+			name:         "relative load from a remote module",
+			load:         srv.URL + "/relative.star@" + relativeSum.String(),
+			wantErr:      "can only load builtins and pinned remote modules",
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = 0
+			fsys := aferohelpers.NewMemFsWithFiles(FileDefinition{
+				Path:    "/repo/main.star",
+				Content: fmt.Sprintf("load(%q, \"greeting\")\nload(%q, g2 = \"greeting\")\n", tt.load, tt.load),
+			})
+			l := testLoader(fsys, WithHTTPClient(srv.Client()), WithDownloadCache(cache.New(fsys, "/cache")))
+			err := runRoot(t, l, fsys, "/repo/main.star")
+			require.Equal(t, tt.wantRequests, requests)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			// A second run is served from the download cache.
+			require.NoError(t, runRoot(t, l, fsys, "/repo/main.star"))
+			require.Equal(t, tt.wantRequests, requests)
+		})
+	}
+}
+
+func TestSequential_LockfileAndVendor(t *testing.T) {
+	const lib = "greeting = \"hello\"\n"
+	requests := 0
+	var outer string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/lib/outer.star" {
+			fmt.Fprint(w, outer)
+			return
+		}
+		fmt.Fprint(w, lib)
+	}))
+	defer srv.Close()
+	sum, err := checksum.FromReader(checksum.SHA256, strings.NewReader(lib))
+	require.NoError(t, err)
+	module := srv.URL + "/lib/greeting.star"
+	outer = fmt.Sprintf("load(%q, g = \"greeting\")\ngreeting = g\n", module+"@"+sum.String())
+	outerSum, err := checksum.FromReader(checksum.SHA256, strings.NewReader(outer))
+	require.NoError(t, err)
+	fsys := aferohelpers.NewMemFsWithFiles(FileDefinition{
+		Path:    "/repo/main.star",
+		Content: fmt.Sprintf("load(%q, \"greeting\")\n", module+"@"+sum.String()),
+	})
+
+	// A locked run fails while the module is not in the lockfile.
+	lf := lockfile.New()
+	err = runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf), WithLocked(true)), fsys, "/repo/main.star")
+	require.ErrorContains(t, err, "is not in starcm.lock")
+	require.Equal(t, 0, requests)
+
+	// An unlocked run records it.
+	require.NoError(t, runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf)), fsys, "/repo/main.star"))
+	require.True(t, lf.Changed())
+	locked, ok := lf.Lookup(module)
+	require.True(t, ok)
+	require.Equal(t, []string{sum.String()}, locked)
+	require.NoError(t, runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf), WithLocked(true)), fsys, "/repo/main.star"))
+
+	// A different pin no longer matches the lockfile.
+	lf.Modules[module] = lockfile.Module{Checksums: []string{"sha256:" + strings.Repeat("0", 64)}}
+	err = runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf), WithLocked(true)), fsys, "/repo/main.star")
+	require.ErrorContains(t, err, "but starcm.lock has")
+	lf.Modules[module] = lockfile.Module{Checksums: []string{sum.String()}}
+
+	// Vendoring follows loads from every .star file in the workspace and from remote modules, and prunes the
+	// lockfile entries and vendored copies that nothing uses any more.
+	host := strings.ReplaceAll(strings.TrimPrefix(srv.URL, "http://"), ":", "_")
+	stale := "/repo/.starcm/vendor/" + host + "/lib/sha256-" + strings.Repeat("0", 64) + "/greeting.star"
+	require.NoError(t, afero.WriteFile(fsys, stale, []byte("greeting = \"old\"\n"), 0644))
+	require.NoError(t, afero.WriteFile(fsys, "/repo/roles/web.star", []byte(fmt.Sprintf("load(%q, \"greeting\")\n", srv.URL+"/lib/outer.star@"+outerSum.String())), 0644))
+	lf.Record("https://old.example.com/unused.star", "sha256:"+strings.Repeat("1", 64))
+	// Files that are not vendored copies are never pruned, in the starcm vendor directory or outside it.
+	unrelated := []string{"/repo/vendor/foo.go", "/repo/.starcm/vendor/foo.go", "/repo/.starcm/vendor/" + host + "/lib/notes.star"}
+	for _, p := range unrelated {
+		require.NoError(t, afero.WriteFile(fsys, p, []byte("keep"), 0644))
+	}
+	l := testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf))
+	written, pruned, err := l.Vendor(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/repo/.starcm/vendor/" + host + "/lib/sha256-" + sum.Hex + "/greeting.star",
+		"/repo/.starcm/vendor/" + host + "/lib/sha256-" + outerSum.Hex + "/outer.star",
+	}, written)
+	require.Equal(t, []string{"https://old.example.com/unused.star@sha256:" + strings.Repeat("1", 64)}, pruned)
+	require.Equal(t, map[string]lockfile.Module{
+		module:                      {Checksums: []string{sum.String()}},
+		srv.URL + "/lib/outer.star": {Checksums: []string{outerSum.String()}},
+	}, lf.Modules)
+	exists, err := afero.Exists(fsys, filepath.Dir(stale))
+	require.NoError(t, err)
+	require.False(t, exists)
+	for _, p := range unrelated {
+		exists, err := afero.Exists(fsys, p)
+		require.NoError(t, err)
+		require.True(t, exists, p)
+	}
+
+	// Vendored modules are used without the network.
+	srv.Close()
+	require.NoError(t, runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf), WithLocked(true)), fsys, "/repo/main.star"))
+	require.NoError(t, runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf), WithLocked(true)), fsys, "/repo/roles/web.star"))
+
+	// An edited vendored copy is refused.
+	require.NoError(t, afero.WriteFile(fsys, written[0], []byte("greeting = \"tampered\"\n"), 0644))
+	err = runRoot(t, testLoader(fsys, WithHTTPClient(srv.Client()), WithLockfile(lf)), fsys, "/repo/main.star")
+	require.ErrorContains(t, err, "does not match its pin")
+}
+
+func TestSequential_LockfileKeepsEveryPinOfAURL(t *testing.T) {
+	// Two files load the same url at different pins, each from its own vendored copy.
+	module := "https://example.com/lib/greeting.star"
+	files := []FileDefinition{}
+	for i, content := range []string{"greeting = \"v1\"\n", "greeting = \"v2\"\n"} {
+		sum, err := checksum.FromReader(checksum.SHA256, strings.NewReader(content))
+		require.NoError(t, err)
+		files = append(files,
+			FileDefinition{Path: "/repo/.starcm/vendor/example.com/lib/sha256-" + sum.Hex + "/greeting.star", Content: content},
+			FileDefinition{Path: fmt.Sprintf("/repo/v%d.star", i+1), Content: fmt.Sprintf("load(%q, \"greeting\")\n", module+"@"+sum.String())},
+		)
+	}
+	fsys := aferohelpers.NewMemFsWithFiles(files...)
+
+	lf := lockfile.New()
+	for _, root := range []string{"/repo/v1.star", "/repo/v2.star"} {
+		require.NoError(t, runRoot(t, testLoader(fsys, WithLockfile(lf)), fsys, root))
+	}
+	locked, ok := lf.Lookup(module)
+	require.True(t, ok)
+	require.Len(t, locked, 2)
+
+	// Later runs neither change the lockfile nor fail in locked mode.
+	require.NoError(t, lf.Save(fsys, "/repo/starcm.lock"))
+	for _, root := range []string{"/repo/v1.star", "/repo/v2.star"} {
+		require.NoError(t, runRoot(t, testLoader(fsys, WithLockfile(lf)), fsys, root))
+		require.NoError(t, runRoot(t, testLoader(fsys, WithLockfile(lf), WithLocked(true)), fsys, root))
+	}
+	require.False(t, lf.Changed())
+}
+
+func TestSequential_SearchPaths(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/lib/net.star", Content: "where = \"lib\"\n"},
+		FileDefinition{Path: "/opt/shared/net.star", Content: "where = \"shared\"\n"},
+		FileDefinition{Path: "/opt/shared/users.star", Content: "users = [\"alice\"]\n"},
+		FileDefinition{Path: "/repo/roles/web/local.star", Content: "local = True\n"},
+		FileDefinition{
+			Path: "/repo/roles/web/main.star",
+			Content: `load("local.star", "local")
+load("net.star", "where")
+load("users.star", "users")
+if not local or where != "lib" or users != ["alice"]:
+    fail(local, where, users)
+`,
+		},
+	)
+	l := testLoader(fsys, WithSearchPaths("/repo/lib", "/opt/shared"))
+	require.NoError(t, runRoot(t, l, fsys, "/repo/roles/web/main.star"))
+}
+
+func TestSequential_Repositories(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, body := range map[string]string{
+		"tools-1.0/net/dns.star":  "load(\"../util.star\", \"join\")\nresolvers = join([\"1.1.1.1\", \"8.8.8.8\"])\n",
+		"tools-1.0/util.star":     "def join(xs):\n    return \",\".join(xs)\n",
+		"tools-1.0/net/more.star": "load(\"dns.star\", r = \"resolvers\")\nresolvers = r\n",
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/corp-lib/network.star", Content: "load(\"helpers/names.star\", \"name\")\niface = name\n"},
+		FileDefinition{Path: "/corp-lib/helpers/names.star", Content: "name = \"eth0\"\n"},
+		FileDefinition{Path: "/repo/third_party/tools.tar.gz", Content: buf.String()},
+		FileDefinition{
+			Path: "/repo/main.star",
+			Content: `load("@corp_lib//network.star", "iface")
+load("@tools//net/dns.star", "resolvers")
+load("@tools//net/more.star", resolvers2 = "resolvers")
+if iface != "eth0" or resolvers != "1.1.1.1,8.8.8.8" or resolvers2 != resolvers:
+    fail(iface, resolvers, resolvers2)
+`,
+		},
+	)
+	repos := map[string]workspace.Repository{
+		"corp_lib": {Path: "/corp-lib"},
+		"tools":    {Archive: "/repo/third_party/tools.tar.gz", StripComponents: 1},
+	}
+	require.NoError(t, runRoot(t, testLoader(fsys, WithRepositories(repos)), fsys, "/repo/main.star"))
+
+	tests := []struct {
+		name    string
+		load    string
+		wantErr string
+	}{
+		{name: "unknown repository", load: "@nope//x.star", wantErr: `unknown repository "@nope"`},
+		{name: "missing path", load: "@corp_lib", wantErr: "not valid"},
+		{name: "missing module", load: "@tools//missing.star", wantErr: `loading module "@tools//missing.star"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := aferohelpers.NewMemFsWithFiles(FileDefinition{Path: "/repo/main.star", Content: fmt.Sprintf("load(%q, \"x\")\n", tt.load)})
+			require.NoError(t, afero.WriteFile(fsys, "/repo/third_party/tools.tar.gz", buf.Bytes(), 0644))
+			err := runRoot(t, testLoader(fsys, WithRepositories(repos)), fsys, "/repo/main.star")
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDefault_ModuleDefaults(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/hello.tpl", Content: "hello {{ name }}"},
+		FileDefinition{
+			Path: "/repo/main.star",
+			Content: `load("starcm", "template")
+r = template(label = "default applies", template = "hello.tpl", data = {"name": "x"})
+if getattr(r, "return") != "hello x":
+    fail(r)
+`,
+		},
+	)
+	l := Default(context.Background(), fsys, nil, "/repo", WithModuleDefaults(map[string]map[string]any{
+		"template": {"what_if": true},
+	}))
+	require.NoError(t, runRoot(t, l, fsys, "/repo/main.star"))
+
+	l = Default(context.Background(), fsys, nil, "/repo", WithModuleDefaults(map[string]map[string]any{
+		"templates": {"what_if": true},
+	}))
+	require.ErrorContains(t, runRoot(t, l, fsys, "/repo/main.star"), `"templates" is not a starcm builtin`)
+}
+
+func TestDefault_Vars(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/main.star", Content: "load(\"vars\", \"vars\")\nif vars.get(\"region\", \"none\") != \"eu\":\n    fail(vars.names())\n"},
+	)
+	v := vars.New()
+	require.NoError(t, v.ParseAssignment("region=eu"))
+	require.NoError(t, runRoot(t, Default(context.Background(), fsys, nil, "/repo", WithVars(v)), fsys, "/repo/main.star"))
+	// Without variables the module still exists, so configs can fall back to defaults.
+	require.ErrorContains(t, runRoot(t, Default(context.Background(), fsys, nil, "/repo"), fsys, "/repo/main.star"), "[]")
+}
+
+func TestDefault_Facts(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/etc/os-release", Content: "ID=debian\n"},
+		FileDefinition{Path: "/repo/lib.star", Content: "load(\"starcm\", \"facts\")\ndistro = facts.os.distro\n"},
+		FileDefinition{
+			Path:    "/repo/main.star",
+			Content: "load(\"starcm\", \"facts\")\nload(\"lib.star\", \"distro\")\nif facts.os.distro != \"debian\" or distro != \"debian\":\n    fail(facts.os)\n",
+		},
+	)
+	gathered := 0
+	g := facts.NewGatherer(fsys)
+	g.Environ = func() []string {
+		gathered++
+		return nil
+	}
+	l := Default(context.Background(), fsys, nil, "/repo", WithFacts(g))
+	require.NoError(t, runRoot(t, l, fsys, "/repo/main.star"))
+	require.NoError(t, runRoot(t, l, fsys, "/repo/main.star"))
+	require.Equal(t, 1, gathered)
+}
+
+func TestDefault_FactsAreGatheredOnlyWhenUsed(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		FileDefinition{Path: "/repo/main.star", Content: "load(\"starcm\", \"template\", \"facts\")\nok = template != None\n"},
+	)
+	gathered := 0
+	g := facts.NewGatherer(fsys)
+	g.Environ = func() []string {
+		gathered++
+		return nil
+	}
+	require.NoError(t, runRoot(t, Default(context.Background(), fsys, nil, "/repo", WithFacts(g)), fsys, "/repo/main.star"))
+	require.Equal(t, 0, gathered)
+}
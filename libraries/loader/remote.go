@@ -0,0 +1,172 @@
+package loading
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/discentem/starcm/libraries/checksum"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+)
+
+// remoteModule is a module loaded over HTTP(S), written as "https://host/lib.star@sha256:<hex>".
+type remoteModule struct {
+	url string
+	sum checksum.Checksum
+}
+
+// String returns the canonical form of the module, which is also its key in the load cache.
+func (r remoteModule) String() string {
+	return r.url + "@" + r.sum.String()
+}
+
+func isRemote(module string) bool {
+	return strings.HasPrefix(module, "https://") || strings.HasPrefix(module, "http://")
+}
+
+// parseRemote splits a remote module into its url and pin. Unpinned remote modules are refused, since the
+// content could change between runs without anyone noticing, and so are pins weaker than sha256.
+func parseRemote(module string) (remoteModule, error) {
+	// Split on the last "@", since the url itself may contain one in its userinfo.
+	i := strings.LastIndex(module, "@")
+	if i < 0 || !strings.Contains(module[i+1:], ":") {
+		return remoteModule{}, fmt.Errorf("remote module %q must be pinned, e.g. %s@sha256:<hex>", module, module)
+	}
+	rawURL, pin := module[:i], module[i+1:]
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return remoteModule{}, fmt.Errorf("remote module %q: %w", module, err)
+	}
+	if path.Ext(u.Path) != ".star" {
+		return remoteModule{}, fmt.Errorf("module %q is not valid, modules should have a .star extension", module)
+	}
+	sum, err := checksum.Parse(pin)
+	if err != nil {
+		return remoteModule{}, fmt.Errorf("remote module %q: %w", module, err)
+	}
+	// sha1 is fine for spotting a corrupt download but not for pinning code against someone forging it.
+	if sum.Algo != checksum.SHA256 && sum.Algo != checksum.SHA512 {
+		return remoteModule{}, fmt.Errorf("remote module %q must be pinned with %s or %s, not %s", module, checksum.SHA256, checksum.SHA512, sum.Algo)
+	}
+	return remoteModule{url: rawURL, sum: sum}, nil
+}
+
+// VendorDir is the workspace directory that "starcm mod vendor" copies remote modules into. It lives under
+// .starcm so that it never collides with a vendor directory that belongs to something else, like a Go module.
+const VendorDir = ".starcm/vendor"
+
+// vendorPath returns where r is vendored in the workspace, e.g. .starcm/vendor/example.com/sha256-<hex>/lib.star.
+// The digest keeps copies of different pins of the same url apart, so a stale copy never shadows a new pin.
+// A port is kept as "host_port", since ":" is not allowed in file names everywhere.
+func (l *Loader) vendorPath(r remoteModule) (string, error) {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return "", err
+	}
+	dir, file := path.Split(path.Clean("/" + u.Path))
+	return filepath.Join(l.WorkspacePath, filepath.FromSlash(VendorDir), strings.ReplaceAll(u.Host, ":", "_"), filepath.FromSlash(dir), r.sum.Algo+"-"+r.sum.Hex, file), nil
+}
+
+// checkLocked verifies r against the lockfile when the loader runs in locked mode.
+func (l *Loader) checkLocked(r remoteModule) error {
+	if l.Lockfile == nil || !l.Locked {
+		return nil
+	}
+	return l.Lockfile.Check(r.url, r.sum.String())
+}
+
+// fetchRemote returns the source of r from the vendor directory, the download cache, or the network, and
+// records it in the lockfile.
+func (l *Loader) fetchRemote(ctx context.Context, r remoteModule) ([]byte, error) {
+	if err := l.checkLocked(r); err != nil {
+		return nil, err
+	}
+	data, err := l.readVendored(r)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if data, err = l.download(ctx, r); err != nil {
+			return nil, err
+		}
+	}
+	if l.Lockfile != nil && !l.Locked {
+		l.Lockfile.Record(r.url, r.sum.String())
+	}
+	return data, nil
+}
+
+// readVendored returns the vendored copy of r, or nil if it has not been vendored.
+func (l *Loader) readVendored(r remoteModule) ([]byte, error) {
+	p, err := l.vendorPath(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(l.Fsys, p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.sum.Verify(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("vendored copy %q of %q does not match its pin, run \"starcm mod vendor\" again: %w", p, r.url, err)
+	}
+	logging.Log("Loader.readVendored", deck.V(2), "info", "using vendored copy %q of %q", p, r.url)
+	return data, nil
+}
+
+// download returns r from the download cache, or fetches and verifies it with the loader's HTTP client.
+func (l *Loader) download(ctx context.Context, r remoteModule) ([]byte, error) {
+	if l.DownloadCache != nil {
+		data, found, err := l.DownloadCache.ReadFile(r.sum)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			logging.Log("Loader.fetchRemote", deck.V(2), "info", "serving %q from the cache", r.url)
+			return data, nil
+		}
+	}
+
+	client := l.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching remote module %q: %w", r.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching remote module %q: unexpected status %s", r.url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("fetching remote module %q: %w", r.url, err)
+	}
+	if err := r.sum.Verify(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("remote module %q does not match its pin: %w", r.url, err)
+	}
+
+	if l.DownloadCache != nil {
+		if err := l.DownloadCache.WriteFile(r.sum, data); err != nil {
+			logging.Log("Loader.fetchRemote", nil, "warn", "failed to cache %q: %v", r.url, err)
+		}
+	}
+	return data, nil
+}
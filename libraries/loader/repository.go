@@ -0,0 +1,100 @@
+package loading
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/discentem/starcm/libraries/archive"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/discentem/starcm/libraries/workspace"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+)
+
+// isRepositoryModule reports whether module is in a named repository, e.g. "@corp_lib//network.star".
+func isRepositoryModule(module string) bool {
+	return strings.HasPrefix(module, "@")
+}
+
+// parseRepositoryModule splits "@name//path.star" into the repository name and the slash-separated path
+// inside it. The path is cleaned so that it cannot climb out of the repository.
+func parseRepositoryModule(module string) (name, rel string, err error) {
+	name, rel, ok := strings.Cut(strings.TrimPrefix(module, "@"), "//")
+	if !ok || name == "" || rel == "" {
+		return "", "", fmt.Errorf("module %q is not valid, repository modules look like @name//path.star", module)
+	}
+	return name, path.Clean("/" + rel)[1:], nil
+}
+
+func repositoryModule(name, rel string) string {
+	return "@" + name + "//" + rel
+}
+
+// resolveRepositoryModule returns the path of a "@name//path.star" module. Modules in directory
+// repositories resolve to their path on disk; modules in archives keep the "@name//path.star" form and are
+// read with readRepositoryModule.
+func (l *Loader) resolveRepositoryModule(module string) (string, error) {
+	name, rel, err := parseRepositoryModule(module)
+	if err != nil {
+		return "", err
+	}
+	repo, ok := l.Repositories[name]
+	if !ok {
+		return "", fmt.Errorf("module %q: unknown repository %q, repositories are declared in %s", module, "@"+name, workspace.ConfigName)
+	}
+	if repo.Path != "" {
+		return filepath.Join(repo.Path, filepath.FromSlash(rel)), nil
+	}
+	return repositoryModule(name, rel), nil
+}
+
+// readRepositoryModule reads a "@name//path.star" module from an archive repository. Archives are extracted
+// into memory the first time one of their modules is loaded and kept in extracted for the rest of the run.
+func (l *Loader) readRepositoryModule(extracted map[string]afero.Fs, module string) ([]byte, error) {
+	name, rel, err := parseRepositoryModule(module)
+	if err != nil {
+		return nil, err
+	}
+	fsys, ok := extracted[name]
+	if !ok {
+		if fsys, err = l.extractRepository(name); err != nil {
+			return nil, err
+		}
+		extracted[name] = fsys
+	}
+	data, err := afero.ReadFile(fsys, "/"+rel)
+	if err != nil {
+		return nil, fmt.Errorf("loading module %q: %s", module, err)
+	}
+	return data, nil
+}
+
+// extractRepository unpacks the archive behind repository name into an in-memory filesystem rooted at "/".
+func (l *Loader) extractRepository(name string) (afero.Fs, error) {
+	repo := l.Repositories[name]
+	format, err := archive.DetectFormat(repo.Archive)
+	if err != nil {
+		return nil, fmt.Errorf("repository %q: %w", "@"+name, err)
+	}
+	data, err := afero.ReadFile(l.Fsys, repo.Archive)
+	if err != nil {
+		return nil, fmt.Errorf("repository %q: %w", "@"+name, err)
+	}
+	// archive.Extract reads and writes the same filesystem, so the archive is copied into memory first.
+	mem := afero.NewMemMapFs()
+	src := "/.archive/" + filepath.Base(repo.Archive)
+	if err := afero.WriteFile(mem, src, data, 0644); err != nil {
+		return nil, err
+	}
+	extracted, err := archive.Extract(mem, src, "/", format, archive.Options{StripComponents: repo.StripComponents})
+	if err != nil {
+		return nil, fmt.Errorf("repository %q: %w", "@"+name, err)
+	}
+	if err := mem.RemoveAll("/.archive"); err != nil {
+		return nil, err
+	}
+	logging.Log("Loader.extractRepository", deck.V(2), "info", "extracted %d files from %q for repository %q", len(extracted.Extracted), repo.Archive, "@"+name)
+	return mem, nil
+}
@@ -0,0 +1,232 @@
+package loading
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/discentem/starcm/libraries/lockfile"
+	"github.com/discentem/starcm/libraries/logging"
+	"github.com/google/deck"
+	"github.com/spf13/afero"
+	"go.starlark.net/syntax"
+)
+
+// fetchedModule is a remote module and its verified content.
+type fetchedModule struct {
+	remoteModule
+	data []byte
+}
+
+// Vendor copies the remote modules the workspace uses into VendorDir, so that later runs do not need the
+// network. A module is used when a .star file in the workspace, in a repository or on the search path loads
+// it, or when another used module does. The used modules are recorded in the lockfile, and lockfile entries
+// and vendored copies that nothing loads any more are removed. In locked mode the lockfile is only checked.
+// Vendor returns the vendored paths and the pins pruned from the lockfile, as "url@checksum".
+func (l *Loader) Vendor(ctx context.Context) (written, pruned []string, err error) {
+	if l.Lockfile == nil {
+		return nil, nil, fmt.Errorf("no lockfile to vendor into")
+	}
+	used, err := l.usedRemoteModules(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keepPins := map[lockfile.Pin]bool{}
+	keepPaths := map[string]bool{}
+	for _, m := range used {
+		if err := l.checkLocked(m.remoteModule); err != nil {
+			return nil, nil, err
+		}
+		if !l.Locked {
+			l.Lockfile.Record(m.url, m.sum.String())
+		}
+		keepPins[lockfile.Pin{URL: m.url, Checksum: m.sum.String()}] = true
+
+		p, err := l.vendorPath(m.remoteModule)
+		if err != nil {
+			return written, nil, err
+		}
+		if err := l.Fsys.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return written, nil, err
+		}
+		if err := afero.WriteFile(l.Fsys, p, m.data, 0644); err != nil {
+			return written, nil, err
+		}
+		keepPaths[p] = true
+		written = append(written, p)
+	}
+	if !l.Locked {
+		pruned = l.Lockfile.Prune(keepPins)
+	}
+	return written, pruned, l.pruneVendorDir(keepPaths)
+}
+
+// usedRemoteModules returns the remote modules that the .star files of the workspace, its repositories and
+// search paths load, directly or through other remote modules, sorted by url.
+func (l *Loader) usedRemoteModules(ctx context.Context) ([]fetchedModule, error) {
+	seen := map[string]bool{}
+	var queue []remoteModule
+	scan := func(filename string, src []byte) error {
+		modules, err := remoteLoads(filename, src)
+		if err != nil {
+			return err
+		}
+		for _, r := range modules {
+			if !seen[r.String()] {
+				seen[r.String()] = true
+				queue = append(queue, r)
+			}
+		}
+		return nil
+	}
+
+	type root struct {
+		fsys afero.Fs
+		dir  string
+	}
+	roots := []root{{l.Fsys, l.WorkspacePath}}
+	for _, dir := range l.SearchPaths {
+		roots = append(roots, root{l.Fsys, dir})
+	}
+	names := make([]string, 0, len(l.Repositories))
+	for name := range l.Repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if repo := l.Repositories[name]; repo.Path != "" {
+			roots = append(roots, root{l.Fsys, repo.Path})
+			continue
+		}
+		fsys, err := l.extractRepository(name)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root{fsys, "/"})
+	}
+	vendorDir := filepath.Join(l.WorkspacePath, filepath.FromSlash(VendorDir))
+	for _, r := range roots {
+		if err := walkStarFiles(r.fsys, r.dir, vendorDir, scan); err != nil {
+			return nil, err
+		}
+	}
+
+	var used []fetchedModule
+	for i := 0; i < len(queue); i++ {
+		data, err := l.download(ctx, queue[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := scan(queue[i].url, data); err != nil {
+			return nil, err
+		}
+		used = append(used, fetchedModule{queue[i], data})
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].String() < used[j].String() })
+	return used, nil
+}
+
+// walkStarFiles calls fn with every .star file under dir, skipping hidden directories and skip. A dir that
+// does not exist has no files.
+func walkStarFiles(fsys afero.Fs, dir, skip string, fn func(filename string, src []byte) error) error {
+	if _, err := fsys.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return afero.Walk(fsys, dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && (p == skip || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".star" {
+			return nil
+		}
+		src, err := afero.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		return fn(p, src)
+	})
+}
+
+// remoteLoads returns the remote modules that the load statements of a .star file refer to.
+func remoteLoads(filename string, src []byte) ([]remoteModule, error) {
+	f, err := (&syntax.FileOptions{TopLevelControl: true}).Parse(filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	var modules []remoteModule
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok || !isRemote(load.ModuleName()) {
+			continue
+		}
+		r, err := parseRemote(load.ModuleName())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		modules = append(modules, r)
+	}
+	return modules, nil
+}
+
+// vendoredCopy matches the paths, relative to VendorDir, that vendorPath writes:
+// <host>/<dir>/<algo>-<hex>/<file>.star.
+var vendoredCopy = regexp.MustCompile(`^[^/]+/(?:.+/)?(?:sha256-[0-9a-f]{64}|sha512-[0-9a-f]{128})/[^/]+\.star$`)
+
+// pruneVendorDir removes the vendored copies in VendorDir that are not in keep, and the directories that
+// leaves empty. Files that vendorPath would not have written are left alone.
+func (l *Loader) pruneVendorDir(keep map[string]bool) error {
+	root := filepath.Join(l.WorkspacePath, filepath.FromSlash(VendorDir))
+	if _, err := l.Fsys.Stat(root); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var removed []string
+	err := afero.Walk(l.Fsys, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || keep[p] {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if !vendoredCopy.MatchString(filepath.ToSlash(rel)) {
+			logging.Log("Loader.Vendor", deck.V(2), "info", "leaving %q alone, it is not a vendored copy", p)
+			return nil
+		}
+		logging.Log("Loader.Vendor", deck.V(1), "info", "removing unused vendored copy %q", p)
+		if err := l.Fsys.Remove(p); err != nil {
+			return err
+		}
+		removed = append(removed, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Remove the directories the removed copies leave empty, up to but not including root.
+	for _, p := range removed {
+		for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+			if empty, err := afero.IsEmpty(l.Fsys, dir); err != nil || !empty {
+				break
+			}
+			if err := l.Fsys.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Name is the file name of the lockfile at the root of the workspace.
+const Name = "starcm.lock"
+
+// version is written to the lockfile so that the format can change later.
+const version = 1
+
+// Module is the resolved state of one external module.
+type Module struct {
+	// Checksums are the "algo:hex" digests the url is pinned to, sorted. A url that is loaded at several pins
+	// has one entry per pin.
+	Checksums []string `json:"checksums"`
+}
+
+// Pin is one url at one checksum, the unit that Check, Record and Prune work on.
+type Pin struct {
+	URL      string
+	Checksum string
+}
+
+func (p Pin) String() string {
+	return p.URL + "@" + p.Checksum
+}
+
+// Lockfile records the checksums of every external module a run resolved, keyed by url.
+type Lockfile struct {
+	Version int               `json:"version"`
+	Modules map[string]Module `json:"modules"`
+
+	changed bool
+}
+
+// New returns an empty lockfile.
+func New() *Lockfile {
+	return &Lockfile{Version: version, Modules: map[string]Module{}}
+}
+
+// Load reads the lockfile at path. A missing file yields an empty lockfile.
+func Load(fsys afero.Fs, path string) (*Lockfile, error) {
+	data, err := afero.ReadFile(fsys, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lf := New()
+	if err := json.Unmarshal(data, lf); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if lf.Version != version {
+		return nil, fmt.Errorf("%s has unsupported version %d", path, lf.Version)
+	}
+	if lf.Modules == nil {
+		lf.Modules = map[string]Module{}
+	}
+	return lf, nil
+}
+
+// Lookup returns the checksums url is locked to.
+func (lf *Lockfile) Lookup(url string) ([]string, bool) {
+	m, ok := lf.Modules[url]
+	return m.Checksums, ok
+}
+
+// Check reports an error if url is not locked to sum.
+func (lf *Lockfile) Check(url, sum string) error {
+	locked, ok := lf.Lookup(url)
+	if !ok {
+		return fmt.Errorf("%q is not in %s", url, Name)
+	}
+	if !slices.Contains(locked, sum) {
+		return fmt.Errorf("%q resolved to %s, but %s has %s", url, sum, Name, strings.Join(locked, ", "))
+	}
+	return nil
+}
+
+// Record locks url to sum, next to any other checksums it is already locked to.
+func (lf *Lockfile) Record(url, sum string) {
+	locked := lf.Modules[url].Checksums
+	if slices.Contains(locked, sum) {
+		return
+	}
+	locked = append(slices.Clone(locked), sum)
+	slices.Sort(locked)
+	lf.Modules[url] = Module{Checksums: locked}
+	lf.changed = true
+}
+
+// Prune removes the pins that are not in keep, and the modules left without any, and returns the removed
+// pins as "url@checksum", sorted.
+func (lf *Lockfile) Prune(keep map[Pin]bool) []string {
+	var pruned []string
+	for url, m := range lf.Modules {
+		var kept []string
+		for _, sum := range m.Checksums {
+			if keep[Pin{URL: url, Checksum: sum}] {
+				kept = append(kept, sum)
+			} else {
+				pruned = append(pruned, Pin{URL: url, Checksum: sum}.String())
+			}
+		}
+		switch {
+		case len(kept) == 0:
+			delete(lf.Modules, url)
+		case len(kept) < len(m.Checksums):
+			lf.Modules[url] = Module{Checksums: kept}
+		}
+	}
+	if len(pruned) > 0 {
+		lf.changed = true
+	}
+	sort.Strings(pruned)
+	return pruned
+}
+
+// Changed reports whether Record or Prune modified the lockfile since it was loaded.
+func (lf *Lockfile) Changed() bool {
+	return lf.changed
+}
+
+// Save writes the lockfile to path. Modules are sorted by url, so the file diffs cleanly.
+func (lf *Lockfile) Save(fsys afero.Fs, path string) error {
+	data, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := afero.WriteFile(fsys, path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	lf.changed = false
+	return nil
+}
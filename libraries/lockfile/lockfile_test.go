@@ -0,0 +1,104 @@
+package lockfile
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSave(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	lf, err := Load(fs, "/repo/starcm.lock")
+	require.NoError(t, err)
+	require.Empty(t, lf.Modules)
+	require.False(t, lf.Changed())
+
+	lf.Record("https://b.example.com/lib.star", "sha256:bb")
+	lf.Record("https://a.example.com/lib.star", "sha256:aa")
+	require.True(t, lf.Changed())
+	require.NoError(t, lf.Save(fs, "/repo/starcm.lock"))
+
+	b, err := afero.ReadFile(fs, "/repo/starcm.lock")
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "version": 1,
+  "modules": {
+    "https://a.example.com/lib.star": {
+      "checksums": [
+        "sha256:aa"
+      ]
+    },
+    "https://b.example.com/lib.star": {
+      "checksums": [
+        "sha256:bb"
+      ]
+    }
+  }
+}
+`, string(b))
+
+	lf, err = Load(fs, "/repo/starcm.lock")
+	require.NoError(t, err)
+	lf.Record("https://a.example.com/lib.star", "sha256:aa")
+	require.False(t, lf.Changed())
+}
+
+func TestCheck(t *testing.T) {
+	lf := New()
+	lf.Record("https://example.com/lib.star", "sha256:aa")
+
+	require.NoError(t, lf.Check("https://example.com/lib.star", "sha256:aa"))
+	require.ErrorContains(t, lf.Check("https://example.com/lib.star", "sha256:bb"), "but starcm.lock has sha256:aa")
+	require.ErrorContains(t, lf.Check("https://example.com/other.star", "sha256:aa"), "is not in starcm.lock")
+}
+
+func TestRecord_SeveralPinsOfOneURL(t *testing.T) {
+	lf := New()
+	lf.Record("https://example.com/lib.star", "sha256:bb")
+	lf.Record("https://example.com/lib.star", "sha256:aa")
+	require.Equal(t, map[string]Module{"https://example.com/lib.star": {Checksums: []string{"sha256:aa", "sha256:bb"}}}, lf.Modules)
+	require.NoError(t, lf.Check("https://example.com/lib.star", "sha256:aa"))
+	require.NoError(t, lf.Check("https://example.com/lib.star", "sha256:bb"))
+	require.ErrorContains(t, lf.Check("https://example.com/lib.star", "sha256:cc"), "but starcm.lock has sha256:aa, sha256:bb")
+
+	// Recording both pins again, as every later run does, leaves the lockfile alone.
+	lf.changed = false
+	lf.Record("https://example.com/lib.star", "sha256:aa")
+	lf.Record("https://example.com/lib.star", "sha256:bb")
+	require.False(t, lf.Changed())
+}
+
+func TestPrune(t *testing.T) {
+	lf := New()
+	lf.Record("https://example.com/a.star", "sha256:aa")
+	lf.Record("https://example.com/a.star", "sha256:a2")
+	lf.Record("https://example.com/b.star", "sha256:bb")
+	lf.Record("https://example.com/c.star", "sha256:cc")
+	lf.changed = false
+
+	all := map[Pin]bool{
+		{URL: "https://example.com/a.star", Checksum: "sha256:aa"}: true,
+		{URL: "https://example.com/a.star", Checksum: "sha256:a2"}: true,
+		{URL: "https://example.com/b.star", Checksum: "sha256:bb"}: true,
+		{URL: "https://example.com/c.star", Checksum: "sha256:cc"}: true,
+	}
+	require.Empty(t, lf.Prune(all))
+	require.False(t, lf.Changed())
+
+	require.Equal(t, []string{
+		"https://example.com/a.star@sha256:a2",
+		"https://example.com/b.star@sha256:bb",
+		"https://example.com/c.star@sha256:cc",
+	}, lf.Prune(map[Pin]bool{{URL: "https://example.com/a.star", Checksum: "sha256:aa"}: true}))
+	require.True(t, lf.Changed())
+	require.Equal(t, map[string]Module{"https://example.com/a.star": {Checksums: []string{"sha256:aa"}}}, lf.Modules)
+}
+
+func TestLoad_RejectsUnknownVersion(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/starcm.lock", []byte(`{"version": 9, "modules": {}}`), 0644))
+	_, err := Load(fs, "/starcm.lock")
+	require.ErrorContains(t, err, "unsupported version 9")
+}
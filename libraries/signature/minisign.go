@@ -0,0 +1,112 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	minisignAlgoLegacy    = "Ed"
+	minisignAlgoPrehashed = "ED"
+	minisignKeyIDLen      = 8
+)
+
+// ErrInvalidSignature is returned when a signature does not verify against the given key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+type minisignKey struct {
+	keyID [minisignKeyIDLen]byte
+	key   ed25519.PublicKey
+}
+
+// lastDataLine returns the last line that is not a comment, so that both a bare base64 key and the
+// contents of a minisign .pub file are accepted.
+func lastDataLine(s string) string {
+	var line string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, "untrusted comment:") {
+			line = l
+		}
+	}
+	return line
+}
+
+func parseMinisignKey(s string) (*minisignKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(lastDataLine(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid minisign public key: %w", err)
+	}
+	if len(raw) != 2+minisignKeyIDLen+ed25519.PublicKeySize || string(raw[:2]) != minisignAlgoLegacy {
+		return nil, fmt.Errorf("invalid minisign public key: unexpected length or algorithm")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(raw[2+minisignKeyIDLen:])}
+	copy(k.keyID[:], raw[2:2+minisignKeyIDLen])
+	return k, nil
+}
+
+// VerifyMinisign checks a minisign signature of message. publicKey is the base64 key or the contents of a
+// minisign .pub file; sig is the contents of the .minisig file. Both legacy and prehashed (BLAKE2b-512)
+// signatures are supported, and the trusted comment is verified as well.
+func VerifyMinisign(publicKey string, sig []byte, message io.Reader) error {
+	key, err := parseMinisignKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(sig)), "\n")
+	if len(lines) < 4 {
+		return fmt.Errorf("invalid minisign signature: expected 4 lines, got %d", len(lines))
+	}
+	sigRaw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return fmt.Errorf("invalid minisign signature: %w", err)
+	}
+	if len(sigRaw) != 2+minisignKeyIDLen+ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign signature: unexpected length %d", len(sigRaw))
+	}
+	algo := string(sigRaw[:2])
+	if !bytes.Equal(sigRaw[2:2+minisignKeyIDLen], key.keyID[:]) {
+		return fmt.Errorf("%w: signed by a different key", ErrInvalidSignature)
+	}
+	signature := sigRaw[2+minisignKeyIDLen:]
+
+	trustedComment, found := strings.CutPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !found {
+		return fmt.Errorf("invalid minisign signature: missing trusted comment")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return fmt.Errorf("invalid minisign signature: %w", err)
+	}
+
+	var signed []byte
+	switch algo {
+	case minisignAlgoLegacy:
+		signed, err = io.ReadAll(message)
+	case minisignAlgoPrehashed:
+		h, _ := blake2b.New512(nil)
+		_, err = io.Copy(h, message)
+		signed = h.Sum(nil)
+	default:
+		return fmt.Errorf("invalid minisign signature: unsupported algorithm %q", algo)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(key.key, signed, signature) {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(key.key, append(append([]byte{}, signature...), trustedComment...), globalSig) {
+		return fmt.Errorf("%w: trusted comment does not verify", ErrInvalidSignature)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+var armorPrefix = []byte("-----BEGIN ")
+
+// VerifyOpenPGP checks a detached OpenPGP signature of message against keyring. Both the keyring and the
+// signature may be ASCII-armored or binary.
+func VerifyOpenPGP(keyring, sig []byte, message io.Reader) error {
+	var (
+		keys openpgp.EntityList
+		err  error
+	)
+	if bytes.HasPrefix(bytes.TrimSpace(keyring), armorPrefix) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read OpenPGP keyring: %w", err)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(sig), armorPrefix) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keys, message, bytes.NewReader(sig), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keys, message, bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignFixture signs message the way `minisign -S` does and returns the public key and .minisig contents.
+func minisignFixture(t *testing.T, message []byte, prehash bool) (string, []byte) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	algo, signed := "Ed", message
+	if prehash {
+		sum := blake2b.Sum512(message)
+		algo, signed = "ED", sum[:]
+	}
+	sig := ed25519.Sign(priv, signed)
+	trusted := "timestamp:1700000000\tfile:hello.txt"
+	global := ed25519.Sign(priv, append(append([]byte{}, sig...), trusted...))
+
+	pubKey := "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), pub...)) + "\n"
+	sigFile := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algo), keyID...), sig...)) + "\n" +
+		"trusted comment: " + trusted + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+	return pubKey, []byte(sigFile)
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	message := []byte("hello world")
+	for _, prehash := range []bool{false, true} {
+		pub, sig := minisignFixture(t, message, prehash)
+		require.NoError(t, VerifyMinisign(pub, sig, bytes.NewReader(message)))
+		require.ErrorIs(t, VerifyMinisign(pub, sig, strings.NewReader("tampered")), ErrInvalidSignature)
+
+		otherPub, _ := minisignFixture(t, message, prehash)
+		require.Error(t, VerifyMinisign(otherPub, sig, bytes.NewReader(message)))
+
+		tampered := bytes.Replace(sig, []byte("hello.txt"), []byte("evil.txt"), 1)
+		require.ErrorIs(t, VerifyMinisign(pub, tampered, bytes.NewReader(message)), ErrInvalidSignature)
+	}
+}
+
+func TestVerifyOpenPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("Release Signing", "", "release@example.com", nil)
+	require.NoError(t, err)
+	var keyring bytes.Buffer
+	require.NoError(t, entity.Serialize(&keyring))
+
+	message := []byte("hello world")
+	var armored, binary bytes.Buffer
+	require.NoError(t, openpgp.ArmoredDetachSign(&armored, entity, bytes.NewReader(message), nil))
+	require.NoError(t, openpgp.DetachSign(&binary, entity, bytes.NewReader(message), nil))
+
+	require.NoError(t, VerifyOpenPGP(keyring.Bytes(), armored.Bytes(), bytes.NewReader(message)))
+	require.NoError(t, VerifyOpenPGP(keyring.Bytes(), binary.Bytes(), bytes.NewReader(message)))
+	require.ErrorIs(t, VerifyOpenPGP(keyring.Bytes(), armored.Bytes(), strings.NewReader("tampered")), ErrInvalidSignature)
+}
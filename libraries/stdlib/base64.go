@@ -0,0 +1,53 @@
+package stdlib
+
+import (
+	"encoding/base64"
+
+	"go.starlark.net/starlark"
+)
+
+// base64Module encodes and decodes standard base64, or the URL-safe alphabet with url = True:
+//
+//	base64.encode(s, url = False)
+//	base64.decode(s, url = False)
+var base64Module = module("base64", map[string]builtinFunc{
+	"encode": base64Encode,
+	"decode": base64Decode,
+})
+
+func encoding(url bool) *base64.Encoding {
+	if url {
+		return base64.URLEncoding
+	}
+	return base64.StdEncoding
+}
+
+func base64Encode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		s   starlark.Value
+		url bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "s", &s, "url?", &url); err != nil {
+		return nil, err
+	}
+	data, err := stringOrBytes(b, s)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(encoding(url).EncodeToString(data)), nil
+}
+
+func base64Decode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		s   string
+		url bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "s", &s, "url?", &url); err != nil {
+		return nil, err
+	}
+	data, err := encoding(url).DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(data), nil
+}
@@ -0,0 +1,138 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// fsModule answers questions about the filesystem without changing it. Relative paths are relative to the
+// calling file and "//" paths to the workspace:
+//
+//	fs.exists(path)    # False for a broken symlink
+//	fs.is_dir(path)
+//	fs.read(path)      # the content as a string
+//	fs.glob(pattern)   # the absolute paths that match, sorted
+//	fs.stat(path)      # a struct with name, size, mode, mode_string, is_dir, is_symlink and mtime
+//	fs.readlink(path)
+func (f *files) fsModule() *starlarkstruct.Module {
+	return module("fs", map[string]builtinFunc{
+		"exists":   f.exists,
+		"is_dir":   f.isDir,
+		"read":     f.read,
+		"glob":     f.glob,
+		"stat":     f.stat,
+		"readlink": f.readlink,
+	})
+}
+
+// unpackPath unpacks the single path argument of a builtin and resolves it.
+func (f *files) unpackPath(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (string, error) {
+	var p string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p); err != nil {
+		return "", err
+	}
+	return f.resolve(thread, p), nil
+}
+
+func (f *files) exists(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	p, err := f.unpackPath(thread, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := afero.Exists(f.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(exists), nil
+}
+
+func (f *files) isDir(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	p, err := f.unpackPath(thread, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	isDir, err := afero.IsDir(f.fsys, p)
+	if errors.Is(err, os.ErrNotExist) {
+		return starlark.False, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(isDir), nil
+}
+
+func (f *files) read(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	p, err := f.unpackPath(thread, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	data, err := afero.ReadFile(f.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(data), nil
+}
+
+func (f *files) glob(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern); err != nil {
+		return nil, err
+	}
+	matches, err := afero.Glob(f.fsys, f.resolve(thread, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	sort.Strings(matches)
+	values := make([]starlark.Value, 0, len(matches))
+	for _, m := range matches {
+		values = append(values, starlark.String(m))
+	}
+	return starlark.NewList(values), nil
+}
+
+func (f *files) stat(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	p, err := f.unpackPath(thread, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	var info os.FileInfo
+	if lstater, ok := f.fsys.(afero.Lstater); ok {
+		info, _, err = lstater.LstatIfPossible(p)
+	} else {
+		info, err = f.fsys.Stat(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"name":        starlark.String(info.Name()),
+		"size":        starlark.MakeInt64(info.Size()),
+		"mode":        starlark.MakeUint(uint(info.Mode().Perm())),
+		"mode_string": starlark.String(info.Mode().String()),
+		"is_dir":      starlark.Bool(info.IsDir()),
+		"is_symlink":  starlark.Bool(info.Mode()&os.ModeSymlink != 0),
+		"mtime":       starlark.MakeInt64(info.ModTime().Unix()),
+	}), nil
+}
+
+func (f *files) readlink(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	p, err := f.unpackPath(thread, b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	reader, ok := f.fsys.(afero.LinkReader)
+	if !ok {
+		return nil, fmt.Errorf("%s: the filesystem does not support symlinks", b.Name())
+	}
+	target, err := reader.ReadlinkIfPossible(p)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(target), nil
+}
@@ -0,0 +1,63 @@
+package stdlib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/discentem/starcm/libraries/checksum"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// hashlibModule returns hex digests of strings and files:
+//
+//	hashlib.sha256(s)                    # also sha1 and sha512
+//	hashlib.file(path, algo = "sha256")  # path is relative to the calling file
+func (f *files) hashlibModule() *starlarkstruct.Module {
+	fns := map[string]builtinFunc{
+		"file": f.hashFile,
+	}
+	for _, algo := range []string{checksum.SHA1, checksum.SHA256, checksum.SHA512} {
+		fns[algo] = hashString(algo)
+	}
+	return module("hashlib", fns)
+}
+
+func hashString(algo string) builtinFunc {
+	return func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s starlark.Value
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+			return nil, err
+		}
+		data, err := stringOrBytes(b, s)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := checksum.FromReader(algo, strings.NewReader(string(data)))
+		if err != nil {
+			return nil, err
+		}
+		return starlark.String(sum.Hex), nil
+	}
+}
+
+func (f *files) hashFile(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		p    string
+		algo = checksum.SHA256
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p, "algo?", &algo); err != nil {
+		return nil, err
+	}
+	resolved := f.resolve(thread, p)
+	file, err := f.fsys.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sum, err := checksum.FromReader(algo, file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", resolved, err)
+	}
+	return starlark.String(sum.Hex), nil
+}
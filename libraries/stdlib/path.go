@@ -0,0 +1,58 @@
+package stdlib
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// pathModule manipulates paths without touching the filesystem:
+//
+//	path.join(*parts)
+//	path.dirname(p)
+//	path.basename(p)
+//	path.abs(p)  # relative to the calling file; "//" paths are relative to the workspace
+func (f *files) pathModule() *starlarkstruct.Module {
+	return module("path", map[string]builtinFunc{
+		"join":     pathJoin,
+		"dirname":  pathFunc(filepath.Dir),
+		"basename": pathFunc(filepath.Base),
+		"abs":      f.abs,
+	})
+}
+
+func pathJoin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), nil, kwargs); err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := starlark.AsString(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s: part %d is a %s, want string", b.Name(), i, arg.Type())
+		}
+		parts[i] = s
+	}
+	return starlark.String(filepath.Join(parts...)), nil
+}
+
+// pathFunc wraps a function of one path.
+func pathFunc(fn func(string) string) builtinFunc {
+	return func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var p string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &p); err != nil {
+			return nil, err
+		}
+		return starlark.String(fn(p)), nil
+	}
+}
+
+func (f *files) abs(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var p string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &p); err != nil {
+		return nil, err
+	}
+	return starlark.String(f.resolve(thread, p)), nil
+}
@@ -0,0 +1,193 @@
+package stdlib
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// reModule matches regular expressions in Go's RE2 syntax. Names follow Python's re module:
+//
+//	re.match(pattern, s)                # a match at the start of s, or None
+//	re.search(pattern, s)               # the first match anywhere in s, or None
+//	re.findall(pattern, s)              # like Python: the matches, the group, or tuples of groups
+//	re.sub(pattern, repl, s, count = 0) # repl may refer to groups as $1 or ${name}
+//	re.split(pattern, s, maxsplit = 0)
+//
+// A match is a struct with text, start, end, groups (a tuple, None for groups that did not take part) and
+// named (a dict of the named groups).
+type reModule struct {
+	mu    sync.Mutex
+	cache map[string]*regexp.Regexp
+}
+
+func newReModule() *starlarkstruct.Module {
+	r := &reModule{cache: map[string]*regexp.Regexp{}}
+	return module("re", map[string]builtinFunc{
+		"match":   r.match,
+		"search":  r.search,
+		"findall": r.findall,
+		"sub":     r.sub,
+		"split":   r.split,
+	})
+}
+
+// compile returns the compiled pattern, caching it since configs tend to use the same patterns in loops.
+func (r *reModule) compile(b *starlark.Builtin, pattern string) (*regexp.Regexp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if re, ok := r.cache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	r.cache[pattern] = re
+	return re, nil
+}
+
+func (r *reModule) unpack(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (*regexp.Regexp, string, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, "", err
+	}
+	re, err := r.compile(b, pattern)
+	return re, s, err
+}
+
+func (r *reModule) match(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, err
+	}
+	re, err := r.compile(b, `^(?:`+pattern+`)`)
+	if err != nil {
+		return nil, err
+	}
+	return matchValue(re, s, re.FindStringSubmatchIndex(s)), nil
+}
+
+func (r *reModule) search(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := r.unpack(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return matchValue(re, s, re.FindStringSubmatchIndex(s)), nil
+}
+
+// matchValue returns the match struct for the submatch indices loc, or None when loc is nil.
+func matchValue(re *regexp.Regexp, s string, loc []int) starlark.Value {
+	if loc == nil {
+		return starlark.None
+	}
+	groups := make(starlark.Tuple, 0, re.NumSubexp())
+	named := starlark.NewDict(re.NumSubexp())
+	for i, name := range re.SubexpNames()[1:] {
+		g := group(s, loc, i+1)
+		groups = append(groups, g)
+		if name != "" {
+			_ = named.SetKey(starlark.String(name), g)
+		}
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"text":   starlark.String(s[loc[0]:loc[1]]),
+		"start":  starlark.MakeInt(loc[0]),
+		"end":    starlark.MakeInt(loc[1]),
+		"groups": groups,
+		"named":  named,
+	})
+}
+
+// group returns submatch i, or None if it did not take part in the match.
+func group(s string, loc []int, i int) starlark.Value {
+	if loc[2*i] < 0 {
+		return starlark.None
+	}
+	return starlark.String(s[loc[2*i]:loc[2*i+1]])
+}
+
+func (r *reModule) findall(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := r.unpack(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	var results []starlark.Value
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		switch re.NumSubexp() {
+		case 0:
+			results = append(results, starlark.String(s[loc[0]:loc[1]]))
+		case 1:
+			// Like Python, a group that did not take part is an empty string here.
+			g := group(s, loc, 1)
+			if g == starlark.None {
+				g = starlark.String("")
+			}
+			results = append(results, g)
+		default:
+			groups := make(starlark.Tuple, 0, re.NumSubexp())
+			for i := 1; i <= re.NumSubexp(); i++ {
+				g := group(s, loc, i)
+				if g == starlark.None {
+					g = starlark.String("")
+				}
+				groups = append(groups, g)
+			}
+			results = append(results, groups)
+		}
+	}
+	return starlark.NewList(results), nil
+}
+
+func (r *reModule) sub(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		pattern, repl, s string
+		count            int
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "repl", &repl, "s", &s, "count?", &count); err != nil {
+		return nil, err
+	}
+	re, err := r.compile(b, pattern)
+	if err != nil {
+		return nil, err
+	}
+	n := -1
+	if count > 0 {
+		n = count
+	}
+	var out []byte
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, n) {
+		out = append(out, s[last:loc[0]]...)
+		out = re.ExpandString(out, repl, s, loc)
+		last = loc[1]
+	}
+	out = append(out, s[last:]...)
+	return starlark.String(out), nil
+}
+
+func (r *reModule) split(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		pattern, s string
+		maxsplit   int
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s, "maxsplit?", &maxsplit); err != nil {
+		return nil, err
+	}
+	re, err := r.compile(b, pattern)
+	if err != nil {
+		return nil, err
+	}
+	n := -1
+	if maxsplit > 0 {
+		n = maxsplit + 1
+	}
+	var parts []starlark.Value
+	for _, part := range re.Split(s, n) {
+		parts = append(parts, starlark.String(part))
+	}
+	return starlark.NewList(parts), nil
+}
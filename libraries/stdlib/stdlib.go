@@ -0,0 +1,78 @@
+package stdlib
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/discentem/starcm/libraries/workspace"
+	"github.com/spf13/afero"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	"go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Modules returns the members of the "stdlib" module. The fs module and hashlib.file read from fsys, with
+// the same path rules as load(): "//" paths are relative to workspacePath and other relative paths to the
+// calling file.
+func Modules(fsys afero.Fs, workspacePath string) starlark.StringDict {
+	files := &files{fsys: fsys, workspacePath: workspacePath}
+	return starlark.StringDict{
+		"struct":  starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"json":    json.Module,
+		"math":    math.Module,
+		"time":    time.Module,
+		"yaml":    yamlModule,
+		"re":      newReModule(),
+		"base64":  base64Module,
+		"hashlib": files.hashlibModule(),
+		"fs":      files.fsModule(),
+		"path":    files.pathModule(),
+	}
+}
+
+// files resolves and reads the paths passed to stdlib functions.
+type files struct {
+	fsys          afero.Fs
+	workspacePath string
+}
+
+// resolve returns the absolute filesystem path for p as passed by the Starlark code calling a builtin. The
+// path is absolute so that paths returned to Starlark, like fs.glob's, resolve to the same file when passed back.
+func (f *files) resolve(thread *starlark.Thread, p string) string {
+	var callerDir string
+	// Frame 0 is the builtin itself and frame 1 the Starlark code that called it.
+	if len(thread.CallStack()) > 1 {
+		callerDir = filepath.Dir(thread.CallStack().At(1).Pos.Filename())
+	}
+	resolved := workspace.Resolve(f.workspacePath, callerDir, p)
+	if abs, err := filepath.Abs(resolved); err == nil {
+		return abs
+	}
+	return resolved
+}
+
+// builtinFunc is the signature of the functions behind starlark.NewBuiltin.
+type builtinFunc = func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+
+// module returns a Starlark module with the given builtins, named "name.function" in error messages.
+func module(name string, fns map[string]builtinFunc) *starlarkstruct.Module {
+	members := make(starlark.StringDict, len(fns))
+	for fn, impl := range fns {
+		members[fn] = starlark.NewBuiltin(name+"."+fn, impl)
+	}
+	return &starlarkstruct.Module{Name: name, Members: members}
+}
+
+// stringOrBytes returns the content of a string or bytes argument.
+func stringOrBytes(b *starlark.Builtin, v starlark.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return []byte(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("%s: got %s, want string or bytes", b.Name(), v.Type())
+	}
+}
@@ -0,0 +1,205 @@
+package stdlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+func TestModules(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		aferohelpers.FileDefinition{Path: "/repo/roles/web/hello.txt", Content: "hello world"},
+		aferohelpers.FileDefinition{Path: "/repo/top.txt", Content: "hello world"},
+	)
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{
+			name: "json",
+			script: `
+assert_eq(json.decode('{"a": [1, 2.5, null]}'), {"a": [1, 2.5, None]})
+assert_eq(json.encode({"a": True}), '{"a":true}')
+`,
+		},
+		{
+			name: "yaml",
+			script: `
+assert_eq(yaml.decode("a: 1\nb: [x, y]\nc: {d: true}\n"), {"a": 1, "b": ["x", "y"], "c": {"d": True}})
+assert_eq(yaml.decode(yaml.encode({"name": "web", "ports": [80, 443]})), {"name": "web", "ports": [80, 443]})
+assert_eq(yaml.encode({"a": 1}), "a: 1\n")
+`,
+		},
+		{
+			name:    "yaml non-string keys",
+			script:  `yaml.decode("1: a")`,
+			wantErr: "cannot represent the document in Starlark",
+		},
+		{
+			name:    "yaml encode function",
+			script:  `yaml.encode(len)`,
+			wantErr: "builtin_function_or_method",
+		},
+		{
+			name: "re",
+			script: `
+m = re.match(r"(\w+)-(?P<n>\d+)", "web-12.example.com")
+assert_eq((m.text, m.start, m.end, m.groups, m.named), ("web-12", 0, 6, ("web", "12"), {"n": "12"}))
+assert_eq(re.match(r"\d+", "web-12"), None)
+assert_eq(re.match("a|ab", "abc").text, "a")
+assert_eq(re.search(r"\d+", "web-12").text, "12")
+assert_eq(re.search(r"(x)?\d", "1").groups, (None,))
+assert_eq(re.findall(r"\d+", "a1b22c333"), ["1", "22", "333"])
+assert_eq(re.findall(r"(\w)=(\d)", "a=1 b=2"), [("a", "1"), ("b", "2")])
+assert_eq(re.findall(r"(\w)=\d", "a=1 b=2"), ["a", "b"])
+assert_eq(re.sub(r"(\w+)@(\w+)", "${2}:$1", "alice@web bob@db"), "web:alice db:bob")
+assert_eq(re.sub("o", "0", "foo boo", count = 2), "f00 boo")
+assert_eq(re.split(r"\s*,\s*", "a , b,c"), ["a", "b", "c"])
+assert_eq(re.split(",", "a,b,c", maxsplit = 1), ["a", "b,c"])
+`,
+		},
+		{
+			name:    "re invalid pattern",
+			script:  `re.search("(", "x")`,
+			wantErr: "re.search: error parsing regexp",
+		},
+		{
+			name: "base64",
+			script: `
+assert_eq(base64.encode("hello?"), "aGVsbG8/")
+assert_eq(base64.encode("hello?", url = True), "aGVsbG8_")
+assert_eq(base64.decode("aGVsbG8_", url = True), "hello?")
+assert_eq(base64.decode(base64.encode(b"\x00\x7f")), "\x00\x7f")
+`,
+		},
+		{
+			name:    "base64 invalid",
+			script:  `base64.decode("!!")`,
+			wantErr: "illegal base64 data",
+		},
+		{
+			name: "hashlib",
+			script: `
+digest = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+assert_eq(hashlib.sha256("hello world"), digest)
+assert_eq(hashlib.sha256(b"hello world"), digest)
+assert_eq(hashlib.file("hello.txt"), digest)
+assert_eq(hashlib.file("//top.txt"), digest)
+assert_eq(hashlib.file("/repo/top.txt", algo = "sha1"), hashlib.sha1("hello world"))
+assert_eq(len(hashlib.sha512("")), 128)
+`,
+		},
+		{
+			name:    "hashlib missing file",
+			script:  `hashlib.file("missing.txt")`,
+			wantErr: "/repo/roles/web/missing.txt",
+		},
+		{
+			name:    "hashlib unknown algo",
+			script:  `hashlib.file("hello.txt", algo = "md5")`,
+			wantErr: `unsupported checksum algorithm "md5"`,
+		},
+		{
+			name:    "hashlib wrong type",
+			script:  `hashlib.sha256(1)`,
+			wantErr: "hashlib.sha256: got int, want string or bytes",
+		},
+		{
+			name: "fs",
+			script: `
+assert_eq(fs.exists("hello.txt"), True)
+assert_eq(fs.exists("//top.txt"), True)
+assert_eq(fs.exists("missing.txt"), False)
+assert_eq(fs.is_dir("."), True)
+assert_eq(fs.is_dir("hello.txt"), False)
+assert_eq(fs.is_dir("missing"), False)
+assert_eq(fs.read("hello.txt"), "hello world")
+assert_eq(fs.glob("*.txt"), ["/repo/roles/web/hello.txt"])
+assert_eq(fs.glob("//*.txt"), ["/repo/top.txt"])
+assert_eq([fs.read(p) for p in fs.glob("*.txt")], ["hello world"])
+st = fs.stat("hello.txt")
+assert_eq((st.name, st.size, st.mode, st.mode_string, st.is_dir, st.is_symlink), ("hello.txt", 11, 0o644, "-rw-r--r--", False, False))
+`,
+		},
+		{
+			name:    "fs read missing",
+			script:  `fs.read("missing.txt")`,
+			wantErr: "/repo/roles/web/missing.txt",
+		},
+		{
+			name:    "fs readlink unsupported",
+			script:  `fs.readlink("hello.txt")`,
+			wantErr: "fs.readlink: the filesystem does not support symlinks",
+		},
+		{
+			name: "path",
+			script: `
+assert_eq(path.join("a", "b/", "../c.txt"), "a/c.txt")
+assert_eq(path.dirname("/etc/nginx/nginx.conf"), "/etc/nginx")
+assert_eq(path.basename("/etc/nginx/nginx.conf"), "nginx.conf")
+assert_eq(path.abs("templates/a.j2"), "/repo/roles/web/templates/a.j2")
+assert_eq(path.abs("//templates/a.j2"), "/repo/templates/a.j2")
+assert_eq(path.abs("/etc/hosts"), "/etc/hosts")
+`,
+		},
+		{
+			name:    "path join wrong type",
+			script:  `path.join("a", 1)`,
+			wantErr: "path.join: part 1 is a int, want string",
+		},
+		{
+			name: "time and math",
+			script: `
+assert_eq(time.parse_duration("1m30s").seconds, 90.0)
+assert_eq(math.floor(2.7), 2)
+`,
+		},
+		{
+			name: "struct",
+			script: `
+assert_eq(struct(a = 1).a, 1)
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predeclared := Modules(fsys, "/repo")
+			predeclared["assert_eq"] = starlark.NewBuiltin("assert_eq", func(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, _ []starlark.Tuple) (starlark.Value, error) {
+				if eq, err := starlark.Equal(args[0], args[1]); err != nil || !eq {
+					t.Errorf("%s != %s", args[0], args[1])
+				}
+				return starlark.None, nil
+			})
+			_, err := starlark.ExecFileOptions(&syntax.FileOptions{}, &starlark.Thread{}, "/repo/roles/web/main.star", tt.script, predeclared)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFs_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "target.txt"), []byte("x"), 0644))
+	if err := os.Symlink("target.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+	require.NoError(t, os.Symlink("missing.txt", filepath.Join(dir, "broken")))
+
+	_, err := starlark.ExecFileOptions(&syntax.FileOptions{TopLevelControl: true}, &starlark.Thread{}, filepath.Join(dir, "main.star"), `
+if fs.readlink("link") != "target.txt" or not fs.stat("link").is_symlink:
+    fail("link")
+if fs.exists("broken") or not fs.stat("broken").is_symlink:
+    fail("broken")
+`, Modules(afero.NewOsFs(), dir))
+	require.NoError(t, err)
+}
@@ -0,0 +1,50 @@
+package stdlib
+
+import (
+	"fmt"
+
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"go.starlark.net/starlark"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlModule converts between Starlark values and YAML:
+//
+//	yaml.encode(x)  # the YAML document for x
+//	yaml.decode(s)  # the value of the YAML document s
+var yamlModule = module("yaml", map[string]builtinFunc{
+	"encode": yamlEncode,
+	"decode": yamlDecode,
+})
+
+func yamlEncode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	v, err := starlarkhelpers.ToGo(x)
+	if err != nil {
+		return nil, err
+	}
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(out), nil
+}
+
+func yamlDecode(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	var v any
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return nil, err
+	}
+	value, err := starlarkhelpers.FromGo(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot represent the document in Starlark: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,132 @@
+package textfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Document is a line-oriented view of a text file used by modules that edit files in place.
+type Document struct {
+	Lines []string
+	// TrailingNewline records whether the original content ended with a newline.
+	TrailingNewline bool
+	// Exists is false if the file did not exist when it was read.
+	Exists bool
+	// Mode is the permission of the existing file, or zero if it did not exist.
+	Mode os.FileMode
+}
+
+// Read loads path from fsys. A missing file yields an empty Document with Exists set to false.
+func Read(fsys afero.Fs, path string) (*Document, error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Document{TrailingNewline: true}, nil
+		}
+		return nil, fmt.Errorf("failed to stat file %q: %w", path, err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%q is a directory, not a file", path)
+	}
+	b, err := afero.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %q: %w", path, err)
+	}
+	d := Parse(string(b))
+	d.Exists = true
+	d.Mode = info.Mode().Perm()
+	return d, nil
+}
+
+// Parse splits content into lines.
+func Parse(content string) *Document {
+	d := &Document{TrailingNewline: true}
+	if content == "" {
+		return d
+	}
+	d.TrailingNewline = strings.HasSuffix(content, "\n")
+	d.Lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	return d
+}
+
+// String joins the lines back into file content.
+func (d *Document) String() string {
+	if len(d.Lines) == 0 {
+		return ""
+	}
+	s := strings.Join(d.Lines, "\n")
+	if d.TrailingNewline {
+		s += "\n"
+	}
+	return s
+}
+
+// Insert inserts lines at index i.
+func (d *Document) Insert(i int, lines ...string) {
+	d.Lines = append(d.Lines[:i], append(append([]string{}, lines...), d.Lines[i:]...)...)
+}
+
+// InsertionPoint returns the index at which new lines should be inserted.
+// insertAfter places lines after the last line matching the regex and insertBefore places them before the
+// first matching line. The special values "EOF" and "BOF" mean the end and beginning of the file. If neither is
+// set, or the regex matches nothing, lines are appended at the end of the file.
+func (d *Document) InsertionPoint(insertAfter, insertBefore string) (int, error) {
+	switch {
+	case insertAfter != "" && insertBefore != "":
+		return 0, fmt.Errorf("insert_after and insert_before are mutually exclusive")
+	case insertBefore == "BOF":
+		return 0, nil
+	case insertAfter == "" && insertBefore == "", insertAfter == "EOF":
+		return len(d.Lines), nil
+	case insertAfter != "":
+		re, err := regexp.Compile(insertAfter)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insert_after regex %q: %w", insertAfter, err)
+		}
+		for i := len(d.Lines) - 1; i >= 0; i-- {
+			if re.MatchString(d.Lines[i]) {
+				return i + 1, nil
+			}
+		}
+	default:
+		re, err := regexp.Compile(insertBefore)
+		if err != nil {
+			return 0, fmt.Errorf("invalid insert_before regex %q: %w", insertBefore, err)
+		}
+		for i, l := range d.Lines {
+			if re.MatchString(l) {
+				return i, nil
+			}
+		}
+	}
+	return len(d.Lines), nil
+}
+
+// Write replaces the content of path, creating parent directories as needed.
+func Write(fsys afero.Fs, path string, content string, mode os.FileMode) error {
+	if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directories for %q: %w", path, err)
+	}
+	f, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to open file %q for writing: %w", path, err)
+	}
+	defer f.Close()
+
+	n, err := f.WriteString(content)
+	if err != nil {
+		return fmt.Errorf("failed to write to file %q: %w", path, err)
+	}
+	if n != len(content) {
+		return fmt.Errorf("incomplete write to %q: wrote %d bytes out of %d", path, n, len(content))
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package vars
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	starlarkhelpers "github.com/discentem/starcm/starlark-helpers"
+	"github.com/spf13/afero"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix marks environment variables that set input variables: STARCM_VAR_REGION=eu sets "region".
+const EnvPrefix = "STARCM_VAR_"
+
+// Type names accepted by the type= argument of vars.get and vars.require.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeList   = "list"
+	TypeDict   = "dict"
+)
+
+// variable is one input variable and where it came from, for error messages.
+type variable struct {
+	value  starlark.Value
+	source string
+	// text is true for values given as plain text on the command line or in the environment. They are strings
+	// unless the config asks for another type, in which case the text is parsed as that type.
+	text bool
+}
+
+// Vars holds the input variables of a run. Later sources override earlier ones, so callers add var files
+// first, then the environment, then --var flags.
+type Vars struct {
+	values map[string]variable
+}
+
+// New returns an empty set of variables.
+func New() *Vars {
+	return &Vars{values: map[string]variable{}}
+}
+
+// SetString sets name to the plain-text value raw.
+func (v *Vars) SetString(name, raw, source string) {
+	v.values[name] = variable{value: starlark.String(raw), source: source, text: true}
+}
+
+// ParseAssignment sets a variable from a "name=value" command-line argument.
+func (v *Vars) ParseAssignment(s string) error {
+	name, raw, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid --var %q, want name=value", s)
+	}
+	v.SetString(name, raw, "--var")
+	return nil
+}
+
+// LoadEnviron sets a variable for every STARCM_VAR_* entry in environ, which has the form of os.Environ().
+// Names are lower-cased, so STARCM_VAR_DB_HOST sets "db_host".
+func (v *Vars) LoadEnviron(environ []string) {
+	for _, kv := range environ {
+		key, raw, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) || key == EnvPrefix {
+			continue
+		}
+		v.SetString(strings.ToLower(strings.TrimPrefix(key, EnvPrefix)), raw, key)
+	}
+}
+
+// LoadFile sets the variables in a JSON or YAML file, chosen by extension. The file must hold a mapping;
+// its values keep their types.
+func (v *Vars) LoadFile(fsys afero.Fs, path string) error {
+	data, err := afero.ReadFile(fsys, path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		var decoded any
+		if decoded, err = starlarkhelpers.DecodeJSON(data); err == nil {
+			var ok bool
+			if values, ok = decoded.(map[string]any); !ok {
+				err = fmt.Errorf("want a mapping of variables")
+			}
+		}
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("var file %q: unsupported extension %q, want .json, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("var file %q: %w", path, err)
+	}
+	for name, value := range values {
+		sv, err := starlarkhelpers.FromGo(value)
+		if err != nil {
+			return fmt.Errorf("var file %q: %q: %w", path, name, err)
+		}
+		// Every vars.get returns the same value, so it is frozen to keep one module from changing it for the rest.
+		sv.Freeze()
+		v.values[name] = variable{value: sv, source: path}
+	}
+	return nil
+}
+
+// Get returns the value of name converted to typ, or to its natural type when typ is empty.
+func (v *Vars) Get(name, typ string) (starlark.Value, bool, error) {
+	variable, ok := v.values[name]
+	if !ok {
+		return nil, false, nil
+	}
+	value, err := variable.as(typ)
+	if err != nil {
+		return nil, true, fmt.Errorf("variable %q from %s: %w", name, variable.source, err)
+	}
+	return value, true, nil
+}
+
+// Names returns the names of all variables, sorted.
+func (v *Vars) Names() []string {
+	names := make([]string, 0, len(v.values))
+	for name := range v.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// as converts the variable to typ. Text is parsed; values from var files must already have the type, except
+// that an int is accepted as a float.
+func (x variable) as(typ string) (starlark.Value, error) {
+	if typ == "" {
+		return x.value, nil
+	}
+	if x.text {
+		return parseText(string(x.value.(starlark.String)), typ)
+	}
+	if !knownType(typ) {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	if typ == TypeFloat {
+		if i, ok := x.value.(starlark.Int); ok {
+			return i.Float(), nil
+		}
+	}
+	if x.value.Type() != typ {
+		return nil, fmt.Errorf("got a %s, want %s", x.value.Type(), typ)
+	}
+	return x.value, nil
+}
+
+// parseText parses a plain-text value as typ. Lists and dicts are written as JSON.
+func parseText(raw, typ string) (starlark.Value, error) {
+	switch typ {
+	case TypeString:
+		return starlark.String(raw), nil
+	case TypeInt:
+		i, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an int", raw)
+		}
+		return starlark.MakeInt64(i), nil
+	case TypeFloat:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a float", raw)
+		}
+		return starlark.Float(f), nil
+	case TypeBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", raw)
+		}
+		return starlark.Bool(b), nil
+	case TypeList, TypeDict:
+		value, err := starlarkhelpers.DecodeJSON([]byte(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a JSON %s: %w", raw, typ, err)
+		}
+		sv, err := starlarkhelpers.FromGo(value)
+		if err != nil {
+			return nil, err
+		}
+		if sv.Type() != typ {
+			return nil, fmt.Errorf("%q is not a JSON %s", raw, typ)
+		}
+		sv.Freeze()
+		return sv, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
+
+func knownType(typ string) bool {
+	switch typ {
+	case TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeDict:
+		return true
+	}
+	return false
+}
+
+// typeOf returns the type name of a default value, or "" when it does not imply a type.
+func typeOf(v starlark.Value) string {
+	if knownType(v.Type()) {
+		return v.Type()
+	}
+	return ""
+}
+
+// Module returns the "vars" Starlark module:
+//
+//	vars.get(name, default = None, type = None)  # default's type is used when type is not given
+//	vars.require(name, type = None)              # fails when name is not set
+//	vars.names()
+func (v *Vars) Module() *starlarkstruct.Module {
+	return &starlarkstruct.Module{
+		Name: "vars",
+		Members: starlark.StringDict{
+			"get":     starlark.NewBuiltin("vars.get", v.get),
+			"require": starlark.NewBuiltin("vars.require", v.require),
+			"names":   starlark.NewBuiltin("vars.names", v.names),
+		},
+	}
+}
+
+func (v *Vars) get(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name string
+		def  starlark.Value = starlark.None
+		typ  string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &def, "type?", &typ); err != nil {
+		return nil, err
+	}
+	if typ == "" {
+		typ = typeOf(def)
+	}
+	value, ok, err := v.Get(name, typ)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return def, nil
+	}
+	return value, nil
+}
+
+func (v *Vars) require(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, typ string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "type?", &typ); err != nil {
+		return nil, err
+	}
+	value, ok, err := v.Get(name, typ)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("required variable %q is not set: pass --var %s=<value>, set %s%s or add it to a --var-file", name, name, EnvPrefix, strings.ToUpper(name))
+	}
+	return value, nil
+}
+
+func (v *Vars) names(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	var names []starlark.Value
+	for _, name := range v.Names() {
+		names = append(names, starlark.String(name))
+	}
+	return starlark.NewList(names), nil
+}
@@ -0,0 +1,118 @@
+package vars
+
+import (
+	"testing"
+
+	"github.com/discentem/starcm/testhelpers/aferohelpers"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+func testVars(t *testing.T) *Vars {
+	t.Helper()
+	fsys := aferohelpers.NewMemFsWithFiles(
+		aferohelpers.FileDefinition{Path: "/vars.yaml", Content: "region: eu\nreplicas: 3\ntags: [a, b]\ndebug: true\n"},
+		aferohelpers.FileDefinition{Path: "/override.json", Content: `{"region": "us", "limits": {"cpu": 2}}`},
+	)
+	v := New()
+	require.NoError(t, v.LoadFile(fsys, "/vars.yaml"))
+	require.NoError(t, v.LoadFile(fsys, "/override.json"))
+	v.LoadEnviron([]string{"PATH=/bin", "STARCM_VAR_DB_HOST=db1", "STARCM_VAR_PORT=5432", "STARCM_VAR_=ignored"})
+	require.NoError(t, v.ParseAssignment("env=prod=blue"))
+	require.NoError(t, v.ParseAssignment("port=6543"))
+	return v
+}
+
+func TestVars_Sources(t *testing.T) {
+	v := testVars(t)
+	require.Equal(t, []string{"db_host", "debug", "env", "limits", "port", "region", "replicas", "tags"}, v.Names())
+
+	tests := []struct {
+		name    string
+		typ     string
+		want    string
+		wantErr string
+	}{
+		{name: "region", want: `"us"`},
+		{name: "replicas", want: "3"},
+		{name: "replicas", typ: TypeFloat, want: "3.0"},
+		{name: "tags", want: `["a", "b"]`},
+		{name: "limits", typ: TypeDict, want: `{"cpu": 2}`},
+		{name: "env", want: `"prod=blue"`},
+		{name: "db_host", want: `"db1"`},
+		// Text values stay strings unless a type is asked for.
+		{name: "port", want: `"6543"`},
+		{name: "port", typ: TypeInt, want: "6543"},
+		{name: "db_host", typ: TypeInt, wantErr: `variable "db_host" from STARCM_VAR_DB_HOST: "db1" is not an int`},
+		{name: "region", typ: TypeBool, wantErr: `variable "region" from /override.json: got a string, want bool`},
+		{name: "debug", typ: "bytes", wantErr: `unknown type "bytes"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.typ, func(t *testing.T) {
+			got, ok, err := v.Get(tt.name, tt.typ)
+			require.True(t, ok)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestVars_Errors(t *testing.T) {
+	fsys := aferohelpers.NewMemFsWithFiles(
+		aferohelpers.FileDefinition{Path: "/vars.toml", Content: "a = 1"},
+		aferohelpers.FileDefinition{Path: "/list.json", Content: "[1]"},
+		aferohelpers.FileDefinition{Path: "/trailing.json", Content: `{"a": 1} {}`},
+	)
+	v := New()
+	require.ErrorContains(t, v.ParseAssignment("novalue"), "want name=value")
+	require.ErrorContains(t, v.ParseAssignment("=x"), "want name=value")
+	require.ErrorContains(t, v.LoadFile(fsys, "/vars.toml"), "unsupported extension")
+	require.ErrorContains(t, v.LoadFile(fsys, "/list.json"), "want a mapping")
+	require.ErrorContains(t, v.LoadFile(fsys, "/trailing.json"), "unexpected data")
+	require.Error(t, v.LoadFile(fsys, "/missing.yaml"))
+}
+
+func TestVars_Module(t *testing.T) {
+	v := testVars(t)
+	predeclared := starlark.StringDict{"vars": v.Module()}
+	_, err := starlark.ExecFileOptions(&syntax.FileOptions{TopLevelControl: true}, &starlark.Thread{}, "main.star", `
+if vars.require("region") != "us":
+    fail("region")
+if vars.get("port", 0) != 6543:
+    fail("port should be an int because the default is")
+if vars.get("missing", "fallback") != "fallback":
+    fail("missing")
+if vars.get("missing") != None:
+    fail("missing without default")
+if vars.require("port", type = "int") + 1 != 6544:
+    fail("typed require")
+if "tags" not in vars.names():
+    fail("names")
+`, predeclared)
+	require.NoError(t, err)
+
+	_, err = starlark.ExecFile(&starlark.Thread{}, "main.star", `vars.require("api_token")`, predeclared)
+	require.ErrorContains(t, err, `required variable "api_token" is not set: pass --var api_token=<value>, set STARCM_VAR_API_TOKEN or add it to a --var-file`)
+}
+
+func TestVars_ValuesAreFrozen(t *testing.T) {
+	v := testVars(t)
+	require.NoError(t, v.ParseAssignment(`zones=["a"]`))
+	predeclared := starlark.StringDict{"vars": v.Module()}
+	for _, src := range []string{
+		`vars.get("tags").append("c")`,
+		`vars.get("limits")["cpu"] = 4`,
+		`vars.get("zones", type = "list").append("b")`,
+	} {
+		_, err := starlark.ExecFile(&starlark.Thread{}, "main.star", src, predeclared)
+		require.ErrorContains(t, err, "frozen", src)
+	}
+	tags, _, err := v.Get("tags", "")
+	require.NoError(t, err)
+	require.Equal(t, `["a", "b"]`, tags.String())
+}
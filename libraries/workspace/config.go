@@ -0,0 +1,206 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/afero"
+)
+
+// ConfigName is the file that marks the root of a workspace.
+const ConfigName = "starcm.toml"
+
+// Config is the content of starcm.toml.
+//
+//	[flags]                      # defaults for command-line flags
+//	v = 2
+//
+//	[http]                       # HTTP client settings, overridden by --ca-file and friends
+//	ca_file = "certs/corp.pem"
+//	timeout = "30s"
+//
+//	[modules]
+//	search_path = ["lib"]        # extra directories for load("x.star")
+//
+//	[module_defaults.download]   # keyword arguments used when a builtin call omits them
+//	retries = 5
+//
+//	[facts]                      # custom facts, instead of /etc/starcm/facts.d
+//	dirs = ["facts.d"]
+//	timeout = "5s"               # for each fact executable
+//
+//	[repositories.corp_lib]      # load("@corp_lib//network.star")
+//	path = "../corp-lib"
+//
+//	[repositories.tools]
+//	archive = "third_party/tools.tar.gz"
+//	strip_components = 1
+//
+// Relative paths are relative to the directory containing starcm.toml.
+type Config struct {
+	// Root is the directory containing starcm.toml. It is the workspace root for "//" paths.
+	Root string `toml:"-"`
+
+	Flags          map[string]any            `toml:"flags"`
+	HTTP           HTTPConfig                `toml:"http"`
+	Modules        ModulesConfig             `toml:"modules"`
+	ModuleDefaults map[string]map[string]any `toml:"module_defaults"`
+	Facts          FactsConfig               `toml:"facts"`
+	Repositories   map[string]Repository     `toml:"repositories"`
+}
+
+// HTTPConfig configures the HTTP client used for downloads and remote modules.
+type HTTPConfig struct {
+	CAFile     string `toml:"ca_file"`
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+	Proxy      string `toml:"proxy"`
+	Timeout    string `toml:"timeout"`
+}
+
+// ModulesConfig configures how load() finds modules.
+type ModulesConfig struct {
+	SearchPath []string `toml:"search_path"`
+}
+
+// FactsConfig configures custom facts.
+type FactsConfig struct {
+	// Dirs replaces the default facts.d directories when set.
+	Dirs    []string `toml:"dirs"`
+	Timeout string   `toml:"timeout"`
+}
+
+// Repository is a named library that configs load from with "@name//path.star". It is either a
+// directory or an archive, which is extracted in memory when first used.
+type Repository struct {
+	Path            string `toml:"path"`
+	Archive         string `toml:"archive"`
+	StripComponents int    `toml:"strip_components"`
+}
+
+// validRepositoryName matches the names allowed after "@" in load().
+var validRepositoryName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Discover walks up from dir to the first directory containing starcm.toml and loads it.
+// It returns nil without an error when there is none.
+func Discover(fsys afero.Fs, dir string) (*Config, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p := filepath.Join(dir, ConfigName)
+		if _, err := fsys.Stat(p); err == nil {
+			return LoadConfig(fsys, p)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads the starcm.toml at p.
+func LoadConfig(fsys afero.Fs, p string) (*Config, error) {
+	data, err := afero.ReadFile(fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := toml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", p, err)
+	}
+	if cfg.HTTP.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.HTTP.Timeout); err != nil {
+			return nil, fmt.Errorf("%s: http.timeout: %w", p, err)
+		}
+	}
+	if cfg.Facts.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.Facts.Timeout); err != nil {
+			return nil, fmt.Errorf("%s: facts.timeout: %w", p, err)
+		}
+	}
+	cfg.Root = filepath.Dir(p)
+	for _, f := range []*string{&cfg.HTTP.CAFile, &cfg.HTTP.ClientCert, &cfg.HTTP.ClientKey} {
+		if *f != "" {
+			*f = Resolve(cfg.Root, "", *f)
+		}
+	}
+	for i, dir := range cfg.Modules.SearchPath {
+		cfg.Modules.SearchPath[i] = Resolve(cfg.Root, "", dir)
+	}
+	for i, dir := range cfg.Facts.Dirs {
+		cfg.Facts.Dirs[i] = Resolve(cfg.Root, "", dir)
+	}
+	for name, repo := range cfg.Repositories {
+		if !validRepositoryName.MatchString(name) {
+			return nil, fmt.Errorf("%s: repositories.%s: invalid repository name", p, name)
+		}
+		if (repo.Path == "") == (repo.Archive == "") {
+			return nil, fmt.Errorf("%s: repositories.%s: exactly one of path and archive must be set", p, name)
+		}
+		if repo.StripComponents != 0 && repo.Archive == "" {
+			return nil, fmt.Errorf("%s: repositories.%s: strip_components only applies to archives", p, name)
+		}
+		for _, f := range []*string{&repo.Path, &repo.Archive} {
+			if *f != "" {
+				*f = Resolve(cfg.Root, "", *f)
+			}
+		}
+		cfg.Repositories[name] = repo
+	}
+	return &cfg, nil
+}
+
+// pathFlags are the flags that take paths. Relative paths for them in [flags] are relative to the workspace
+// root, like every other path in starcm.toml, rather than to the directory starcm runs in.
+var pathFlags = map[string]bool{
+	"ca-file":     true,
+	"client-cert": true,
+	"client-key":  true,
+	"cache-dir":   true,
+	"var-file":    true,
+}
+
+// FlagDefaults returns the command-line flag values set by the config, keyed by flag name. Arrays give one
+// value per element, for flags that can be repeated such as var-file. The [http] section maps onto
+// --ca-file, --client-cert, --client-key, --proxy and --http-timeout.
+func (c *Config) FlagDefaults() map[string][]string {
+	flags := make(map[string][]string, len(c.Flags)+5)
+	for name, v := range c.Flags {
+		var values []string
+		if list, ok := v.([]any); ok {
+			for _, item := range list {
+				values = append(values, fmt.Sprint(item))
+			}
+		} else {
+			values = []string{fmt.Sprint(v)}
+		}
+		if pathFlags[name] {
+			for i, p := range values {
+				values[i] = Resolve(c.Root, "", p)
+			}
+		}
+		flags[name] = values
+	}
+	for name, v := range map[string]string{
+		"ca-file":      c.HTTP.CAFile,
+		"client-cert":  c.HTTP.ClientCert,
+		"client-key":   c.HTTP.ClientKey,
+		"proxy":        c.HTTP.Proxy,
+		"http-timeout": c.HTTP.Timeout,
+	} {
+		if v != "" {
+			flags[name] = []string{v}
+		}
+	}
+	return flags
+}
@@ -0,0 +1,94 @@
+package workspace
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+[flags]
+v = 2
+no-cache = true
+cache-dir = "build/cache"
+var-file = ["vars/common.yaml", "/etc/starcm/site.json"]
+
+[http]
+ca_file = "certs/corp.pem"
+proxy = "http://proxy:3128"
+timeout = "30s"
+
+[modules]
+search_path = ["lib", "/opt/starcm/lib"]
+
+[module_defaults.download]
+retries = 5
+
+[facts]
+dirs = ["facts.d", "/etc/starcm/facts.d"]
+timeout = "5s"
+
+[repositories.corp_lib]
+path = "../corp-lib"
+
+[repositories.tools]
+archive = "third_party/tools.tar.gz"
+strip_components = 1
+`
+
+func TestDiscover(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/repo/starcm.toml", []byte(testConfig), 0644))
+	require.NoError(t, fs.MkdirAll("/repo/roles/web", 0755))
+	require.NoError(t, fs.MkdirAll("/elsewhere", 0755))
+
+	cfg, err := Discover(fs, "/repo/roles/web")
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	require.Equal(t, "/repo", cfg.Root)
+	require.Equal(t, []string{"/repo/lib", "/opt/starcm/lib"}, cfg.Modules.SearchPath)
+	require.Equal(t, map[string]any{"retries": int64(5)}, cfg.ModuleDefaults["download"])
+	require.Equal(t, FactsConfig{Dirs: []string{"/repo/facts.d", "/etc/starcm/facts.d"}, Timeout: "5s"}, cfg.Facts)
+	require.Equal(t, map[string]Repository{
+		"corp_lib": {Path: "/corp-lib"},
+		"tools":    {Archive: "/repo/third_party/tools.tar.gz", StripComponents: 1},
+	}, cfg.Repositories)
+	require.Equal(t, map[string][]string{
+		"v":            {"2"},
+		"no-cache":     {"true"},
+		"cache-dir":    {"/repo/build/cache"},
+		"var-file":     {"/repo/vars/common.yaml", "/etc/starcm/site.json"},
+		"ca-file":      {"/repo/certs/corp.pem"},
+		"proxy":        {"http://proxy:3128"},
+		"http-timeout": {"30s"},
+	}, cfg.FlagDefaults())
+
+	cfg, err = Discover(fs, "/elsewhere")
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "invalid toml", content: "[flags", wantErr: "failed to parse"},
+		{name: "invalid timeout", content: "[http]\ntimeout = \"soon\"", wantErr: "http.timeout"},
+		{name: "invalid facts timeout", content: "[facts]\ntimeout = \"5\"", wantErr: "facts.timeout"},
+		{name: "invalid repository name", content: "[repositories.\"a/b\"]\npath = \"x\"", wantErr: "invalid repository name"},
+		{name: "repository without source", content: "[repositories.lib]", wantErr: "exactly one of path and archive"},
+		{name: "repository with both sources", content: "[repositories.lib]\npath = \"x\"\narchive = \"x.zip\"", wantErr: "exactly one of path and archive"},
+		{name: "strip_components without archive", content: "[repositories.lib]\npath = \"x\"\nstrip_components = 1", wantErr: "strip_components"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/repo/starcm.toml", []byte(tt.content), 0644))
+			_, err := LoadConfig(fs, "/repo/starcm.toml")
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package workspace
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Resolve returns the filesystem path for a reference to a module or template file.
+// Paths starting with "//" are relative to workspacePath, absolute paths are returned as is, and any other
+// path is relative to relativeTo, or to workspacePath when relativeTo is empty.
+func Resolve(workspacePath, relativeTo, p string) string {
+	switch {
+	case IsWorkspaceRelative(p):
+		return filepath.Join(workspacePath, p[2:])
+	case filepath.IsAbs(p):
+		return p
+	case relativeTo != "":
+		return filepath.Join(relativeTo, p)
+	default:
+		return filepath.Join(workspacePath, p)
+	}
+}
+
+// IsWorkspaceRelative reports whether p is a "//"-prefixed workspace path.
+func IsWorkspaceRelative(p string) bool {
+	return strings.HasPrefix(p, "//")
+}
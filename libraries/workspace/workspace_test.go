@@ -0,0 +1,26 @@
+package workspace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		relativeTo string
+		path       string
+		want       string
+	}{
+		{name: "workspace relative", relativeTo: "/repo/roles/web", path: "//lib/base.star", want: "/repo/lib/base.star"},
+		{name: "absolute", relativeTo: "/repo/roles/web", path: "/etc/starcm/x.star", want: "/etc/starcm/x.star"},
+		{name: "relative to caller", relativeTo: "/repo/roles/web", path: "../common/x.star", want: "/repo/roles/common/x.star"},
+		{name: "relative without caller", path: "x.star", want: "/repo/x.star"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Resolve("/repo", tt.relativeTo, tt.path))
+		})
+	}
+}
@@ -2,11 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/discentem/starcm/libraries/cache"
+	"github.com/discentem/starcm/libraries/facts"
+	"github.com/discentem/starcm/libraries/httpclient"
 	loader "github.com/discentem/starcm/libraries/loader"
+	"github.com/discentem/starcm/libraries/lockfile"
 	"github.com/discentem/starcm/libraries/shell"
+	"github.com/discentem/starcm/libraries/vars"
+	"github.com/discentem/starcm/libraries/workspace"
 	"github.com/spf13/afero"
 	"github.com/urfave/cli/v2"
 
@@ -14,11 +23,144 @@ import (
 	"github.com/google/deck/backends/logger"
 )
 
+// cacheDir returns the download cache directory from --cache-dir, defaulting to the per-user cache directory.
+func cacheDir(c *cli.Context) (string, error) {
+	if dir := c.String("cache-dir"); dir != "" {
+		return dir, nil
+	}
+	return cache.DefaultDir()
+}
+
+// lockfilePath returns the path of starcm.lock in the workspace.
+func lockfilePath(workspacePath string) string {
+	return filepath.Join(workspacePath, lockfile.Name)
+}
+
+// loadWorkspace finds starcm.toml by walking up from dir and applies its flag defaults to the flags that
+// were not set on the command line. Without a starcm.toml the workspace is the current directory.
+func loadWorkspace(c *cli.Context, fsys afero.Fs, dir string) (string, *workspace.Config, error) {
+	cfg, err := workspace.Discover(fsys, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if cfg == nil {
+		wd, err := os.Getwd()
+		return wd, nil, err
+	}
+	for name, values := range cfg.FlagDefaults() {
+		if c.IsSet(name) {
+			continue
+		}
+		if len(values) > 1 && !isSliceFlag(c, name) {
+			return "", nil, fmt.Errorf("%s: flag %q takes a single value, not a list", filepath.Join(cfg.Root, workspace.ConfigName), name)
+		}
+		// Every Set of a slice flag appends one element.
+		for _, value := range values {
+			if err := c.Set(name, value); err != nil {
+				return "", nil, fmt.Errorf("%s: flag %q: %w", filepath.Join(cfg.Root, workspace.ConfigName), name, err)
+			}
+		}
+	}
+	return cfg.Root, cfg, nil
+}
+
+// isSliceFlag reports whether the flag called name can be repeated.
+func isSliceFlag(c *cli.Context, name string) bool {
+	for _, f := range c.App.Flags {
+		if _, ok := f.(*cli.StringSliceFlag); ok && f.Names()[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadVars collects the input variables of the run. --var-file files are read in order, then STARCM_VAR_*
+// environment variables and --var flags override them.
+func loadVars(c *cli.Context, fsys afero.Fs) (*vars.Vars, error) {
+	v := vars.New()
+	for _, p := range c.StringSlice("var-file") {
+		if err := v.LoadFile(fsys, p); err != nil {
+			return nil, err
+		}
+	}
+	v.LoadEnviron(os.Environ())
+	for _, assignment := range c.StringSlice("var") {
+		if err := v.ParseAssignment(assignment); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// newLoader builds the loader from the global flags and the workspace config, which may be nil.
+func newLoader(ctx context.Context, c *cli.Context, fsys afero.Fs, workspacePath string, cfg *workspace.Config, lf *lockfile.Lockfile) (loader.Loader, error) {
+	httpClient, err := httpclient.New(fsys, httpclient.Config{
+		CAFile:   c.String("ca-file"),
+		CertFile: c.String("client-cert"),
+		KeyFile:  c.String("client-key"),
+		Proxy:    c.String("proxy"),
+		Timeout:  c.Duration("http-timeout"),
+	})
+	if err != nil {
+		return loader.Loader{}, err
+	}
+
+	inputs, err := loadVars(c, fsys)
+	if err != nil {
+		return loader.Loader{}, err
+	}
+
+	// The facts.d settings from the config are filled in below.
+	gatherer := facts.NewGatherer(fsys)
+
+	loaderOpts := []loader.LoaderOption{
+		loader.WithHTTPClient(httpClient),
+		loader.WithVars(inputs),
+		loader.WithFacts(gatherer),
+		loader.WithLockfile(lf),
+		loader.WithLocked(c.Bool("locked")),
+	}
+	if c.Bool("trace-loads") {
+		loaderOpts = append(loaderOpts, loader.WithLoadTrace(os.Stderr))
+	}
+	if cfg != nil {
+		if cfg.Facts.Dirs != nil {
+			gatherer.CustomDirs = cfg.Facts.Dirs
+		}
+		if cfg.Facts.Timeout != "" {
+			// LoadConfig has validated the timeout.
+			gatherer.CustomTimeout, _ = time.ParseDuration(cfg.Facts.Timeout)
+		}
+		loaderOpts = append(loaderOpts,
+			loader.WithSearchPaths(cfg.Modules.SearchPath...),
+			loader.WithModuleDefaults(cfg.ModuleDefaults),
+			loader.WithRepositories(cfg.Repositories),
+		)
+	}
+	if !c.Bool("no-cache") {
+		dir, err := cacheDir(c)
+		if err != nil {
+			return loader.Loader{}, err
+		}
+		loaderOpts = append(loaderOpts, loader.WithDownloadCache(cache.New(fsys, dir)))
+	}
+
+	return loader.Default(
+		ctx,
+		fsys,
+		&shell.RealExecutor{},
+		workspacePath,
+		loaderOpts...,
+	), nil
+}
+
 func main() {
 	app := &cli.App{
 		Name:  "starcm",
 		Usage: "A configuration management language using Starlark",
 		Args:  true,
+		// --var values may contain commas, e.g. --var 'tags=["a","b"]'.
+		DisableSliceFlagSeparator: true,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:  "timestamps",
@@ -29,6 +171,123 @@ func main() {
 				Value: 1,
 				Usage: "verbosity level",
 			},
+			&cli.StringFlag{
+				Name:  "ca-file",
+				Usage: "PEM bundle of additional certificate authorities to trust for HTTPS",
+			},
+			&cli.StringFlag{
+				Name:  "client-cert",
+				Usage: "PEM client certificate for mutual TLS",
+			},
+			&cli.StringFlag{
+				Name:  "client-key",
+				Usage: "PEM client key for mutual TLS",
+			},
+			&cli.StringFlag{
+				Name:  "proxy",
+				Usage: "HTTP(S) proxy url, overriding HTTP_PROXY/HTTPS_PROXY",
+			},
+			&cli.DurationFlag{
+				Name:  "http-timeout",
+				Usage: "timeout for each HTTP request, e.g. 30s (0 means no timeout)",
+			},
+			&cli.StringFlag{
+				Name:  "cache-dir",
+				Usage: "directory of the content-addressed download cache (default: the user cache directory)",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "do not serve or store downloads in the cache",
+			},
+			&cli.BoolFlag{
+				Name:  "trace-loads",
+				Usage: "print the graph of loaded modules to stderr",
+			},
+			&cli.StringSliceFlag{
+				Name:  "var",
+				Usage: "set an input variable, e.g. --var region=eu (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "var-file",
+				Usage: "read input variables from a JSON or YAML file (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "locked",
+				Usage: "fail if a remote module is missing from starcm.lock or resolves to a different hash",
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "mod",
+				Usage: "manage remote modules",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "vendor",
+						Usage: "copy the remote modules the workspace loads into .starcm/vendor and prune starcm.lock",
+						Action: func(c *cli.Context) error {
+							ctx := context.Background()
+							fsys := afero.NewOsFs()
+							wd, cfg, err := loadWorkspace(c, fsys, ".")
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							lf, err := lockfile.Load(fsys, lockfilePath(wd))
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							l, err := newLoader(ctx, c, fsys, wd, cfg, lf)
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							written, pruned, err := l.Vendor(ctx)
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							if lf.Changed() {
+								if err := lf.Save(fsys, lockfilePath(wd)); err != nil {
+									return cli.Exit(err.Error(), 1)
+								}
+							}
+							for _, pin := range pruned {
+								fmt.Printf("removed unused module %s from %s\n", pin, lockfile.Name)
+							}
+							fmt.Printf("vendored %d modules into %s\n", len(written), filepath.Join(wd, filepath.FromSlash(loader.VendorDir)))
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "manage the download cache",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "prune",
+						Usage: "remove cached downloads",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "older-than",
+								Usage: "only remove entries unused for longer than this, e.g. 720h (default: remove everything)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if _, _, err := loadWorkspace(c, afero.NewOsFs(), "."); err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							dir, err := cacheDir(c)
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							result, err := cache.New(afero.NewOsFs(), dir).Prune(c.Duration("older-than"))
+							if err != nil {
+								return cli.Exit(err.Error(), 1)
+							}
+							fmt.Printf("removed %d entries (%d bytes) from %s\n", result.Removed, result.Freed, dir)
+							return nil
+						},
+					},
+				},
+			},
 		},
 		Action: func(c *cli.Context) error {
 			if c.NArg() < 1 {
@@ -36,6 +295,14 @@ func main() {
 			}
 
 			rootFile := c.Args().First()
+			fsys := afero.NewOsFs()
+
+			// starcm.toml may set defaults for the flags below, so it is loaded first.
+			wd, cfg, err := loadWorkspace(c, fsys, filepath.Dir(rootFile))
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+
 			timestamps := c.Bool("timestamps")
 			verbosity := c.Int("v")
 
@@ -50,19 +317,14 @@ func main() {
 
 			ctx := context.Background()
 
-			fsys := afero.NewOsFs()
-
-			wd, err := os.Getwd()
+			lf, err := lockfile.Load(fsys, lockfilePath(wd))
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			starcmLoader, err := newLoader(ctx, c, fsys, wd, cfg, lf)
 			if err != nil {
 				return cli.Exit(err.Error(), 1)
 			}
-
-			starcmLoader := loader.Default(
-				ctx,
-				fsys,
-				&shell.RealExecutor{},
-				wd,
-			)
 
 			b, err := afero.ReadFile(fsys, rootFile)
 			if err != nil {
@@ -79,6 +341,12 @@ func main() {
 				return cli.Exit(err.Error(), 1)
 			}
 
+			if lf.Changed() {
+				if err := lf.Save(fsys, lockfilePath(wd)); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+			}
+
 			return nil
 		},
 	}
@@ -0,0 +1,215 @@
+package starlarkhelpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// ToGo converts a Starlark value into plain Go values, recursing into containers:
+//
+//	None              -> nil
+//	bool              -> bool
+//	int               -> int64, or *big.Int if it does not fit
+//	float             -> float64
+//	string            -> string
+//	bytes             -> []byte
+//	list, tuple, set  -> []any
+//	dict, struct      -> map[string]any
+//
+// Dict keys must be strings. Values of any other type, such as functions, are an error.
+func ToGo(v starlark.Value) (any, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	case *starlark.List:
+		return iterableToGo(v, v.Len())
+	case starlark.Tuple:
+		return iterableToGo(v, v.Len())
+	case *starlark.Set:
+		return iterableToGo(v, v.Len())
+	case *starlark.Dict:
+		m := make(map[string]any, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			value, err := ToGo(item[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key.GoString(), err)
+			}
+			m[string(key)] = value
+		}
+		return m, nil
+	case *starlarkstruct.Struct:
+		return attrsToGo(v)
+	case *starlarkstruct.Module:
+		return attrsToGo(v)
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Go value", v.Type())
+	}
+}
+
+func iterableToGo(v starlark.Iterable, n int) ([]any, error) {
+	list := make([]any, 0, n)
+	iter := v.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for i := 0; iter.Next(&x); i++ {
+		value, err := ToGo(x)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+func attrsToGo(v starlark.HasAttrs) (map[string]any, error) {
+	names := v.AttrNames()
+	m := make(map[string]any, len(names))
+	for _, name := range names {
+		attr, err := v.Attr(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := ToGo(attr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// FromGo converts a Go value into a Starlark value. It is the inverse of ToGo: maps with string keys
+// become dicts, slices and arrays become lists, and all integer and float kinds are supported.
+// A starlark.Value is returned unchanged.
+func FromGo(v any) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case starlark.Value:
+		return v, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []byte:
+		return starlark.Bytes(v), nil
+	case *big.Int:
+		return starlark.MakeBigInt(v), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return starlark.MakeInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return starlark.MakeUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return starlark.Float(rv.Float()), nil
+	case reflect.String:
+		return starlark.String(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return starlark.None, nil
+		}
+		elems := make([]starlark.Value, rv.Len())
+		for i := range elems {
+			elem, err := FromGo(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			elems[i] = elem
+		}
+		return starlark.NewList(elems), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings, got %s", rv.Type().Key())
+		}
+		if rv.IsNil() {
+			return starlark.None, nil
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		// Sort so that iterating over the dict in Starlark is deterministic.
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(keys))
+		for _, k := range keys {
+			value, err := FromGo(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if err := dict.SetKey(starlark.String(k), value); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return starlark.None, nil
+		}
+		return FromGo(rv.Elem().Interface())
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a Starlark value", v)
+	}
+}
+
+// DecodeJSON decodes data like json.Unmarshal into an any, except that integers become int64 rather than
+// float64, so that FromGo turns them into Starlark ints.
+func DecodeJSON(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var value any
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return numbers(value), nil
+}
+
+// numbers replaces the json.Numbers in v with int64 or float64.
+func numbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = numbers(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = numbers(v[k])
+		}
+	}
+	return v
+}
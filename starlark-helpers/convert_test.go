@@ -0,0 +1,165 @@
+package starlarkhelpers
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func TestToGo(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	users := starlark.NewDict(2)
+	require.NoError(t, users.SetKey(starlark.String("name"), starlark.String("alice")))
+	require.NoError(t, users.SetKey(starlark.String("uid"), starlark.MakeInt(1000)))
+	intKeys := starlark.NewDict(1)
+	require.NoError(t, intKeys.SetKey(starlark.MakeInt(1), starlark.String("one")))
+
+	tests := []struct {
+		name     string
+		value    starlark.Value
+		expected any
+		wantErr  bool
+	}{
+		{name: "none", value: starlark.None, expected: nil},
+		{name: "bool", value: starlark.True, expected: true},
+		{name: "int", value: starlark.MakeInt(42), expected: int64(42)},
+		{name: "big int", value: starlark.MakeBigInt(huge), expected: huge},
+		{name: "float", value: starlark.Float(1.5), expected: 1.5},
+		{name: "string", value: starlark.String("hi"), expected: "hi"},
+		{name: "bytes", value: starlark.Bytes("hi"), expected: []byte("hi")},
+		{
+			name:     "tuple",
+			value:    starlark.Tuple{starlark.MakeInt(1), starlark.None},
+			expected: []any{int64(1), nil},
+		},
+		{
+			name:  "list of dicts",
+			value: starlark.NewList([]starlark.Value{users}),
+			expected: []any{
+				map[string]any{"name": "alice", "uid": int64(1000)},
+			},
+		},
+		{
+			name: "struct",
+			value: starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+				"enabled": starlark.True,
+				"ports":   starlark.NewList([]starlark.Value{starlark.MakeInt(80), starlark.MakeInt(443)}),
+			}),
+			expected: map[string]any{"enabled": true, "ports": []any{int64(80), int64(443)}},
+		},
+		{name: "non-string dict key", value: intKeys, wantErr: true},
+		{name: "function", value: starlark.NewBuiltin("f", nil), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToGo(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected string
+		wantErr  bool
+	}{
+		{name: "nil", value: nil, expected: "None"},
+		{name: "bool", value: false, expected: "False"},
+		{name: "int", value: 7, expected: "7"},
+		{name: "uint8", value: uint8(7), expected: "7"},
+		{name: "float32", value: float32(0.5), expected: "0.5"},
+		{name: "string", value: "hi", expected: `"hi"`},
+		{name: "string slice", value: []string{"a", "b"}, expected: `["a", "b"]`},
+		{
+			name:     "nested map",
+			value:    map[string]any{"b": []any{map[string]string{"k": "v"}}, "a": nil},
+			expected: `{"a": None, "b": [{"k": "v"}]}`,
+		},
+		{name: "starlark value", value: starlark.MakeInt(3), expected: "3"},
+		{name: "non-string map key", value: map[int]string{1: "one"}, wantErr: true},
+		{name: "channel", value: make(chan int), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromGo(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, got.String())
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := map[string]any{
+		"hosts": []any{
+			map[string]any{"name": "web", "port": int64(80), "tls": false},
+			map[string]any{"name": "db", "port": int64(5432), "weight": 0.5},
+		},
+		"owner": nil,
+	}
+	v, err := FromGo(in)
+	require.NoError(t, err)
+	out, err := ToGo(v)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+}
+
+func TestDictToGoMap(t *testing.T) {
+	d := starlark.NewDict(1)
+	require.NoError(t, d.SetKey(starlark.String("port"), starlark.MakeInt(80)))
+	m, err := DictToGoMap(d)
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"port": int64(80)}, m)
+
+	require.NoError(t, d.SetKey(starlark.MakeInt(1), starlark.True))
+	_, err = DictToGoMap(d)
+	require.ErrorContains(t, err, "dict keys must be strings")
+
+	d = starlark.NewDict(1)
+	require.NoError(t, d.SetKey(starlark.String("f"), starlark.NewBuiltin("f", nil)))
+	_, err = DictToGoMap(d)
+	require.Error(t, err)
+}
+
+func TestGoDictToStarlarkDict(t *testing.T) {
+	d, err := GoDictToStarlarkDict(map[string]any{"n": 1, "env": map[string]any{"a": "b"}})
+	require.NoError(t, err)
+	n, found, err := d.Get(starlark.String("n"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, starlark.MakeInt(1), n)
+	env, found, err := d.Get(starlark.String("env"))
+	require.NoError(t, err)
+	require.True(t, found)
+	require.IsType(t, &starlark.Dict{}, env)
+
+	_, err = GoDictToStarlarkDict(map[string]any{"n": 1, "ch": make(chan int)})
+	require.ErrorContains(t, err, `"ch"`)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	got, err := DecodeJSON([]byte(`{"n": 3, "f": 1.5, "big": 1e3, "list": [1, {"x": 2}]}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{
+		"n":    int64(3),
+		"f":    1.5,
+		"big":  1000.0,
+		"list": []any{int64(1), map[string]any{"x": int64(2)}},
+	}, got)
+
+	_, err = DecodeJSON([]byte(`{} {}`))
+	require.ErrorContains(t, err, "unexpected data")
+}
@@ -1,11 +1,28 @@
 package starlarkhelpers
 
-import "go.starlark.net/starlark"
+import (
+	"fmt"
+	"sort"
 
-func GoDictToStarlarkDict(dict map[string]any) *starlark.Dict {
+	"go.starlark.net/starlark"
+)
+
+// GoDictToStarlarkDict converts each value of dict with FromGo, in sorted key order.
+func GoDictToStarlarkDict(dict map[string]any) (*starlark.Dict, error) {
+	keys := make([]string, 0, len(dict))
+	for key := range dict {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	starlarkDict := starlark.NewDict(len(dict))
-	for key, value := range dict {
-		starlarkDict.SetKey(starlark.String(key), starlark.String(value.(string)))
+	for _, key := range keys {
+		value, err := FromGo(dict[key])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", key, err)
+		}
+		if err := starlarkDict.SetKey(starlark.String(key), value); err != nil {
+			return nil, err
+		}
 	}
-	return starlarkDict
+	return starlarkDict, nil
 }
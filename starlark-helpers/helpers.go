@@ -95,6 +95,22 @@ func FindStringInKwargs(kwargs []starlark.Tuple, value string) (*string, error)
 	return &s, nil
 }
 
+// FindOptionalStringInKwargs returns nil if value is absent or None, and an error if it is not a string.
+func FindOptionalStringInKwargs(kwargs []starlark.Tuple, value string) (*string, error) {
+	v, err := FindRawValueInKwargs(kwargs, value)
+	if err != nil && !errors.Is(err, ErrIndexNotFound) {
+		return nil, err
+	}
+	if v == nil || v == starlark.None {
+		return nil, nil
+	}
+	s, ok := starlark.AsString(v)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a string, got %s", value, v.Type())
+	}
+	return &s, nil
+}
+
 func FindIntInKwargs(kwargs []starlark.Tuple, value string, defaultValue int64) (int64, error) {
 	v, err := FindRawValueInKwargs(kwargs, value)
 	if err != nil {
@@ -140,38 +156,13 @@ var unesc = [256]byte{
 	'"':  '"',
 }
 
-func ValueToGo(value starlark.Value) any {
-	switch value := value.(type) {
-	case starlark.String:
-		return string(value)
-	case starlark.Int:
-		i, _ := value.Int64()
-		return i
-	case starlark.Float:
-		return float64(value)
-	case starlark.Bool:
-		return bool(value)
-	case *starlark.List:
-		list := make([]interface{}, value.Len())
-		for i := 0; i < value.Len(); i++ {
-			list[i] = ValueToGo(value.Index(i))
-		}
-		return list
-	case *starlark.Dict:
-		return DictToGoMap(value)
-	default:
-		return value
-	}
-}
-
-func DictToGoMap(dict *starlark.Dict) map[string]interface{} {
-	result := make(map[string]interface{})
-	for _, item := range dict.Items() {
-		key := item[0].(starlark.String)
-		value := ValueToGo(item[1])
-		result[string(key)] = value
+// DictToGoMap converts dict with ToGo. Keys must be strings, and every value must be convertible.
+func DictToGoMap(dict *starlark.Dict) (map[string]any, error) {
+	v, err := ToGo(dict)
+	if err != nil {
+		return nil, err
 	}
-	return result
+	return v.(map[string]any), nil
 }
 
 func OptionalKeyword(kw starlark.String) starlark.String {